@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"false-fact-server/factcheck"
+)
+
+const analyzeUsage = `Usage: false-fact-server analyze article|long|short [flags]
+
+Analyzes the text in -file, or on stdin, with the configured MODEL and prints
+a report, or the JSON response with -json. The configuration is read from
+-config (or CONFIG_FILE), .env and the environment, as for the server.
+
+Flags:
+`
+
+// Runs "analyze <kind>" and returns the exit code: 1 if the analysis failed,
+// 2 for usage and configuration errors
+func runAnalyze(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flagSet := flag.NewFlagSet("analyze", flag.ContinueOnError)
+	flagSet.SetOutput(stderr)
+	flagSet.Usage = func() {
+		fmt.Fprint(stderr, analyzeUsage)
+		flagSet.PrintDefaults()
+	}
+	file := flagSet.String("file", "", "File with the text to analyze; stdin if empty or -")
+	title := flagSet.String("title", "", "Article title (article only)")
+	url := flagSet.String("url", "", "Article URL (article only)")
+	lastEdited := flagSet.String("last-edited", "", "When the article was last edited, e.g. 2025-08-01 (article only)")
+	language := flagSet.String("language", "", "ISO 639-1 code of the text's language, e.g. es; detected if empty")
+	responseLanguage := flagSet.String("response-language", "", "ISO 639-1 code of the language to write the reasons in; the text's if empty")
+	jsonOutput := flagSet.Bool("json", false, "Print the JSON response instead of a report")
+	colorMode := flagSet.String("color", "auto", "Color the report: auto, always or never")
+	configFile := flagSet.String("config", "", "YAML config file (or CONFIG_FILE)")
+	model := flagSet.String("model", "", "Provider to use instead of MODEL")
+	verbose := flagSet.Bool("verbose", false, "Log debug output to stderr")
+
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		flagSet.Usage()
+		return 2
+	}
+	kind := args[0]
+	if kind != "article" && kind != "long" && kind != "short" {
+		fmt.Fprintf(stderr, "analyze: unknown kind '%s', use article, long or short\n", kind)
+		return 2
+	}
+	if err := flagSet.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if flagSet.NArg() > 0 {
+		fmt.Fprintf(stderr, "analyze: unexpected arguments %v\n", flagSet.Args())
+		return 2
+	}
+	if kind != "article" && (*title != "" || *url != "" || *lastEdited != "") {
+		fmt.Fprintln(stderr, "analyze: -title, -url and -last-edited are only used with article")
+		return 2
+	}
+	var edited time.Time
+	if *lastEdited != "" {
+		var err error
+		if edited, err = parseLastEdited(*lastEdited); err != nil {
+			fmt.Fprintf(stderr, "analyze: %v\n", err)
+			return 2
+		}
+	}
+	for name, code := range map[string]string{"-language": *language, "-response-language": *responseLanguage} {
+		if code != "" && factcheck.LanguageName(code) == "" {
+			fmt.Fprintf(stderr, "analyze: %s: '%s' is not the ISO 639-1 code of a supported language\n", name, code)
+			return 2
+		}
+	}
+	languages := factcheck.Languages{Content: *language, Response: *responseLanguage}
+	color, err := useColor(*colorMode, stdout)
+	if err != nil {
+		fmt.Fprintf(stderr, "analyze: %v\n", err)
+		return 2
+	}
+
+	content, err := readContent(*file, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "analyze: %v\n", err)
+		return 2
+	}
+	content = strings.TrimSpace(content)
+	if content == "" {
+		fmt.Fprintln(stderr, "analyze: there is no text to analyze")
+		return 2
+	}
+
+	// Nothing listens, so PORT needn't be configured
+	configArgs := []string{"-port", "0"}
+	if *configFile != "" {
+		configArgs = append(configArgs, "-config", *configFile)
+	}
+	if *model != "" {
+		configArgs = append(configArgs, "-model", *model)
+	}
+	cfg, _, err := LoadConfig(configArgs, os.LookupEnv, ".env")
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	// Logs go to stderr so that the output stays clean
+	level := "warn"
+	if *verbose {
+		level = "debug"
+	}
+	logger, err := factcheck.NewLogger(stderr, level, "text")
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	factcheck.LogContent = cfg.LogContent
+	factcheck.LogContentLimit = cfg.LogContentLimit
+	slog.SetDefault(logger)
+
+	if err := configureAnalysis(cfg); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	state, err := buildRuntime(cfg)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	variant := state.experiments.Default
+
+	// Ctrl-C cancels the provider calls
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	var result any
+	switch kind {
+	case "article":
+		result, err = factcheck.AiAnalyzeArticle(ctx, content, *title, *url, edited, languages, variant)
+	case "long":
+		result, err = factcheck.AiAnalyzeTextLong(ctx, content, languages, variant)
+	case "short":
+		result, err = factcheck.AiAnalyzeTextShort(ctx, content, languages, variant)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "analysis failed: %v\n", err)
+		var extErr *factcheck.ExtensionError
+		if errors.As(err, &extErr) && extErr.UserMessage != "" {
+			fmt.Fprintln(stderr, extErr.UserMessage)
+		}
+		return 1
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(result)
+		return 0
+	}
+	r := &report{w: stdout, color: color}
+	switch result := result.(type) {
+	case *factcheck.AnalysisResponse:
+		r.analysis(result)
+	case *factcheck.ShortAnalysisResponse:
+		r.shortAnalysis(result)
+	}
+	return 0
+}
+
+// Reads the whole file, or stdin for "" and "-"
+func readContent(path string, stdin io.Reader) (string, error) {
+	if path == "" || path == "-" {
+		data, err := io.ReadAll(stdin)
+		return string(data), err
+	}
+	data, err := os.ReadFile(path)
+	return string(data), err
+}
+
+// Accepts a date or an RFC 3339 time
+func parseLastEdited(s string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("-last-edited: '%s' is not a date like 2025-08-01 or an RFC 3339 time", s)
+	}
+	return t, nil
+}
+
+// With "auto", color is used when stdout is a terminal and NO_COLOR is not set
+func useColor(mode string, stdout io.Writer) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		if _, ok := os.LookupEnv("NO_COLOR"); ok {
+			return false, nil
+		}
+		f, ok := stdout.(*os.File)
+		if !ok {
+			return false, nil
+		}
+		info, err := f.Stat()
+		return err == nil && info.Mode()&os.ModeCharDevice != 0, nil
+	}
+	return false, fmt.Errorf("-color: '%s' is not one of auto, always, never", mode)
+}
+
+// ANSI escape codes used in reports
+const (
+	ansiReset  = "\033[0m"
+	ansiBold   = "\033[1m"
+	ansiDim    = "\033[2m"
+	ansiRed    = "\033[31m"
+	ansiGreen  = "\033[32m"
+	ansiYellow = "\033[33m"
+	ansiCyan   = "\033[36m"
+)
+
+// Human-readable analysis output
+type report struct {
+	w     io.Writer
+	color bool
+}
+
+func (r *report) paint(code string, s string) string {
+	if !r.color {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+// Green for high scores, yellow for middling and red for low ones
+func (r *report) score(score int) string {
+	code := ansiRed
+	switch {
+	case score >= 70:
+		code = ansiGreen
+	case score >= 40:
+		code = ansiYellow
+	}
+	return r.paint(ansiBold+code, fmt.Sprintf("%d/100", score))
+}
+
+func (r *report) analysis(a *factcheck.AnalysisResponse) {
+	fmt.Fprintf(r.w, "%s %s\n", r.paint(ansiBold, "Credibility:"), r.score(a.CredibilityScore))
+	fmt.Fprintf(r.w, "Factuality %d, objectivity %d, confidence %d\n", a.Categories.Factuality, a.Categories.Objectivity, a.Confidence)
+	r.list("Factual", "+", ansiGreen, a.Reasoning.Factual)
+	r.list("Unfactual", "-", ansiRed, a.Reasoning.Unfactual)
+	r.list("Subjective", "~", ansiYellow, a.Reasoning.Subjective)
+	r.list("Objective", "*", ansiCyan, a.Reasoning.Objective)
+	r.footer(a.StructuredSources, a.CitationWarnings, a.Usage, a.PromptVersion)
+}
+
+func (r *report) shortAnalysis(a *factcheck.ShortAnalysisResponse) {
+	code := map[factcheck.Verdict]string{
+		factcheck.VerdictFact:    ansiGreen,
+		factcheck.VerdictFalse:   ansiRed,
+		factcheck.VerdictOpinion: ansiYellow,
+		factcheck.VerdictNone:    ansiDim,
+	}[a.Verdict]
+	fmt.Fprintf(r.w, "%s %s (confidence %d)\n", r.paint(ansiBold, "Verdict:"), r.paint(ansiBold+code, strings.ToUpper(string(a.Verdict))), a.Confidence)
+	fmt.Fprintf(r.w, "\n%s\n", a.Reason)
+	r.footer(a.StructuredSources, a.CitationWarnings, a.Usage, a.PromptVersion)
+}
+
+// A heading and its items, skipped when empty
+func (r *report) list(heading string, marker string, code string, items []string) {
+	if len(items) == 0 {
+		return
+	}
+	fmt.Fprintf(r.w, "\n%s\n", r.paint(ansiBold, heading))
+	for _, item := range items {
+		fmt.Fprintf(r.w, "  %s %s\n", r.paint(code, marker), item)
+	}
+}
+
+func (r *report) footer(sources []factcheck.Source, warnings []string, usage *factcheck.UsageReport, promptVersion string) {
+	if len(sources) > 0 {
+		fmt.Fprintf(r.w, "\n%s\n", r.paint(ansiBold, "Sources"))
+		for _, s := range sources {
+			line := fmt.Sprintf("[%d] %s", s.ID, s.URL)
+			if s.Title != "" {
+				line = fmt.Sprintf("[%d] %s %s", s.ID, s.Title, r.paint(ansiDim, s.URL))
+			}
+			if !s.Referenced {
+				line += r.paint(ansiYellow, " (not cited)")
+			}
+			if s.Check != nil && !s.Check.Reliable {
+				line += r.paint(ansiRed, " (unreliable)")
+			}
+			fmt.Fprintf(r.w, "  %s\n", line)
+		}
+	}
+	r.list("Warnings", "!", ansiYellow, warnings)
+	meta := "prompt " + promptVersion
+	if usage != nil {
+		meta += fmt.Sprintf(", %d tokens, $%.4f", usage.TotalTokens, usage.CostUSD)
+	}
+	fmt.Fprintf(r.w, "\n%s\n", r.paint(ansiDim, meta))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"false-fact-server/factcheck"
+)
+
+// Runs the analyze command against the fixtures of setupReplay
+func analyzeCommand(t *testing.T, stdin string, args ...string) (code int, stdout string, stderr string) {
+	t.Helper()
+	t.Setenv("MODEL", "replay")
+	t.Setenv("REPLAY_DIR", current.Load().cfg.ReplayDir)
+	logger := slog.Default()
+	defer slog.SetDefault(logger)
+
+	var out, errOut bytes.Buffer
+	code = runAnalyze(args, strings.NewReader(stdin), &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+func TestAnalyzeCommandShort(t *testing.T) {
+	setupReplay(t, replayCase{kind: factcheck.PromptTextShort, data: factcheck.PromptData{Content: "some text"}, response: shortResponse})
+
+	code, out, errOut := analyzeCommand(t, "some text\n", "short", "-json")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, errOut)
+	}
+	var result factcheck.ShortAnalysisResponse
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, out)
+	}
+	if result.Verdict != factcheck.VerdictFact || result.Confidence != 97 {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	code, out, _ = analyzeCommand(t, "some text", "short", "-color", "never")
+	if code != 0 || !strings.Contains(out, "Verdict: FACT (confidence 97)") || !strings.Contains(out, "https://en.wikipedia.org/wiki/Boiling_point") {
+		t.Errorf("exit code %d, report:\n%s", code, out)
+	}
+	if strings.Contains(out, "\033[") {
+		t.Error("-color never printed escape codes")
+	}
+	if _, out, _ = analyzeCommand(t, "some text", "short", "-color", "always"); !strings.Contains(out, "\033[32m") {
+		t.Errorf("-color always did not color the verdict:\n%q", out)
+	}
+}
+
+func TestAnalyzeCommandArticle(t *testing.T) {
+	data := factcheck.PromptData{Content: "Apollo 11 landed on the Moon in 1969.", Title: "Moon landing", URL: "https://example.com/apollo", Language: "English", ResponseLanguage: "English"}
+	setupReplay(t, replayCase{kind: factcheck.PromptArticle, data: data, response: articleResponse})
+	file := writeFile(t, t.TempDir(), "article.txt", data.Content+"\n")
+
+	code, out, errOut := analyzeCommand(t, "", "article", "--file", file, "--title", data.Title, "--url", data.URL, "--color", "never")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, errOut)
+	}
+	for _, want := range []string{"Credibility: 95/100", "Factuality 97, objectivity 92, confidence 90", "+ Landing date matches NASA records [1].", "[1] nasa.gov https://www.nasa.gov/mission/apollo-11/", "prompt article-v2"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report does not contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestAnalyzeCommandErrors(t *testing.T) {
+	setupReplay(t)
+	tests := []struct {
+		name  string
+		stdin string
+		args  []string
+		code  int
+	}{
+		{"no kind", "text", nil, 2},
+		{"unknown kind", "text", []string{"tweet"}, 2},
+		{"no text", " \n", []string{"short"}, 2},
+		{"title for short text", "text", []string{"short", "-title", "x"}, 2},
+		{"bad date", "text", []string{"article", "-last-edited", "yesterday"}, 2},
+		{"unknown language", "text", []string{"short", "-response-language", "xx"}, 2},
+		{"missing file", "", []string{"long", "-file", filepath.Join(t.TempDir(), "missing.txt")}, 2},
+		{"unknown model", "text", []string{"short", "-model", "nonexistent"}, 2},
+		{"analysis fails", "no fixture for this", []string{"short"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, errOut := analyzeCommand(t, tt.stdin, tt.args...)
+			if code != tt.code {
+				t.Errorf("exit code %d, want %d (%s)", code, tt.code, errOut)
+			}
+		})
+	}
+}
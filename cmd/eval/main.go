@@ -0,0 +1,539 @@
+// Offline evaluation of the analysis prompts against a labeled dataset.
+//
+//	go run ./cmd/eval -dataset cmd/eval/datasets/sample.jsonl -provider mock
+//
+// Each dataset line is a JSON object:
+//
+//	{"id": "...", "type": "short", "text": "...", "expected": "fact"}
+//	{"id": "...", "type": "article", "title": "...", "text": "...", "expectedScore": [0, 40]}
+//
+// "type" is short (default), long or article. Short examples are labeled with the
+// expected verdict (fact, false, opinion or none); long texts and articles with the
+// range the credibilityScore should fall in. An optional "response" holds a raw model
+// output that the mock provider returns for that example. Exchanges saved with -record
+// can be evaluated again offline with -provider replay -fixtures <dir>.
+//
+// -fit-calibration isotonic|platt fits a confidence calibration per prompt version from
+// the results and writes it to the -calibration file, which the server reads from
+// CALIBRATION_FILE. Without fitting, -calibration applies an existing file instead.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"false-fact-server/factcheck"
+
+	"github.com/joho/godotenv"
+)
+
+type Example struct {
+	ID            string  `json:"id"`
+	Type          string  `json:"type"`
+	Text          string  `json:"text"`
+	Title         string  `json:"title"`
+	URL           string  `json:"url"`
+	Expected      string  `json:"expected"`
+	ExpectedScore *[2]int `json:"expectedScore"`
+	Response      string  `json:"response"`
+}
+
+var verdictLabels = []string{"fact", "false", "opinion", "none"}
+
+// The outcome of running one example
+type Result struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	Expected   string `json:"expected"`
+	Predicted  string `json:"predicted"`
+	Score      *int   `json:"score,omitempty"`
+	Confidence int    `json:"confidence"`
+	// Confidence before calibration
+	RawConfidence int    `json:"rawConfidence"`
+	PromptVersion string `json:"promptVersion"`
+	Correct       bool   `json:"correct"`
+	// "parse" or "upstream" when the analysis failed
+	Failure   string `json:"failure,omitempty"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latencyMs"`
+}
+
+type CalibrationBin struct {
+	Range          string  `json:"range"`
+	Count          int     `json:"count"`
+	MeanConfidence float64 `json:"meanConfidence"`
+	Accuracy       float64 `json:"accuracy"`
+}
+
+type Report struct {
+	Provider      string `json:"provider"`
+	Examples      int    `json:"examples"`
+	Scored        int    `json:"scored"`
+	Correct       int    `json:"correct"`
+	ParseFailures int    `json:"parseFailures"`
+	UpstreamErrs  int    `json:"upstreamErrors"`
+	// Accuracy over examples that got an analysis back
+	Accuracy         float64 `json:"accuracy"`
+	ParseFailureRate float64 `json:"parseFailureRate"`
+	// Expected verdict -> predicted verdict -> count, for short examples
+	Confusion map[string]map[string]int `json:"confusion"`
+	// Expected calibration error and Brier score of Confidence against correctness
+	Calibration    []CalibrationBin  `json:"calibration"`
+	ECE            float64           `json:"ece"`
+	Brier          float64           `json:"brier"`
+	PromptVersions map[string]string `json:"promptVersions"`
+	Results        []Result          `json:"results"`
+}
+
+// Returns canned responses from the dataset, for running without network
+type mockProvider struct {
+	responses map[string]string
+}
+
+func (m *mockProvider) Name() string { return "mock" }
+
+// Answers with the response of the longest example text contained in the prompt
+func (m *mockProvider) Call(ctx context.Context, systemPrompt string, userPrompt string) (factcheck.Completion, error) {
+	best := ""
+	for text := range m.responses {
+		if strings.Contains(userPrompt, text) && len(text) > len(best) {
+			best = text
+		}
+	}
+	if best == "" {
+		return factcheck.Completion{}, fmt.Errorf("mock provider has no response for this prompt")
+	}
+	return factcheck.Completion{Text: m.responses[best], Model: "mock"}, nil
+}
+
+func main() {
+	datasetPath := flag.String("dataset", "", "Path to the labeled JSONL dataset")
+	providerName := flag.String("provider", "", "Provider to evaluate (gemini, pollinations, mock or replay); defaults to MODEL")
+	fixturesDir := flag.String("fixtures", "", "Directory of recorded exchanges for -provider replay")
+	recordDir := flag.String("record", "", "Save every provider exchange to this directory")
+	promptsDir := flag.String("prompts", "", "Directory with prompt template overrides")
+	concurrency := flag.Int("concurrency", 2, "Number of examples analyzed at once")
+	jsonOutput := flag.Bool("json", false, "Print the report as JSON")
+	minAccuracy := flag.Float64("min-accuracy", 0, "Exit with status 1 if accuracy is below this (0-1)")
+	maxParseFailures := flag.Float64("max-parse-failure-rate", 1, "Exit with status 1 if the parse-failure rate is above this (0-1)")
+	calibrationPath := flag.String("calibration", "", "Calibration file to apply, or to write with -fit-calibration")
+	fitMethod := flag.String("fit-calibration", "", "Fit a confidence calibration (isotonic or platt) and save it to -calibration")
+	calibrateAs := flag.String("calibrate-as", "", "Provider name to store fitted calibrations under; defaults to the evaluated provider")
+	verbose := flag.Bool("verbose", false, "Log debug output, including prompts and model output, to stderr")
+	flag.Parse()
+
+	if *datasetPath == "" {
+		fmt.Fprintln(os.Stderr, "eval: -dataset is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *fitMethod != "" && *fitMethod != "isotonic" && *fitMethod != "platt" {
+		fmt.Fprintf(os.Stderr, "eval: unknown -fit-calibration '%s', use isotonic or platt\n", *fitMethod)
+		os.Exit(2)
+	}
+	if *fitMethod != "" && *calibrationPath == "" {
+		fmt.Fprintln(os.Stderr, "eval: -fit-calibration needs a -calibration file to write")
+		os.Exit(2)
+	}
+	// The environment may already be set by CI, so a missing .env is fine
+	_ = godotenv.Load()
+	factcheck.RegisterProvider(factcheck.NewGeminiProvider(os.Getenv("GEMINI_API_KEY")))
+
+	// Logs go to stderr so that -json output stays clean
+	level := "warn"
+	if *verbose {
+		level = "debug"
+		factcheck.LogContent = "full"
+	}
+	logger, err := factcheck.NewLogger(os.Stderr, level, "text")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "eval: %v\n", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	examples, err := loadDataset(*datasetPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "eval: %v\n", err)
+		os.Exit(1)
+	}
+
+	name := *providerName
+	if name == "" {
+		name = os.Getenv("MODEL")
+	}
+	if strings.EqualFold(name, "mock") {
+		mock := &mockProvider{responses: map[string]string{}}
+		for _, ex := range examples {
+			if ex.Response != "" {
+				mock.responses[ex.Text] = ex.Response
+			}
+		}
+		factcheck.RegisterProvider(mock)
+	}
+	if *fixturesDir != "" {
+		factcheck.RegisterProvider(&factcheck.ReplayProvider{Dir: *fixturesDir})
+	}
+	provider, err := factcheck.ProviderByName(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "eval: %v\n", err)
+		os.Exit(1)
+	}
+	if *recordDir != "" {
+		provider = &factcheck.RecordingProvider{Inner: provider, Dir: *recordDir}
+	}
+	prompts, err := factcheck.LoadPrompts(*promptsDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "eval: %v\n", err)
+		os.Exit(1)
+	}
+	variant := factcheck.Variant{Name: "eval", Provider: provider, Prompts: prompts}
+
+	calibrations := factcheck.NewCalibrationSet()
+	if *calibrationPath != "" {
+		loaded, err := factcheck.LoadCalibrations(*calibrationPath)
+		switch {
+		case err == nil:
+			calibrations = loaded
+		case !errors.Is(err, os.ErrNotExist) || *fitMethod == "":
+			fmt.Fprintf(os.Stderr, "eval: %v\n", err)
+			os.Exit(1)
+		}
+		// When fitting, the report shows the confidence the calibration is fitted on
+		if *fitMethod == "" {
+			factcheck.Calibrations = calibrations
+		}
+	}
+
+	results := runExamples(examples, variant, max(*concurrency, 1))
+	report := buildReport(provider.Name(), results)
+	report.PromptVersions = map[string]string{}
+	for kind, version := range prompts.Versions() {
+		report.PromptVersions[string(kind)] = version
+	}
+
+	if *fitMethod != "" {
+		name := *calibrateAs
+		if name == "" {
+			name = provider.Name()
+		}
+		for _, c := range fitCalibrations(results, *fitMethod, name) {
+			calibrations.Set(c)
+			fmt.Fprintf(os.Stderr, "eval: fitted %s calibration for %s/%s on %d answers\n", c.Method, c.Provider, c.PromptVersion, c.Samples)
+		}
+		if err := calibrations.Save(*calibrationPath); err != nil {
+			fmt.Fprintf(os.Stderr, "eval: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		printReport(report)
+	}
+
+	if report.Accuracy < *minAccuracy || report.ParseFailureRate > *maxParseFailures {
+		os.Exit(1)
+	}
+}
+
+func loadDataset(path string) ([]Example, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var examples []Example
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var ex Example
+		if err := json.Unmarshal(scanner.Bytes(), &ex); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if ex.ID == "" {
+			ex.ID = fmt.Sprintf("line-%d", line)
+		}
+		if ex.Type == "" {
+			ex.Type = "short"
+		}
+		switch ex.Type {
+		case "short":
+			if !isVerdictLabel(ex.Expected) {
+				return nil, fmt.Errorf("%s:%d: short example needs expected to be one of %s", path, line, strings.Join(verdictLabels, ", "))
+			}
+		case "long", "article":
+			if ex.ExpectedScore == nil {
+				return nil, fmt.Errorf("%s:%d: %s example needs expectedScore", path, line, ex.Type)
+			}
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown type '%s'", path, line, ex.Type)
+		}
+		examples = append(examples, ex)
+	}
+	return examples, scanner.Err()
+}
+
+func isVerdictLabel(label string) bool {
+	for _, l := range verdictLabels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+func runExamples(examples []Example, variant factcheck.Variant, concurrency int) []Result {
+	results := make([]Result, len(examples))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, ex := range examples {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = runExample(ex, variant)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func runExample(ex Example, variant factcheck.Variant) Result {
+	result := Result{ID: ex.ID, Type: ex.Type, Expected: ex.Expected}
+	// Log lines of an example carry its ID
+	ctx := factcheck.WithRequestID(context.Background(), ex.ID)
+	if ex.ExpectedScore != nil {
+		result.Expected = fmt.Sprintf("%d-%d", ex.ExpectedScore[0], ex.ExpectedScore[1])
+	}
+
+	start := time.Now()
+	var err error
+	switch ex.Type {
+	case "short":
+		var resp *factcheck.ShortAnalysisResponse
+		resp, err = factcheck.AiAnalyzeTextShort(ctx, ex.Text, factcheck.Languages{}, variant)
+		if err == nil {
+			result.Predicted = string(resp.Verdict)
+			result.Confidence = resp.Confidence
+			result.RawConfidence = resp.RawConfidence
+			result.PromptVersion = resp.PromptVersion
+			result.Correct = result.Predicted == ex.Expected
+		}
+	case "long", "article":
+		var resp *factcheck.AnalysisResponse
+		if ex.Type == "long" {
+			resp, err = factcheck.AiAnalyzeTextLong(ctx, ex.Text, factcheck.Languages{}, variant)
+		} else {
+			resp, err = factcheck.AiAnalyzeArticle(ctx, ex.Text, ex.Title, ex.URL, time.Time{}, factcheck.Languages{}, variant)
+		}
+		if err == nil {
+			score := resp.CredibilityScore
+			result.Score = &score
+			result.Predicted = fmt.Sprint(score)
+			result.Confidence = resp.Confidence
+			result.RawConfidence = resp.RawConfidence
+			result.PromptVersion = resp.PromptVersion
+			result.Correct = score >= ex.ExpectedScore[0] && score <= ex.ExpectedScore[1]
+		}
+	}
+	result.LatencyMs = time.Since(start).Milliseconds()
+
+	if err != nil {
+		result.Error = err.Error()
+		var parseErr *factcheck.ParseError
+		if errors.As(err, &parseErr) {
+			result.Failure = "parse"
+		} else {
+			result.Failure = "upstream"
+		}
+	}
+	return result
+}
+
+// Minimum number of answers per prompt version to fit a calibration on
+const minCalibrationSamples = 10
+
+// Fits one calibration per prompt version from the raw confidence of the answered examples
+func fitCalibrations(results []Result, method string, provider string) []*factcheck.Calibration {
+	byVersion := map[string][]factcheck.CalibrationSample{}
+	for _, r := range results {
+		if r.Failure == "" {
+			byVersion[r.PromptVersion] = append(byVersion[r.PromptVersion], factcheck.CalibrationSample{Confidence: r.RawConfidence, Correct: r.Correct})
+		}
+	}
+
+	versions := []string{}
+	for version := range byVersion {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+
+	fitted := []*factcheck.Calibration{}
+	for _, version := range versions {
+		samples := byVersion[version]
+		if len(samples) < minCalibrationSamples {
+			fmt.Fprintf(os.Stderr, "eval: skipping calibration for %s, only %d answers (need %d)\n", version, len(samples), minCalibrationSamples)
+			continue
+		}
+		var c *factcheck.Calibration
+		if method == "platt" {
+			c = factcheck.FitPlatt(samples)
+		} else {
+			c = factcheck.FitIsotonic(samples)
+		}
+		c.Provider = provider
+		c.PromptVersion = version
+		fitted = append(fitted, c)
+	}
+	return fitted
+}
+
+func buildReport(provider string, results []Result) Report {
+	report := Report{
+		Provider:  provider,
+		Examples:  len(results),
+		Confusion: map[string]map[string]int{},
+		Results:   results,
+	}
+	for _, label := range verdictLabels {
+		report.Confusion[label] = map[string]int{}
+	}
+
+	var bins [10]struct {
+		count, correct, confidence int
+	}
+	var brier float64
+	for _, r := range results {
+		switch r.Failure {
+		case "parse":
+			report.ParseFailures++
+		case "upstream":
+			report.UpstreamErrs++
+		}
+		if r.Type == "short" {
+			predicted := r.Predicted
+			if r.Failure != "" {
+				predicted = "error"
+			}
+			report.Confusion[r.Expected][predicted]++
+		}
+		if r.Failure != "" {
+			continue
+		}
+
+		report.Scored++
+		correct := 0.0
+		if r.Correct {
+			report.Correct++
+			correct = 1
+		}
+		b := &bins[min(max(r.Confidence/10, 0), 9)]
+		b.count++
+		b.confidence += r.Confidence
+		if r.Correct {
+			b.correct++
+		}
+		brier += math.Pow(float64(r.Confidence)/100-correct, 2)
+	}
+
+	if report.Scored > 0 {
+		report.Accuracy = float64(report.Correct) / float64(report.Scored)
+		report.Brier = brier / float64(report.Scored)
+	}
+	// Parse failures are only possible when the provider answered
+	if answered := report.Examples - report.UpstreamErrs; answered > 0 {
+		report.ParseFailureRate = float64(report.ParseFailures) / float64(answered)
+	}
+
+	for i, b := range bins {
+		bin := CalibrationBin{Range: fmt.Sprintf("%d-%d", i*10, i*10+9), Count: b.count}
+		if i == 9 {
+			bin.Range = "90-100"
+		}
+		if b.count > 0 {
+			bin.MeanConfidence = float64(b.confidence) / float64(b.count)
+			bin.Accuracy = float64(b.correct) / float64(b.count)
+			report.ECE += float64(b.count) / float64(report.Scored) * math.Abs(bin.Accuracy-bin.MeanConfidence/100)
+		}
+		report.Calibration = append(report.Calibration, bin)
+	}
+	return report
+}
+
+func printReport(r Report) {
+	fmt.Printf("Provider: %s\n", r.Provider)
+	versions := []string{}
+	for kind, version := range r.PromptVersions {
+		versions = append(versions, kind+"="+version)
+	}
+	sort.Strings(versions)
+	fmt.Printf("Prompts:  %s\n\n", strings.Join(versions, ", "))
+
+	fmt.Printf("Examples:           %d\n", r.Examples)
+	fmt.Printf("Accuracy:           %.1f%% (%d/%d)\n", r.Accuracy*100, r.Correct, r.Scored)
+	fmt.Printf("Parse failure rate: %.1f%% (%d)\n", r.ParseFailureRate*100, r.ParseFailures)
+	fmt.Printf("Upstream errors:    %d\n\n", r.UpstreamErrs)
+
+	fmt.Println("Confusion matrix (short texts, rows = expected):")
+	columns := append(append([]string{}, verdictLabels...), "error")
+	fmt.Printf("%-10s", "")
+	for _, c := range columns {
+		fmt.Printf("%8s", c)
+	}
+	fmt.Println()
+	for _, expected := range verdictLabels {
+		fmt.Printf("%-10s", expected)
+		for _, c := range columns {
+			fmt.Printf("%8d", r.Confusion[expected][c])
+		}
+		fmt.Println()
+	}
+
+	fmt.Printf("\nConfidence calibration (ECE %.3f, Brier %.3f):\n", r.ECE, r.Brier)
+	fmt.Printf("%-10s%8s%12s%10s\n", "range", "count", "confidence", "accuracy")
+	for _, b := range r.Calibration {
+		if b.Count == 0 {
+			continue
+		}
+		fmt.Printf("%-10s%8d%11.1f%%%9.1f%%\n", b.Range, b.Count, b.MeanConfidence, b.Accuracy*100)
+	}
+
+	failed := []Result{}
+	for _, res := range r.Results {
+		if !res.Correct {
+			failed = append(failed, res)
+		}
+	}
+	if len(failed) > 0 {
+		fmt.Println("\nIncorrect or failed:")
+		for _, res := range failed {
+			if res.Failure != "" {
+				fmt.Printf("  %s: %s failure: %s\n", res.ID, res.Failure, res.Error)
+			} else {
+				fmt.Printf("  %s: expected %s, got %s\n", res.ID, res.Expected, res.Predicted)
+			}
+		}
+	}
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"false-fact-server/factcheck"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Server configuration. Each field is read from, in increasing precedence: its default,
+// the YAML config file (-config or CONFIG_FILE), .env, the environment and a command-line
+// flag named after the variable (PROMPTS_DIR is -prompts-dir). Fields tagged reload take
+// effect on SIGHUP; the others need a restart.
+type Config struct {
+	Port              string        `yaml:"port" env:"PORT" help:"Port to listen on"`
+	TLSCertFile       string        `yaml:"tlsCertFile" env:"TLS_CERT_FILE" help:"PEM certificate; enables HTTPS and HTTP/2"`
+	TLSKeyFile        string        `yaml:"tlsKeyFile" env:"TLS_KEY_FILE" help:"PEM private key for TLS_CERT_FILE"`
+	TLSReloadInterval time.Duration `yaml:"tlsReloadInterval" env:"TLS_RELOAD_INTERVAL" help:"How often the TLS files are checked for changes"`
+	HTTPRedirectPort  string        `yaml:"httpRedirectPort" env:"HTTP_REDIRECT_PORT" help:"Plain HTTP port that redirects to HTTPS"`
+	TLSRedirectHost   string        `yaml:"tlsRedirectHost" env:"TLS_REDIRECT_HOST" help:"Public host name that HTTP_REDIRECT_PORT redirects to, e.g. api.example.com"`
+
+	ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout" env:"READ_HEADER_TIMEOUT" help:"HTTP read header timeout"`
+	ReadTimeout       time.Duration `yaml:"readTimeout" env:"READ_TIMEOUT" help:"HTTP read timeout"`
+	WriteTimeout      time.Duration `yaml:"writeTimeout" env:"WRITE_TIMEOUT" help:"HTTP write timeout, must cover the slowest analysis"`
+	IdleTimeout       time.Duration `yaml:"idleTimeout" env:"IDLE_TIMEOUT" help:"HTTP keep-alive idle timeout"`
+	DrainDelay        time.Duration `yaml:"drainDelay" env:"DRAIN_DELAY" help:"How long to report not ready before closing the listener on shutdown"`
+	ShutdownTimeout   time.Duration `yaml:"shutdownTimeout" env:"SHUTDOWN_TIMEOUT" help:"How long in-flight requests get to finish on shutdown"`
+	ReloadInterval    time.Duration `yaml:"reloadInterval" env:"RELOAD_INTERVAL" help:"How often the config, .env, prompt, experiment and budget files are checked for changes, 0 for SIGHUP only"`
+
+	StrictRequests        bool `yaml:"strictRequests" env:"STRICT_REQUESTS" reload:"true" help:"Reject request bodies with unknown fields or trailing data"`
+	MinContentLength      int  `yaml:"minContentLength" env:"MIN_CONTENT_LENGTH" help:"Fewest characters of content to analyze"`
+	MaxContentLength      int  `yaml:"maxContentLength" env:"MAX_CONTENT_LENGTH" help:"Most characters of an article or long text"`
+	MaxShortContentLength int  `yaml:"maxShortContentLength" env:"MAX_SHORT_CONTENT_LENGTH" help:"Most characters of a short text"`
+	MaxImageBytes         int  `yaml:"maxImageBytes" env:"MAX_IMAGE_BYTES" help:"Largest image /analyze/image accepts, in bytes"`
+
+	Model               string `yaml:"model" env:"MODEL" reload:"true" help:"Provider for analyses: gemini, pollinations or replay"`
+	GeminiAPIKey        string `yaml:"geminiApiKey" env:"GEMINI_API_KEY" secret:"true" reload:"true" help:"Gemini API key"`
+	PromptsDir          string `yaml:"promptsDir" env:"PROMPTS_DIR" reload:"true" help:"Directory with prompt template overrides"`
+	ExperimentsFile     string `yaml:"experimentsFile" env:"EXPERIMENTS_FILE" reload:"true" help:"JSON file with A/B experiment definitions"`
+	JSONFixReprompt     bool   `yaml:"jsonFixReprompt" env:"JSON_FIX_REPROMPT" help:"Ask the model once to fix output that isn't valid JSON"`
+	UnreferencedSources string `yaml:"unreferencedSources" env:"UNREFERENCED_SOURCES" help:"drop or flag sources that no reason cites"`
+	EnsembleProviders   string `yaml:"ensembleProviders" env:"ENSEMBLE_PROVIDERS" reload:"true" help:"Comma-separated providers to run every analysis on"`
+	EnsembleSamples     int    `yaml:"ensembleSamples" env:"ENSEMBLE_SAMPLES" reload:"true" help:"Samples per ensemble provider"`
+	EnsembleAggregate   string `yaml:"ensembleAggregate" env:"ENSEMBLE_AGGREGATE" reload:"true" help:"median or trimmed-mean"`
+	RecordDir           string `yaml:"recordDir" env:"RECORD_DIR" reload:"true" help:"Save every provider exchange as a fixture in this directory"`
+	ReplayDir           string `yaml:"replayDir" env:"REPLAY_DIR" reload:"true" help:"Fixtures answered by MODEL=replay"`
+
+	SourceCheck               bool          `yaml:"sourceCheck" env:"SOURCE_CHECK" help:"Check that cited sources are reachable and rate their domains"`
+	SourceCheckTimeout        time.Duration `yaml:"sourceCheckTimeout" env:"SOURCE_CHECK_TIMEOUT" help:"Timeout of one source check"`
+	SourceCheckConcurrency    int           `yaml:"sourceCheckConcurrency" env:"SOURCE_CHECK_CONCURRENCY" help:"Sources checked at once"`
+	SourceCheckCacheTTL       time.Duration `yaml:"sourceCheckCacheTtl" env:"SOURCE_CHECK_CACHE_TTL" help:"How long source check results are cached"`
+	SourceCheckCacheSize      int           `yaml:"sourceCheckCacheSize" env:"SOURCE_CHECK_CACHE_SIZE" help:"Most source check results kept in the cache"`
+	DomainReputationFile      string        `yaml:"domainReputationFile" env:"DOMAIN_REPUTATION_FILE" help:"Domain reputation list"`
+	UnreliableSourceThreshold float64       `yaml:"unreliableSourceThreshold" env:"UNRELIABLE_SOURCE_THRESHOLD" help:"Share of unreliable sources that lowers the confidence"`
+	UnreliableSourcePenalty   int           `yaml:"unreliableSourcePenalty" env:"UNRELIABLE_SOURCE_PENALTY" help:"Confidence points taken off for unreliable sources"`
+	CalibrationFile           string        `yaml:"calibrationFile" env:"CALIBRATION_FILE" help:"Confidence calibrations fitted with cmd/eval"`
+
+	PricesFile     string  `yaml:"pricesFile" env:"PRICES_FILE" help:"JSON model prices in USD per million tokens"`
+	ReportUsage    bool    `yaml:"reportUsage" env:"REPORT_USAGE" help:"Add token usage and cost to analysis responses"`
+	DailyBudgetUSD float64 `yaml:"dailyBudgetUsd" env:"DAILY_BUDGET_USD" reload:"true" help:"Daily spend limit shared by API keys not in BUDGETS_FILE, 0 for none"`
+	BudgetsFile    string  `yaml:"budgetsFile" env:"BUDGETS_FILE" reload:"true" help:"JSON daily limits by API key"`
+
+	HealthProbe         bool          `yaml:"healthProbe" env:"HEALTH_PROBE" reload:"true" help:"Probe the upstream APIs in /health/ready"`
+	HealthProbeInterval time.Duration `yaml:"healthProbeInterval" env:"HEALTH_PROBE_INTERVAL" reload:"true" help:"How long a probe result is reused"`
+	HealthProbeTimeout  time.Duration `yaml:"healthProbeTimeout" env:"HEALTH_PROBE_TIMEOUT" reload:"true" help:"Timeout of one probe"`
+
+	LogLevel        string `yaml:"logLevel" env:"LOG_LEVEL" help:"debug, info, warn or error"`
+	LogFormat       string `yaml:"logFormat" env:"LOG_FORMAT" help:"text or json"`
+	LogContent      string `yaml:"logContent" env:"LOG_CONTENT" help:"redact, truncate or full prompts and model output in logs"`
+	LogContentLimit int    `yaml:"logContentLimit" env:"LOG_CONTENT_LIMIT" help:"Characters kept by LOG_CONTENT=truncate"`
+	Tracing         string `yaml:"tracing" env:"TRACING" help:"Trace exporter: otlp or stdout"`
+	TracingEndpoint string `yaml:"tracingEndpoint" env:"TRACING_ENDPOINT" help:"OTLP endpoint URL"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		TLSReloadInterval:         time.Minute,
+		ReadHeaderTimeout:         10 * time.Second,
+		ReadTimeout:               30 * time.Second,
+		WriteTimeout:              2 * time.Minute,
+		IdleTimeout:               2 * time.Minute,
+		ShutdownTimeout:           30 * time.Second,
+		MinContentLength:          3,
+		MaxContentLength:          100000,
+		MaxShortContentLength:     5000,
+		MaxImageBytes:             5 << 20,
+		UnreferencedSources:       "drop",
+		EnsembleAggregate:         "median",
+		SourceCheckTimeout:        5 * time.Second,
+		SourceCheckConcurrency:    4,
+		SourceCheckCacheTTL:       time.Hour,
+		SourceCheckCacheSize:      10000,
+		UnreliableSourceThreshold: 0.5,
+		UnreliableSourcePenalty:   20,
+		HealthProbeInterval:       5 * time.Minute,
+		HealthProbeTimeout:        5 * time.Second,
+		LogLevel:                  "info",
+		LogFormat:                 "text",
+		LogContent:                "redact",
+		LogContentLimit:           200,
+	}
+}
+
+// Every problem found while loading and validating the configuration
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// One Config field with its tags
+type configField struct {
+	env    string
+	help   string
+	secret bool
+	reload bool
+	value  reflect.Value
+}
+
+func (c *Config) fields() []configField {
+	v := reflect.ValueOf(c).Elem()
+	fields := []configField{}
+	for i := range v.NumField() {
+		f := v.Type().Field(i)
+		fields = append(fields, configField{
+			env:    f.Tag.Get("env"),
+			help:   f.Tag.Get("help"),
+			secret: f.Tag.Get("secret") == "true",
+			reload: f.Tag.Get("reload") == "true",
+			value:  v.Field(i),
+		})
+	}
+	return fields
+}
+
+// Flag name of an environment variable, PROMPTS_DIR is prompts-dir
+func flagName(env string) string {
+	return strings.ToLower(strings.ReplaceAll(env, "_", "-"))
+}
+
+// Parses s into a string, bool, int, float64 or time.Duration field
+func setField(v reflect.Value, s string) error {
+	if v.Type() == reflect.TypeFor[time.Duration]() {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a duration like 30s or 5m", s)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("'%s' is not true or false", s)
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a whole number", s)
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a number", s)
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// A command-line flag that sets a Config field after the other sources are applied
+type configFlag struct {
+	field configField
+	set   *string
+}
+
+func (f *configFlag) String() string {
+	if f.set == nil {
+		return ""
+	}
+	return *f.set
+}
+
+func (f *configFlag) Set(s string) error {
+	f.set = &s
+	return nil
+}
+
+func (f *configFlag) IsBoolFlag() bool { return f.field.value.Kind() == reflect.Bool }
+
+// Options that control loading itself rather than the server
+type loadOptions struct {
+	Verbose     bool
+	PrintConfig bool
+	// Where each source was found, for the startup log
+	ConfigFile string
+	EnvFile    string
+}
+
+// Loads the configuration from args (without the program name), the environment looked
+// up with lookupEnv and envFile (skipped if missing), then validates it. Returns a
+// *ConfigError listing every problem, or flag.ErrHelp for -help.
+func LoadConfig(args []string, lookupEnv func(string) (string, bool), envFile string) (*Config, loadOptions, error) {
+	cfg := defaultConfig()
+	opts := loadOptions{}
+	problems := []string{}
+
+	flagSet := flag.NewFlagSet("false-fact-server", flag.ContinueOnError)
+	flagSet.SetOutput(io.Discard)
+	configPath := flagSet.String("config", "", "YAML config file (or CONFIG_FILE)")
+	flagSet.BoolVar(&opts.Verbose, "verbose", false, "Log at debug level (same as -log-level debug)")
+	flagSet.BoolVar(&opts.PrintConfig, "print-config", false, "Print the effective configuration with secrets redacted and exit")
+	flags := []*configFlag{}
+	for _, field := range cfg.fields() {
+		f := &configFlag{field: field}
+		flagSet.Var(f, flagName(field.env), field.help+" ("+field.env+")")
+		flags = append(flags, f)
+	}
+	if err := flagSet.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			flagSet.SetOutput(os.Stderr)
+			flagSet.PrintDefaults()
+			return nil, opts, err
+		}
+		return nil, opts, &ConfigError{Problems: []string{err.Error()}}
+	}
+
+	// .env is optional: the environment may come from systemd or Docker
+	dotenv, err := godotenv.Read(envFile)
+	if err == nil {
+		opts.EnvFile = envFile
+	} else if !errors.Is(err, os.ErrNotExist) {
+		problems = append(problems, fmt.Sprintf("%s: %v", envFile, err))
+	}
+	lookup := func(name string) (string, bool) {
+		if value, ok := lookupEnv(name); ok {
+			return value, true
+		}
+		value, ok := dotenv[name]
+		return value, ok
+	}
+
+	if *configPath == "" {
+		*configPath, _ = lookup("CONFIG_FILE")
+	}
+	if *configPath != "" {
+		opts.ConfigFile = *configPath
+		if err := cfg.loadFile(*configPath); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	for _, field := range cfg.fields() {
+		if value, ok := lookup(field.env); ok {
+			if err := setField(field.value, value); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", field.env, err))
+			}
+		}
+	}
+	for _, f := range flags {
+		if f.set != nil {
+			if err := setField(f.field.value, *f.set); err != nil {
+				problems = append(problems, fmt.Sprintf("-%s: %v", flagName(f.field.env), err))
+			}
+		}
+	}
+	if opts.Verbose {
+		cfg.LogLevel = "debug"
+	}
+
+	cfg.normalize()
+	problems = append(problems, cfg.validate()...)
+	if len(problems) > 0 {
+		return nil, opts, &ConfigError{Problems: problems}
+	}
+	return &cfg, opts, nil
+}
+
+// Reads the YAML config file; unknown keys are errors so typos don't go unnoticed
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// Lowercases the values that are names or modes
+func (c *Config) normalize() {
+	for _, s := range []*string{&c.Model, &c.UnreferencedSources, &c.EnsembleAggregate, &c.LogLevel, &c.LogFormat, &c.LogContent, &c.Tracing} {
+		*s = strings.ToLower(strings.TrimSpace(*s))
+	}
+}
+
+// Checks every setting and returns all problems found
+func (c *Config) validate() []string {
+	problems := []string{}
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	oneOf := func(name string, value string, allowed ...string) {
+		for _, a := range allowed {
+			if value == a {
+				return
+			}
+		}
+		add("%s: '%s' is not one of %s", name, value, strings.Join(allowed, ", "))
+	}
+
+	if c.Port == "" {
+		add("PORT is required")
+	} else if n, err := strconv.Atoi(c.Port); err != nil || n < 0 || n > 65535 {
+		add("PORT: '%s' is not a port number", c.Port)
+	}
+	if c.Model == "" {
+		add("MODEL is required, e.g. gemini or pollinations")
+	}
+	usesGemini := c.Model == factcheck.Gemini.Name()
+	for _, name := range strings.Split(c.EnsembleProviders, ",") {
+		usesGemini = usesGemini || strings.EqualFold(strings.TrimSpace(name), factcheck.Gemini.Name())
+	}
+	if usesGemini && c.GeminiAPIKey == "" {
+		add("GEMINI_API_KEY is required to use Gemini")
+	}
+	if c.Model == "replay" && c.ReplayDir == "" {
+		add("MODEL=replay needs REPLAY_DIR")
+	}
+	for _, name := range append([]string{c.Model}, strings.Split(c.EnsembleProviders, ",")...) {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || name == "replay" {
+			continue
+		}
+		if _, err := factcheck.ProviderByName(name); err != nil {
+			add("%v", err)
+		}
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		add("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if c.HTTPRedirectPort != "" && c.TLSCertFile == "" {
+		add("HTTP_REDIRECT_PORT needs TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+	if c.HTTPRedirectPort != "" && c.TLSRedirectHost == "" {
+		add("HTTP_REDIRECT_PORT needs TLS_REDIRECT_HOST")
+	}
+	if u, err := url.Parse("https://" + c.TLSRedirectHost); c.TLSRedirectHost != "" && (err != nil || u.Host != c.TLSRedirectHost || u.Port() != "") {
+		add("TLS_REDIRECT_HOST: '%s' is not a host name", c.TLSRedirectHost)
+	}
+
+	if c.MaxContentLength < c.MinContentLength || c.MaxShortContentLength < c.MinContentLength {
+		add("MAX_CONTENT_LENGTH and MAX_SHORT_CONTENT_LENGTH must be at least MIN_CONTENT_LENGTH")
+	}
+	if c.MaxImageBytes <= 0 {
+		add("MAX_IMAGE_BYTES must be positive")
+	}
+
+	oneOf("UNREFERENCED_SOURCES", c.UnreferencedSources, "drop", "flag")
+	oneOf("ENSEMBLE_AGGREGATE", c.EnsembleAggregate, "median", "trimmed-mean")
+	oneOf("LOG_LEVEL", c.LogLevel, "debug", "info", "warn", "error")
+	oneOf("LOG_FORMAT", c.LogFormat, "text", "json")
+	oneOf("LOG_CONTENT", c.LogContent, "redact", "truncate", "full")
+	oneOf("TRACING", c.Tracing, "", "otlp", "stdout")
+
+	for _, field := range c.fields() {
+		switch field.value.Kind() {
+		case reflect.Int, reflect.Int64:
+			if field.value.Int() < 0 {
+				add("%s must not be negative", field.env)
+			}
+		case reflect.Float64:
+			if field.value.Float() < 0 {
+				add("%s must not be negative", field.env)
+			}
+		}
+	}
+	if c.UnreliableSourceThreshold > 1 {
+		add("UNRELIABLE_SOURCE_THRESHOLD is a share between 0 and 1")
+	}
+	for name, d := range map[string]time.Duration{
+		"TLS_RELOAD_INTERVAL":   c.TLSReloadInterval,
+		"SHUTDOWN_TIMEOUT":      c.ShutdownTimeout,
+		"SOURCE_CHECK_TIMEOUT":  c.SourceCheckTimeout,
+		"HEALTH_PROBE_INTERVAL": c.HealthProbeInterval,
+		"HEALTH_PROBE_TIMEOUT":  c.HealthProbeTimeout,
+	} {
+		if d <= 0 {
+			add("%s must be positive", name)
+		}
+	}
+
+	// Files are read later, but a wrong path is caught here with everything else
+	for name, path := range map[string]string{
+		"TLS_CERT_FILE":          c.TLSCertFile,
+		"TLS_KEY_FILE":           c.TLSKeyFile,
+		"PROMPTS_DIR":            c.PromptsDir,
+		"EXPERIMENTS_FILE":       c.ExperimentsFile,
+		"REPLAY_DIR":             c.ReplayDir,
+		"DOMAIN_REPUTATION_FILE": c.DomainReputationFile,
+		"CALIBRATION_FILE":       c.CalibrationFile,
+		"PRICES_FILE":            c.PricesFile,
+		"BUDGETS_FILE":           c.BudgetsFile,
+	} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			add("%s: %v", name, err)
+		}
+	}
+	return problems
+}
+
+// Settings that differ from old but only take effect on a restart
+func (c *Config) restartRequired(old *Config) []string {
+	changed := []string{}
+	oldFields := old.fields()
+	for i, field := range c.fields() {
+		if !field.reload && !field.value.Equal(oldFields[i].value) {
+			changed = append(changed, field.env)
+		}
+	}
+	return changed
+}
+
+// The configuration as YAML, usable as a config file, with secrets replaced by "<redacted>"
+func (c *Config) Redacted() string {
+	redacted := *c
+	for _, field := range redacted.fields() {
+		if field.secret && field.value.String() != "" {
+			field.value.SetString("<redacted>")
+		}
+	}
+	node := &yaml.Node{}
+	node.Encode(redacted)
+	// Durations are written the way they are read, e.g. 30s
+	for i := 1; i < len(node.Content); i += 2 {
+		if d, ok := durationOf(redacted, node.Content[i-1].Value); ok {
+			node.Content[i] = &yaml.Node{Kind: yaml.ScalarNode, Value: d.String()}
+		}
+	}
+	out, _ := yaml.Marshal(node)
+	return string(out)
+}
+
+// The duration field with the given YAML key
+func durationOf(c Config, key string) (time.Duration, bool) {
+	t := reflect.TypeOf(c)
+	for i := range t.NumField() {
+		if t.Field(i).Tag.Get("yaml") == key && t.Field(i).Type == reflect.TypeFor[time.Duration]() {
+			return time.Duration(reflect.ValueOf(c).Field(i).Int()), true
+		}
+	}
+	return 0, false
+}
+
+func (c *Config) serverConfig() serverConfig {
+	return serverConfig{
+		ReadHeaderTimeout: c.ReadHeaderTimeout,
+		ReadTimeout:       c.ReadTimeout,
+		WriteTimeout:      c.WriteTimeout,
+		IdleTimeout:       c.IdleTimeout,
+		DrainDelay:        c.DrainDelay,
+		ShutdownTimeout:   c.ShutdownTimeout,
+	}
+}
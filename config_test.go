@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// An environment lookup backed by a map
+func fakeEnv(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+func writeFile(t *testing.T, dir string, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	configFile := writeFile(t, dir, "config.yaml", "port: \"7000\"\nmodel: pollinations\nlogLevel: warn\nsourceCheckTimeout: 2s\nlogContentLimit: 50\n")
+	envFile := writeFile(t, dir, ".env", "PORT=7001\nLOG_LEVEL=error\nCONFIG_FILE="+configFile+"\n")
+	env := fakeEnv(map[string]string{"PORT": "7002"})
+
+	cfg, opts, err := LoadConfig([]string{"-port", "7003", "-json-fix-reprompt"}, env, envFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Flag over env over .env over the file over the defaults
+	if cfg.Port != "7003" || cfg.LogLevel != "error" || cfg.SourceCheckTimeout != 2*time.Second || cfg.LogContentLimit != 50 {
+		t.Errorf("port %s, log level %s, timeout %v, limit %d", cfg.Port, cfg.LogLevel, cfg.SourceCheckTimeout, cfg.LogContentLimit)
+	}
+	if !cfg.JSONFixReprompt || cfg.Model != "pollinations" || cfg.ShutdownTimeout != 30*time.Second {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+	if opts.ConfigFile != configFile || opts.EnvFile != envFile {
+		t.Errorf("sources = %+v", opts)
+	}
+}
+
+func TestConfigWithoutEnvFile(t *testing.T) {
+	env := fakeEnv(map[string]string{"PORT": "8080", "MODEL": "Pollinations"})
+	cfg, _, err := LoadConfig(nil, env, filepath.Join(t.TempDir(), ".env"))
+	if err != nil {
+		t.Fatalf("a missing .env should not be an error: %v", err)
+	}
+	if cfg.Model != "pollinations" {
+		t.Errorf("model = %s, want it lowercased", cfg.Model)
+	}
+}
+
+func TestConfigAggregatesProblems(t *testing.T) {
+	env := fakeEnv(map[string]string{
+		"MODEL":                    "gemini",
+		"LOG_FORMAT":               "xml",
+		"SOURCE_CHECK":             "maybe",
+		"DRAIN_DELAY":              "5",
+		"TLS_CERT_FILE":            "/no/such/cert.pem",
+		"ENSEMBLE_SAMPLES":         "-1",
+		"CALIBRATION_FILE":         "/no/such/calibration.json",
+		"MIN_CONTENT_LENGTH":       "10",
+		"MAX_SHORT_CONTENT_LENGTH": "5",
+		"MAX_IMAGE_BYTES":          "0",
+		"HTTP_REDIRECT_PORT":       "80",
+		"TLS_REDIRECT_HOST":        "https://example.com/",
+	})
+	_, _, err := LoadConfig([]string{"-log-content-limit", "many"}, env, "")
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("expected a *ConfigError, got %v", err)
+	}
+	for _, want := range []string{
+		"PORT is required",
+		"GEMINI_API_KEY is required",
+		"LOG_FORMAT: 'xml'",
+		"SOURCE_CHECK: 'maybe' is not true or false",
+		"DRAIN_DELAY: '5' is not a duration",
+		"-log-content-limit: 'many'",
+		"TLS_CERT_FILE and TLS_KEY_FILE must be set together",
+		"ENSEMBLE_SAMPLES must not be negative",
+		"CALIBRATION_FILE:",
+		"MAX_SHORT_CONTENT_LENGTH must be at least MIN_CONTENT_LENGTH",
+		"MAX_IMAGE_BYTES must be positive",
+		"TLS_REDIRECT_HOST: 'https://example.com/' is not a host name",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestConfigFileRejectsUnknownKeys(t *testing.T) {
+	configFile := writeFile(t, t.TempDir(), "config.yaml", "port: \"8080\"\nmodel: pollinations\nprot: \"9090\"\n")
+	_, _, err := LoadConfig([]string{"-config", configFile}, fakeEnv(nil), "")
+	if err == nil || !strings.Contains(err.Error(), "prot") {
+		t.Errorf("expected an error naming the unknown key, got %v", err)
+	}
+}
+
+func TestConfigRedacted(t *testing.T) {
+	env := fakeEnv(map[string]string{"PORT": "8080", "MODEL": "gemini", "GEMINI_API_KEY": "AIza-secret"})
+	cfg, _, err := LoadConfig(nil, env, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := cfg.Redacted()
+	if strings.Contains(out, "AIza-secret") || !strings.Contains(out, "geminiApiKey: <redacted>") {
+		t.Errorf("secret not redacted:\n%s", out)
+	}
+	if !strings.Contains(out, "shutdownTimeout: 30s") {
+		t.Errorf("durations should print as they are written:\n%s", out)
+	}
+
+	// The output is a valid config file
+	path := writeFile(t, t.TempDir(), "config.yaml", out)
+	reloaded, _, err := LoadConfig([]string{"-config", path, "-gemini-api-key", "AIza-secret"}, fakeEnv(nil), "")
+	if err != nil || *reloaded != *cfg {
+		t.Errorf("reloading the printed config gave %+v, %v", reloaded, err)
+	}
+}
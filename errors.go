@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"false-fact-server/factcheck"
+)
+
+// The error of every failed request
+type APIError struct {
+	Type factcheck.AnalysisErrorType `json:"type"`
+	// What went wrong, for developers
+	Message string `json:"message"`
+	// What to tell the user
+	UserMessage string `json:"userMessage"`
+	// Whether the same request may succeed later
+	Retryable bool      `json:"retryable"`
+	RequestID string    `json:"requestId"`
+	Timestamp time.Time `json:"timestamp"`
+	// The problem fields of an INVALID_REQUEST
+	Fields []factcheck.FieldError `json:"fields,omitempty"`
+}
+
+// HTTP status of each error type
+var errorStatuses = map[factcheck.AnalysisErrorType]int{
+	factcheck.RateLimited:      http.StatusTooManyRequests,
+	factcheck.BudgetExceeded:   http.StatusTooManyRequests,
+	factcheck.ApiUnavailable:   http.StatusServiceUnavailable,
+	factcheck.NetworkError:     http.StatusBadGateway,
+	factcheck.InvalidResponse:  http.StatusBadGateway,
+	factcheck.InvalidContent:   http.StatusUnprocessableEntity,
+	factcheck.InvalidRequest:   http.StatusBadRequest,
+	factcheck.PayloadTooLarge:  http.StatusRequestEntityTooLarge,
+	factcheck.MethodNotAllowed: http.StatusMethodNotAllowed,
+	factcheck.NotFound:         http.StatusNotFound,
+	factcheck.InternalError:    http.StatusInternalServerError,
+	// The configured provider can't do it, e.g. analyze images
+	factcheck.CapabilityUnsupported: http.StatusNotImplemented,
+}
+
+// Writes the error with the status of its type, adding the request ID and time
+func writeError(w http.ResponseWriter, r *http.Request, apiErr APIError) {
+	apiErr.RequestID = factcheck.RequestID(r.Context())
+	apiErr.Timestamp = time.Now()
+	status, ok := errorStatuses[apiErr.Type]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: false,
+		Error:   &apiErr,
+	})
+}
+
+// Writes the error of a failed analysis. Only the fields of an ExtensionError are sent;
+// anything else is logged and reported as an internal error.
+func writeAnalysisError(w http.ResponseWriter, r *http.Request, err error) {
+	var extErr *factcheck.ExtensionError
+	var parseErr *factcheck.ParseError
+	switch {
+	case errors.As(err, &extErr):
+		writeError(w, r, APIError{Type: extErr.Type, Message: extErr.Message, UserMessage: extErr.UserMessage, Retryable: extErr.Retryable})
+	case errors.As(err, &parseErr):
+		writeError(w, r, APIError{
+			Type:        factcheck.InvalidResponse,
+			Message:     "The model's answer could not be used",
+			UserMessage: "Try analyzing the content again",
+			Retryable:   true,
+		})
+	case errors.Is(err, context.DeadlineExceeded):
+		writeError(w, r, APIError{
+			Type:        factcheck.NetworkError,
+			Message:     "The analysis timed out",
+			UserMessage: "The analysis took too long, please try again",
+			Retryable:   true,
+		})
+	default:
+		slog.ErrorContext(r.Context(), "analysis failed", "error", err)
+		writeError(w, r, APIError{
+			Type:        factcheck.InternalError,
+			Message:     "The analysis failed",
+			UserMessage: "Something went wrong, please try again later",
+		})
+	}
+}
+
+// Rejects a request made with a method the endpoint doesn't support
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, allowed string) {
+	w.Header().Set("Allow", allowed+", OPTIONS")
+	writeError(w, r, APIError{
+		Type:        factcheck.MethodNotAllowed,
+		Message:     "Method not allowed, use " + allowed,
+		UserMessage: "This request is not supported",
+	})
+}
+
+// Rejects a request that doesn't decode or validate, listing the problem fields.
+// Errors other than a ValidationError are logged and not sent.
+func invalidRequest(w http.ResponseWriter, r *http.Request, err error) {
+	var validationErr *factcheck.ValidationError
+	if !errors.As(err, &validationErr) {
+		slog.WarnContext(r.Context(), "reading the request failed", "error", err)
+		writeError(w, r, APIError{
+			Type:        factcheck.InvalidRequest,
+			Message:     "The request could not be read",
+			UserMessage: "The request could not be read",
+		})
+		return
+	}
+	apiErr := APIError{
+		Type:        factcheck.InvalidRequest,
+		Message:     err.Error(),
+		UserMessage: validationErr.Fields[0].Message,
+		Fields:      validationErr.Fields,
+	}
+	for _, field := range validationErr.Fields {
+		if field.Code == factcheck.FieldTooLarge {
+			apiErr.Type = factcheck.PayloadTooLarge
+		}
+	}
+	writeError(w, r, apiErr)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"false-fact-server/factcheck"
+)
+
+func TestWriteAnalysisError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantType   factcheck.AnalysisErrorType
+	}{
+		{"rate limited", &factcheck.ExtensionError{Type: factcheck.RateLimited, Message: "slow down", Retryable: true}, http.StatusTooManyRequests, factcheck.RateLimited},
+		{"wrapped", fmt.Errorf("ensemble: %w", &factcheck.ExtensionError{Type: factcheck.ApiUnavailable, Message: "down"}), http.StatusServiceUnavailable, factcheck.ApiUnavailable},
+		{"network", &factcheck.ExtensionError{Type: factcheck.NetworkError, Message: "reset"}, http.StatusBadGateway, factcheck.NetworkError},
+		{"rejected content", &factcheck.ExtensionError{Type: factcheck.InvalidContent, Message: "rejected"}, http.StatusUnprocessableEntity, factcheck.InvalidContent},
+		{"timeout", fmt.Errorf("calling provider: %w", context.DeadlineExceeded), http.StatusBadGateway, factcheck.NetworkError},
+		{"unknown", errors.New("dial tcp 10.0.0.7:443: secret-token rejected"), http.StatusInternalServerError, factcheck.InternalError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := factcheck.WithRequestID(context.Background(), "req-1")
+			req := httptest.NewRequest(http.MethodPost, "/v1/analyze/text/short", nil).WithContext(ctx)
+			rec := httptest.NewRecorder()
+			writeAnalysisError(rec, req, tt.err)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			var resp APIResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			wantError(tt.wantType)(t, resp)
+			if resp.Error.RequestID != "req-1" {
+				t.Errorf("requestId = %q", resp.Error.RequestID)
+			}
+			// Raw errors stay in the logs
+			if strings.Contains(rec.Body.String(), "secret-token") {
+				t.Errorf("raw error leaked: %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestMethodNotAllowedSetsAllow(t *testing.T) {
+	rec := httptest.NewRecorder()
+	methodNotAllowed(rec, httptest.NewRequest(http.MethodGet, "/analyze/article", nil), http.MethodPost)
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "POST, OPTIONS" {
+		t.Errorf("status %d, Allow %q", rec.Code, rec.Header().Get("Allow"))
+	}
+}
+
+func TestInvalidRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantType   factcheck.AnalysisErrorType
+	}{
+		{"invalid", &factcheck.ValidationError{Fields: []factcheck.FieldError{{Field: "title", Code: factcheck.FieldRequired, Message: "title is required"}}}, http.StatusBadRequest, factcheck.InvalidRequest},
+		{"too large", &factcheck.ValidationError{Fields: []factcheck.FieldError{{Code: factcheck.FieldTooLarge, Message: "too large"}}}, http.StatusRequestEntityTooLarge, factcheck.PayloadTooLarge},
+		{"raw", errors.New("multipart: NextPart: secret-token"), http.StatusBadRequest, factcheck.InvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			invalidRequest(rec, httptest.NewRequest(http.MethodPost, "/analyze/image", nil), tt.err)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			var resp APIResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			wantError(tt.wantType)(t, resp)
+			if strings.Contains(rec.Body.String(), "secret-token") {
+				t.Errorf("raw error leaked: %s", rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,786 @@
+package factcheck
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// Request structure for AI API
+type AnalyzeArticleRequest struct {
+	Content    string    `json:"content"`
+	Title      string    `json:"title"`
+	URL        string    `json:"url"`
+	LastEdited time.Time `json:"last_edited"`
+	// ISO 639-1 code of the content's language; detected when empty
+	Language string `json:"language,omitempty"`
+	// ISO 639-1 code of the language to write the reasons in; the content's when empty
+	ResponseLanguage string `json:"responseLanguage,omitempty"`
+}
+
+// Request structure for AI API
+type AnalyzeTextRequest struct {
+	Content string `json:"content"`
+	// ISO 639-1 code of the content's language; detected when empty
+	Language string `json:"language,omitempty"`
+	// ISO 639-1 code of the language to write the reasons in; the content's when empty
+	ResponseLanguage string `json:"responseLanguage,omitempty"`
+}
+
+// Response structure for AI API
+type Reasoning struct {
+	Factual    []string `json:"factual"`
+	Unfactual  []string `json:"unfactual"`
+	Subjective []string `json:"subjective"`
+	Objective  []string `json:"objective"`
+}
+
+// Legacy shape of the short-text verdict: only the key matching Verdict is set
+type Analysis struct {
+	Fact    *[]string `json:"fact"`
+	False   *[]string `json:"false"`
+	Opinion *[]string `json:"opinion"`
+	None    *[]string `json:"none"`
+}
+
+// Conclusion of a short-text analysis
+type Verdict string
+
+const (
+	VerdictFact    Verdict = "fact"
+	VerdictFalse   Verdict = "false"
+	VerdictOpinion Verdict = "opinion"
+	// The text has no checkable claim, e.g. it is gibberish
+	VerdictNone Verdict = "none"
+)
+
+var verdicts = []Verdict{VerdictFact, VerdictFalse, VerdictOpinion, VerdictNone}
+
+type Categories struct {
+	Factuality  int `json:"factuality"`
+	Objectivity int `json:"objectivity"`
+}
+
+type AnalysisResponse struct {
+	Reasoning        Reasoning  `json:"reasoning"`
+	CredibilityScore int        `json:"credibilityScore"`
+	Categories       Categories `json:"categories"`
+	Confidence       int        `json:"confidence"`
+	// Confidence as the model (or ensemble) gave it, before calibration and source penalties
+	RawConfidence int `json:"rawConfidence"`
+	// Calibration method applied to Confidence, if any
+	Calibration string   `json:"calibration,omitempty"`
+	Sources     []string `json:"sources"`
+	// Sources as {id, url, title}, alongside the legacy "[n](url)" strings
+	StructuredSources []Source `json:"structuredSources"`
+	// Problems found (and fixed) when checking citations against sources
+	CitationWarnings []string `json:"citationWarnings,omitempty"`
+	// Set when sources were checked for liveness and reputation
+	SourceCheck *SourceCheckSummary `json:"sourceCheck,omitempty"`
+	// Set when the answers of several providers or samples were merged
+	Ensemble *EnsembleSummary `json:"ensemble,omitempty"`
+	// Claims the model found in an analyzed image
+	Claims []string `json:"claims,omitempty"`
+	// ISO 639-1 code of the content's language, when it could be detected
+	DetectedLanguage string `json:"detectedLanguage,omitempty"`
+	// ISO 639-1 code of the language the reasons were asked in, when known
+	ResponseLanguage string `json:"responseLanguage,omitempty"`
+	// Tokens and cost of the provider calls, set when ReportUsage is
+	Usage         *UsageReport `json:"usage,omitempty"`
+	PromptVersion string       `json:"promptVersion"`
+	Variant       string       `json:"variant"`
+}
+
+type ShortAnalysisResponse struct {
+	Verdict Verdict `json:"verdict"`
+	Reason  string  `json:"reason"`
+	// Verdict and Reason in the original format, kept for older clients
+	Analysis   Analysis `json:"analysis"`
+	Confidence int      `json:"confidence"`
+	// Confidence as the model (or ensemble) gave it, before calibration and source penalties
+	RawConfidence int `json:"rawConfidence"`
+	// Calibration method applied to Confidence, if any
+	Calibration string   `json:"calibration,omitempty"`
+	Sources     []string `json:"sources"`
+	// Sources as {id, url, title}, alongside the legacy "[n](url)" strings
+	StructuredSources []Source `json:"structuredSources"`
+	// Problems found (and fixed) when checking citations against sources
+	CitationWarnings []string `json:"citationWarnings,omitempty"`
+	// Set when sources were checked for liveness and reputation
+	SourceCheck *SourceCheckSummary `json:"sourceCheck,omitempty"`
+	// Set when the answers of several providers or samples were merged
+	Ensemble *EnsembleSummary `json:"ensemble,omitempty"`
+	// Claims the model found in an analyzed image
+	Claims []string `json:"claims,omitempty"`
+	// ISO 639-1 code of the content's language, when it could be detected
+	DetectedLanguage string `json:"detectedLanguage,omitempty"`
+	// ISO 639-1 code of the language the reasons were asked in, when known
+	ResponseLanguage string `json:"responseLanguage,omitempty"`
+	// Tokens and cost of the provider calls, set when ReportUsage is
+	Usage         *UsageReport `json:"usage,omitempty"`
+	PromptVersion string       `json:"promptVersion"`
+	Variant       string       `json:"variant"`
+}
+
+// Calls the external AI API for article analysis
+func AiAnalyzeArticle(ctx context.Context, content string, title string, url string, lastEdited time.Time, requested Languages, variant Variant) (*AnalysisResponse, error) {
+	ctx, meter := usageMeterFor(ctx)
+	prompt, err := variant.Prompts.Get(PromptArticle)
+	if err != nil {
+		return nil, err
+	}
+	languages, detected := resolveLanguages(ctx, content, requested)
+	systemPrompt, analysisPrompt, err := renderPrompt(ctx, prompt, PromptData{
+		Content:          content,
+		Title:            title,
+		URL:              url,
+		LastEdited:       lastEdited,
+		Language:         LanguageName(languages.Content),
+		ResponseLanguage: LanguageName(languages.Response),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	call := analysisCall{variant: variant, kind: PromptArticle, prompt: prompt, languages: languages, detected: detected, meter: meter, start: time.Now()}
+	answers, err := callMembers(ctx, variant, PromptArticle, systemPrompt, analysisPrompt, nil, parseAnalysisResponse)
+	if err != nil {
+		call.fail(err)
+		return nil, err
+	}
+	return call.finishLong(ctx, answers), nil
+}
+
+func AiAnalyzeTextLong(ctx context.Context, content string, requested Languages, variant Variant) (*AnalysisResponse, error) {
+	ctx, meter := usageMeterFor(ctx)
+	prompt, err := variant.Prompts.Get(PromptTextLong)
+	if err != nil {
+		return nil, err
+	}
+	languages, detected := resolveLanguages(ctx, content, requested)
+	systemPrompt, analysisPrompt, err := renderPrompt(ctx, prompt, PromptData{
+		Content:          content,
+		Language:         LanguageName(languages.Content),
+		ResponseLanguage: LanguageName(languages.Response),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	call := analysisCall{variant: variant, kind: PromptTextLong, prompt: prompt, languages: languages, detected: detected, meter: meter, start: time.Now()}
+	answers, err := callMembers(ctx, variant, PromptTextLong, systemPrompt, analysisPrompt, nil, parseAnalysisResponse)
+	if err != nil {
+		call.fail(err)
+		return nil, err
+	}
+	return call.finishLong(ctx, answers), nil
+}
+
+func AiAnalyzeTextShort(ctx context.Context, content string, requested Languages, variant Variant) (*ShortAnalysisResponse, error) {
+	ctx, meter := usageMeterFor(ctx)
+	prompt, err := variant.Prompts.Get(PromptTextShort)
+	if err != nil {
+		return nil, err
+	}
+	languages, detected := resolveLanguages(ctx, content, requested)
+	systemPrompt, analysisPrompt, err := renderPrompt(ctx, prompt, PromptData{
+		Content:          content,
+		Language:         LanguageName(languages.Content),
+		ResponseLanguage: LanguageName(languages.Response),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	call := analysisCall{variant: variant, kind: PromptTextShort, prompt: prompt, languages: languages, detected: detected, meter: meter, start: time.Now()}
+	answers, err := callMembers(ctx, variant, PromptTextShort, systemPrompt, analysisPrompt, nil, parseShortAnalysisResponse)
+	if err != nil {
+		call.fail(err)
+		return nil, err
+	}
+	return call.finishShort(ctx, answers), nil
+}
+
+// A call to the members of a variant, and what its answers are finished with
+type analysisCall struct {
+	variant   Variant
+	kind      PromptKind
+	prompt    *Prompt
+	languages Languages
+	// Language detected in the content, if any
+	detected string
+	meter    *UsageMeter
+	start    time.Time
+}
+
+// Records the failed call in the variant stats
+func (c analysisCall) fail(err error) {
+	recordSample(c.variant, VariantSample{Kind: c.kind, Latency: time.Since(c.start), Outcome: failureOutcome(err)})
+}
+
+// Merges the answers of a long analysis and fills in confidence, languages, version and usage
+func (c analysisCall) finishLong(ctx context.Context, answers []*AnalysisResponse) *AnalysisResponse {
+	parsed := answers[0]
+	if c.variant.Ensemble != nil {
+		claims := [][]string{}
+		for _, a := range answers {
+			claims = append(claims, a.Claims)
+		}
+		parsed = c.variant.Ensemble.aggregateAnalyses(answers)
+		parsed.Claims = mergeClaims(claims...)
+	}
+	parsed.RawConfidence = parsed.Confidence
+	parsed.Calibration = calibrateConfidence(c.variant, c.prompt.Version, &parsed.Confidence)
+	if SourceCheck != nil {
+		parsed.SourceCheck = SourceCheck.Annotate(ctx, parsed.StructuredSources, &parsed.Confidence)
+	}
+	recordSample(c.variant, VariantSample{Kind: c.kind, Latency: time.Since(c.start), Outcome: OutcomeSuccess, Credibility: &parsed.CredibilityScore, Confidence: parsed.Confidence})
+
+	parsed.DetectedLanguage = c.detected
+	parsed.ResponseLanguage = c.languages.Response
+	parsed.PromptVersion = c.prompt.Version
+	parsed.Variant = c.variant.ID()
+	parsed.Usage = c.usage()
+	return parsed
+}
+
+// Merges the answers of a short analysis and fills in confidence, languages, version and usage
+func (c analysisCall) finishShort(ctx context.Context, answers []*ShortAnalysisResponse) *ShortAnalysisResponse {
+	parsed := answers[0]
+	if c.variant.Ensemble != nil {
+		claims := [][]string{}
+		for _, a := range answers {
+			claims = append(claims, a.Claims)
+		}
+		parsed = c.variant.Ensemble.aggregateShortAnalyses(answers)
+		parsed.Claims = mergeClaims(claims...)
+	}
+	parsed.RawConfidence = parsed.Confidence
+	parsed.Calibration = calibrateConfidence(c.variant, c.prompt.Version, &parsed.Confidence)
+	if SourceCheck != nil {
+		parsed.SourceCheck = SourceCheck.Annotate(ctx, parsed.StructuredSources, &parsed.Confidence)
+	}
+	recordSample(c.variant, VariantSample{Kind: c.kind, Latency: time.Since(c.start), Outcome: OutcomeSuccess, Confidence: parsed.Confidence})
+
+	parsed.DetectedLanguage = c.detected
+	parsed.ResponseLanguage = c.languages.Response
+	parsed.PromptVersion = c.prompt.Version
+	parsed.Variant = c.variant.ID()
+	parsed.Usage = c.usage()
+	return parsed
+}
+
+// The usage of the call, when ReportUsage is set
+func (c analysisCall) usage() *UsageReport {
+	if !ReportUsage {
+		return nil
+	}
+	usage := c.meter.Report()
+	return &usage
+}
+
+// Parses the model output. If no JSON object could be recovered and JSONFixReprompt
+// is set, the provider is asked once to fix its own output.
+func parseWithFix[T any](ctx context.Context, variant Variant, response string, parse func(string) (*T, error)) (*T, error) {
+	parsed, err := tracedParse(ctx, parse, response)
+	if !errors.Is(err, errMalformedJSON) || !JSONFixReprompt {
+		return parsed, err
+	}
+
+	prompt, promptErr := variant.Prompts.Get(PromptJSONFix)
+	if promptErr != nil {
+		return nil, err
+	}
+	systemPrompt, fixPrompt, promptErr := renderPrompt(ctx, prompt, PromptData{Content: response})
+	if promptErr != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "asking the provider to fix its JSON", "provider", variant.Provider.Name())
+	fixed, callErr := callProvider(ctx, variant.Provider, 1, systemPrompt, fixPrompt, nil)
+	if callErr != nil {
+		return nil, err
+	}
+	return tracedParse(ctx, parse, fixed.Text)
+}
+
+const geminiModel = "gemini-2.5-flash"
+
+// Fetches the model metadata, which checks the key and the API without using any tokens
+func geminiProbe(ctx context.Context, apiKey string) error {
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{
+		APIKey:  apiKey,
+		Backend: genai.BackendGeminiAPI,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = client.Models.Get(ctx, geminiModel, nil)
+	return err
+}
+
+// Sends prompt, and image if it isn't nil, to Gemini
+func geminiApiCall(ctx context.Context, apiKey string, prompt string, image *Image) (Completion, error) {
+	if len(apiKey) == 0 {
+		return Completion{}, &ExtensionError{
+			Type:        ApiUnavailable,
+			Message:     "Gemini API key is missing",
+			Retryable:   false,
+			UserMessage: "Please set GEMINI_API_KEY in your environment",
+		}
+	}
+
+	slog.DebugContext(ctx, "calling Gemini", contentAttr("prompt", prompt))
+
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{
+		APIKey:  apiKey,
+		Backend: genai.BackendGeminiAPI,
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "failed to initialize the Gemini client", "error", err)
+		return Completion{}, &ExtensionError{
+			Type:        ApiUnavailable,
+			Message:     "Failed to initialize Gemini client",
+			Retryable:   true,
+			UserMessage: "The analysis service is unavailable, please try again later",
+		}
+	}
+
+	modelName := geminiModel
+	temperature := genai.Ptr[float32](0.5)
+	thinkingBudget := int32(0) // disables thinking
+
+	contents := genai.Text(prompt)
+	if image != nil {
+		contents = []*genai.Content{genai.NewContentFromParts([]*genai.Part{
+			genai.NewPartFromText(prompt),
+			genai.NewPartFromBytes(image.Data, image.MIMEType),
+		}, genai.RoleUser)}
+	}
+	result, err := client.Models.GenerateContent(
+		ctx,
+		modelName,
+		contents,
+		&genai.GenerateContentConfig{
+			Temperature: temperature,
+			ThinkingConfig: &genai.ThinkingConfig{
+				ThinkingBudget: &thinkingBudget,
+			},
+			Tools: []*genai.Tool{
+				{GoogleSearch: &genai.GoogleSearch{}},
+			},
+		},
+	)
+	if err != nil {
+		// The upstream error can contain request details, so it is only logged
+		slog.WarnContext(ctx, "Gemini API request failed", "error", err)
+		var apiErr genai.APIError
+		if errors.As(err, &apiErr) {
+			return Completion{}, handleHttpStatusError(apiErr.Code, fmt.Sprintf("Gemini API request failed with status %d", apiErr.Code))
+		}
+		return Completion{}, &ExtensionError{
+			Type:        NetworkError,
+			Message:     "Gemini API request failed",
+			Retryable:   true,
+			UserMessage: "Please check your internet connection and try again",
+		}
+	}
+
+	completion := Completion{Model: modelName}
+	if result != nil {
+		completion.Text = result.Text()
+		if result.ModelVersion != "" {
+			completion.Model = result.ModelVersion
+		}
+		// Grounding and thinking tokens are billed like prompt and output tokens
+		if usage := result.UsageMetadata; usage != nil {
+			completion.Usage = Usage{
+				PromptTokens:     int(usage.PromptTokenCount + usage.ToolUsePromptTokenCount),
+				CompletionTokens: int(usage.CandidatesTokenCount + usage.ThoughtsTokenCount),
+				TotalTokens:      int(usage.TotalTokenCount),
+			}
+		}
+	}
+
+	return completion, nil
+}
+
+func pollinationsApiCall(ctx context.Context, systemPrompt string, userPrompt string) (Completion, error) {
+	payload := map[string]interface{}{
+		"model": "openai-fast",
+		"messages": []map[string]string{
+			{"role": "system", "content": systemPrompt},
+			{"role": "user", "content": userPrompt},
+		},
+		"temperature":     0.7,
+		"stream":          false,
+		"private":         false,
+		"response_format": map[string]string{"type": "json_object"},
+	}
+
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return Completion{}, err
+	}
+	slog.DebugContext(ctx, "calling Pollinations", contentAttr("payload", string(payloadBytes)))
+
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://text.pollinations.ai/openai", bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return Completion{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if id := RequestID(ctx); id != "" {
+		req.Header.Set("X-Request-ID", id)
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		slog.WarnContext(ctx, "Pollinations request failed", "error", err)
+		return Completion{}, handleHttpStatusError(0, "Pollinations request failed")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return Completion{}, handleHttpStatusError(resp.StatusCode, fmt.Sprintf("POST request failed with status %d", resp.StatusCode))
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		slog.WarnContext(ctx, "reading the Pollinations response failed", "error", err)
+		return Completion{}, handleHttpStatusError(0, "Reading the Pollinations response failed")
+	}
+	slog.DebugContext(ctx, "Pollinations response", "status", resp.StatusCode, contentAttr("body", string(body)))
+
+	var responseJson map[string]interface{}
+	if err := json.Unmarshal(body, &responseJson); err != nil {
+		return Completion{}, &ExtensionError{
+			Type:        InvalidResponse,
+			Message:     "Pollinations answered with invalid JSON",
+			Retryable:   true,
+			UserMessage: "Try analyzing the content again",
+		}
+	}
+
+	completion := Completion{Model: "openai-fast"}
+	if choices, ok := responseJson["choices"].([]interface{}); ok && len(choices) > 0 {
+		if choice, ok := choices[0].(map[string]interface{}); ok {
+			if message, ok := choice["message"].(map[string]interface{}); ok {
+				if c, ok := message["content"].(string); ok {
+					completion.Text = c
+				}
+			}
+		}
+	}
+	if model, ok := responseJson["model"].(string); ok && model != "" {
+		completion.Model = model
+	}
+	if usage, ok := responseJson["usage"].(map[string]interface{}); ok {
+		completion.Usage = Usage{
+			PromptTokens:     jsonInt(usage["prompt_tokens"]),
+			CompletionTokens: jsonInt(usage["completion_tokens"]),
+			TotalTokens:      jsonInt(usage["total_tokens"]),
+		}
+	}
+
+	return completion, nil
+}
+
+// Lists the Pollinations text models, a free request that shows the API is up
+func pollinationsProbe(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://text.pollinations.ai/models", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET /models returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Reads a number decoded into an interface{}, 0 if it isn't one
+func jsonInt(v interface{}) int {
+	f, _ := v.(float64)
+	return int(f)
+}
+
+func parseAnalysisResponse(content string) (*AnalysisResponse, error) {
+	var parsed AnalysisResponse
+	if err := decodeModelJSON(content, &parsed, "reasoning", "credibilityScore"); err != nil {
+		return nil, err
+	}
+
+	// Validate required fields
+	if parsed.CredibilityScore == 0 && parsed.Categories.Factuality == 0 && parsed.Categories.Objectivity == 0 &&
+		parsed.Confidence == 0 && len(parsed.Reasoning.Factual) == 0 && len(parsed.Reasoning.Unfactual) == 0 &&
+		len(parsed.Reasoning.Subjective) == 0 && len(parsed.Reasoning.Objective) == 0 {
+		return nil, &ExtensionError{
+			Type:        InvalidResponse,
+			Message:     "Invalid response format from analysis service",
+			Retryable:   true,
+			UserMessage: "Try analyzing the content again",
+		}
+	}
+
+	// Validate score ranges
+	if parsed.CredibilityScore < 0 || parsed.CredibilityScore > 100 {
+		return nil, &ExtensionError{
+			Type:        InvalidResponse,
+			Message:     "Invalid credibility score in response",
+			Retryable:   true,
+			UserMessage: "Try analyzing the content again",
+		}
+	}
+	if parsed.Confidence < 0 || parsed.Confidence > 100 {
+		return nil, &ExtensionError{
+			Type:        InvalidResponse,
+			Message:     "Invalid confidence score in response",
+			Retryable:   true,
+			UserMessage: "Try analyzing the content again",
+		}
+	}
+	// Validate categories
+	if parsed.Categories.Factuality < 0 || parsed.Categories.Factuality > 100 ||
+		parsed.Categories.Objectivity < 0 || parsed.Categories.Objectivity > 100 {
+		return nil, &ExtensionError{
+			Type:        InvalidResponse,
+			Message:     "Category values out of range",
+			Retryable:   true,
+			UserMessage: "Try analyzing the content again",
+		}
+	}
+	// Validate sources against the citations in the reasons
+	reasons := []*[]string{&parsed.Reasoning.Factual, &parsed.Reasoning.Unfactual, &parsed.Reasoning.Subjective, &parsed.Reasoning.Objective}
+	parsed.StructuredSources, parsed.CitationWarnings = checkCitations(reasons, parsed.Sources)
+	parsed.Sources = legacySources(parsed.StructuredSources)
+	logCitationWarnings(parsed.CitationWarnings)
+
+	return &parsed, nil
+}
+
+// Model output for a short analysis. Older prompts answered with "analysis": {"<verdict>": reason},
+// where the reason was either a string or a list of strings, so both forms are accepted.
+type rawShortAnalysis struct {
+	Verdict    string                     `json:"verdict"`
+	Reason     json.RawMessage            `json:"reason"`
+	Analysis   map[string]json.RawMessage `json:"analysis"`
+	Confidence int                        `json:"confidence"`
+	Sources    []string                   `json:"sources"`
+	// Claims found in an image
+	Claims []string `json:"claims"`
+}
+
+func parseShortAnalysisResponse(content string) (*ShortAnalysisResponse, error) {
+	var raw rawShortAnalysis
+	if err := decodeModelJSON(content, &raw, "verdict", "analysis"); err != nil {
+		return nil, err
+	}
+
+	if raw.Confidence < 0 || raw.Confidence > 100 {
+		return nil, &ExtensionError{
+			Type:        InvalidResponse,
+			Message:     "Invalid confidence score in response",
+			Retryable:   true,
+			UserMessage: "Try analyzing the content again",
+		}
+	}
+
+	// Collect every conclusion the model gave, there must be exactly one
+	conclusions := map[Verdict]string{}
+	if raw.Verdict != "" {
+		conclusions[Verdict(strings.ToLower(strings.TrimSpace(raw.Verdict)))] = reasonText(raw.Reason)
+	}
+	for key, reason := range raw.Analysis {
+		if string(reason) == "null" {
+			continue
+		}
+		verdict := Verdict(strings.ToLower(strings.TrimSpace(key)))
+		if conclusions[verdict] == "" {
+			conclusions[verdict] = reasonText(reason)
+		}
+	}
+
+	if len(conclusions) == 0 {
+		return nil, &ExtensionError{
+			Type:        InvalidResponse,
+			Message:     "Missing analysis conclusion",
+			Retryable:   true,
+			UserMessage: "Try analyzing the content again",
+		}
+	}
+	if len(conclusions) > 1 {
+		return nil, &ExtensionError{
+			Type:        InvalidResponse,
+			Message:     "Multiple analysis conclusions",
+			Retryable:   true,
+			UserMessage: "Try analyzing the content again",
+		}
+	}
+
+	parsed := ShortAnalysisResponse{Confidence: raw.Confidence, Claims: raw.Claims}
+	for verdict, reason := range conclusions {
+		if !isVerdict(verdict) {
+			return nil, &ExtensionError{
+				Type:        InvalidResponse,
+				Message:     fmt.Sprintf("Unknown analysis verdict '%s'", verdict),
+				Retryable:   true,
+				UserMessage: "Try analyzing the content again",
+			}
+		}
+		parsed.Verdict = verdict
+		parsed.Reason = reason
+	}
+
+	// Validate sources against the citations in the reason
+	reasons := []string{parsed.Reason}
+	parsed.StructuredSources, parsed.CitationWarnings = checkCitations([]*[]string{&reasons}, raw.Sources)
+	parsed.Reason = reasons[0]
+	parsed.Sources = legacySources(parsed.StructuredSources)
+	logCitationWarnings(parsed.CitationWarnings)
+
+	parsed.Analysis = legacyAnalysis(parsed.Verdict, parsed.Reason)
+
+	return &parsed, nil
+}
+
+// Reads a reason given as a string or a list of strings
+func reasonText(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return strings.TrimSpace(s)
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err == nil {
+		return strings.TrimSpace(strings.Join(list, " "))
+	}
+	return ""
+}
+
+func isVerdict(v Verdict) bool {
+	for _, known := range verdicts {
+		if v == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Builds the legacy analysis object with only the verdict's key set
+func legacyAnalysis(verdict Verdict, reason string) Analysis {
+	reasons := &[]string{reason}
+	switch verdict {
+	case VerdictFact:
+		return Analysis{Fact: reasons}
+	case VerdictFalse:
+		return Analysis{False: reasons}
+	case VerdictOpinion:
+		return Analysis{Opinion: reasons}
+	default:
+		return Analysis{None: reasons}
+	}
+}
+
+type AnalysisErrorType string
+
+const (
+	RateLimited    AnalysisErrorType = "RATE_LIMITED"
+	ApiUnavailable AnalysisErrorType = "API_UNAVAILABLE"
+	// The provider rejected the content that was sent for analysis
+	InvalidContent AnalysisErrorType = "INVALID_CONTENT"
+	NetworkError   AnalysisErrorType = "NETWORK_ERROR"
+	BudgetExceeded AnalysisErrorType = "BUDGET_EXCEEDED"
+	// The provider answered, but its output was unusable
+	InvalidResponse AnalysisErrorType = "INVALID_RESPONSE"
+	// The provider can't do what the analysis needs, e.g. look at images
+	CapabilityUnsupported AnalysisErrorType = "CAPABILITY_UNSUPPORTED"
+)
+
+// Errors about the request itself rather than the analysis, returned by the HTTP API
+const (
+	InvalidRequest   AnalysisErrorType = "INVALID_REQUEST"
+	MethodNotAllowed AnalysisErrorType = "METHOD_NOT_ALLOWED"
+	NotFound         AnalysisErrorType = "NOT_FOUND"
+	InternalError    AnalysisErrorType = "INTERNAL_ERROR"
+	// An invalid request whose body or image is over the size limit
+	PayloadTooLarge AnalysisErrorType = "PAYLOAD_TOO_LARGE"
+)
+
+// Returned by the AiAnalyze* functions when the provider answered but its output was unusable
+type ParseError struct {
+	Err error
+}
+
+func (e *ParseError) Error() string { return e.Err.Error() }
+func (e *ParseError) Unwrap() error { return e.Err }
+
+type ExtensionError struct {
+	Type        AnalysisErrorType `json:"type"`
+	Message     string            `json:"message"`
+	Retryable   bool              `json:"retryable"`
+	UserMessage string            `json:"userMessage"`
+}
+
+func (e *ExtensionError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Type, e.Message)
+}
+
+func handleHttpStatusError(status int, message string) error {
+	switch {
+	case status == 429:
+		return &ExtensionError{
+			Type:        RateLimited,
+			Message:     "API rate limit exceeded",
+			Retryable:   true,
+			UserMessage: "Please wait a moment before trying again",
+		}
+	case status >= 500:
+		return &ExtensionError{
+			Type:        ApiUnavailable,
+			Message:     "Analysis service is temporarily unavailable",
+			Retryable:   true,
+			UserMessage: "Please try again in a few minutes",
+		}
+	case status == 404:
+		return &ExtensionError{
+			Type:        ApiUnavailable,
+			Message:     "API endpoint not found (404)",
+			Retryable:   false,
+			UserMessage: "Using fallback analysis method",
+		}
+	case status == 400:
+		return &ExtensionError{
+			Type:        InvalidContent,
+			Message:     "Invalid request format or content",
+			Retryable:   false,
+			UserMessage: "Please try with different content or check your input",
+		}
+	case status >= 400 && status < 500:
+		return &ExtensionError{
+			Type:        ApiUnavailable,
+			Message:     fmt.Sprintf("API request failed with status %d", status),
+			Retryable:   false,
+			UserMessage: "Please check your request and try again",
+		}
+	default:
+		return &ExtensionError{
+			Type:        NetworkError,
+			Message:     message,
+			Retryable:   true,
+			UserMessage: "Please check your internet connection and try again",
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package factcheck
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseShortAnalysisResponseVerdicts(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		wantVerdict Verdict
+		wantReason  string
+	}{
+		{"verdict and reason", `{"verdict": "false", "reason": "Not true [1].", "confidence": 90, "sources": ["[1](https://example.com)"]}`, VerdictFalse, "Not true [1]."},
+		{"none verdict", `{"verdict": "none", "reason": "Gibberish.", "confidence": 70, "sources": []}`, VerdictNone, "Gibberish."},
+		{"verdict is case-insensitive", `{"verdict": " Opinion ", "reason": "Taste.", "confidence": 70}`, VerdictOpinion, "Taste."},
+		{"legacy string reason", `{"analysis": {"fact": "It is true."}, "confidence": 90, "sources": []}`, VerdictFact, "It is true."},
+		{"legacy list reason", `{"analysis": {"opinion": ["A view.", "Not checkable."]}, "confidence": 90}`, VerdictOpinion, "A view. Not checkable."},
+		{"legacy none", `{"analysis": {"none": "Nothing to check."}, "confidence": 60}`, VerdictNone, "Nothing to check."},
+		{"legacy with null keys", `{"analysis": {"fact": null, "false": "Wrong.", "opinion": null}, "confidence": 60}`, VerdictFalse, "Wrong."},
+		{"verdict agrees with analysis", `{"verdict": "fact", "analysis": {"fact": "True."}, "confidence": 60}`, VerdictFact, "True."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := parseShortAnalysisResponse(tt.content)
+			if err != nil {
+				t.Fatalf("parse failed: %v", err)
+			}
+			if parsed.Verdict != tt.wantVerdict || parsed.Reason != tt.wantReason {
+				t.Errorf("got %s %q, want %s %q", parsed.Verdict, parsed.Reason, tt.wantVerdict, tt.wantReason)
+			}
+
+			// The legacy analysis object has exactly the verdict's key set
+			legacy := map[Verdict]*[]string{
+				VerdictFact:    parsed.Analysis.Fact,
+				VerdictFalse:   parsed.Analysis.False,
+				VerdictOpinion: parsed.Analysis.Opinion,
+				VerdictNone:    parsed.Analysis.None,
+			}
+			for verdict, reasons := range legacy {
+				if (reasons != nil) != (verdict == tt.wantVerdict) {
+					t.Errorf("legacy analysis.%s = %v", verdict, reasons)
+				}
+			}
+			if reasons := legacy[tt.wantVerdict]; reasons == nil || (*reasons)[0] != tt.wantReason {
+				t.Errorf("legacy reason = %v, want %q", reasons, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestParseShortAnalysisResponseRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantMsg string
+	}{
+		{"empty analysis", `{"analysis": {}, "confidence": 80, "sources": []}`, "Missing analysis conclusion"},
+		{"all null", `{"analysis": {"fact": null, "false": null}, "confidence": 80}`, "Missing analysis conclusion"},
+		{"two legacy keys", `{"analysis": {"fact": "a", "false": "b"}, "confidence": 80}`, "Multiple analysis conclusions"},
+		{"verdict disagrees with analysis", `{"verdict": "fact", "analysis": {"opinion": "b"}, "confidence": 80}`, "Multiple analysis conclusions"},
+		{"unknown verdict", `{"verdict": "mostly true", "reason": "x", "confidence": 80}`, "Unknown analysis verdict"},
+		{"confidence out of range", `{"verdict": "fact", "reason": "x", "confidence": 120}`, "Invalid confidence"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseShortAnalysisResponse(tt.content)
+			var extErr *ExtensionError
+			if !errors.As(err, &extErr) || extErr.Type != InvalidResponse || !strings.Contains(extErr.Message, tt.wantMsg) {
+				t.Errorf("expected INVALID_RESPONSE %q, got %v", tt.wantMsg, err)
+			}
+		})
+	}
+}
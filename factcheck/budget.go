@@ -0,0 +1,186 @@
+package factcheck
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Rejects analyses once an API key has spent its daily budget when set (DAILY_BUDGET_USD
+// or BUDGETS_FILE)
+var Budgets *BudgetTracker
+
+// Daily limits in USD, 0 for none. Only the keys in Keys have a budget of their own;
+// requests with any other key, or none, share the "" budget, whose limit is Keys[""] if
+// set and Default otherwise. Keys are not checked, so unlisted ones can't get a budget
+// of their own by changing on each request.
+type BudgetLimits struct {
+	Default float64
+	Keys    map[string]float64
+}
+
+// The budget a key is charged to: its own if it is listed, the shared "" one otherwise
+func (l BudgetLimits) account(apiKey string) string {
+	if _, ok := l.Keys[apiKey]; ok {
+		return apiKey
+	}
+	return ""
+}
+
+// The daily limit of the key's budget, 0 for none
+func (l BudgetLimits) Limit(apiKey string) float64 {
+	if limit, ok := l.Keys[l.account(apiKey)]; ok {
+		return limit
+	}
+	return l.Default
+}
+
+// Daily spend per budget, kept in memory and reset at midnight UTC. The limits are
+// passed in by the caller, so a reload replaces them along with the rest of the
+// runtime state while today's spend is kept.
+//
+// Analyses in flight hold a reservation of their estimated cost (see Reserve), so that
+// concurrent requests can't all pass the check and spend well past the limit. The
+// estimate is the mean cost of the analyses charged so far, or 1% of the limit before
+// the first one. A budget may still be exceeded by how much the analyses in flight
+// cost more than the estimate.
+type BudgetTracker struct {
+	mu  sync.Mutex
+	day string
+	// Spend and reservations by account, see BudgetLimits.account
+	spent    map[string]float64
+	reserved map[string]float64
+	// Total cost and number of the charged analyses, for the estimate
+	costs    float64
+	analyses int
+	now      func() time.Time
+}
+
+func NewBudgetTracker() *BudgetTracker {
+	return &BudgetTracker{spent: map[string]float64{}, reserved: map[string]float64{}, now: time.Now}
+}
+
+// Reads a JSON object of API keys to daily limits in USD
+func LoadBudgetLimits(path string) (map[string]float64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var limits map[string]float64
+	if err := json.Unmarshal(data, &limits); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for key, limit := range limits {
+		if limit < 0 {
+			return nil, fmt.Errorf("%s: negative budget for key %s", path, redactKey(key))
+		}
+	}
+	return limits, nil
+}
+
+// Returns a BUDGET_EXCEEDED error if the key has used up today's budget, counting the
+// reservations of the analyses in flight
+func (b *BudgetTracker) Check(limits BudgetLimits, apiKey string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.check(limits, apiKey)
+}
+
+// Checks the budget like Check and, if it isn't used up, reserves the estimated cost of
+// an analysis. Returns the reservation, which must be handed to Settle afterwards.
+func (b *BudgetTracker) Reserve(limits BudgetLimits, apiKey string) (float64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.check(limits, apiKey); err != nil {
+		return 0, err
+	}
+	limit := limits.Limit(apiKey)
+	if limit <= 0 {
+		return 0, nil
+	}
+	estimate := limit / 100
+	if b.analyses > 0 {
+		estimate = b.costs / float64(b.analyses)
+	}
+	b.reserved[limits.account(apiKey)] += estimate
+	return estimate, nil
+}
+
+// Replaces a reservation from Reserve with the actual cost of the analysis
+func (b *BudgetTracker) Settle(limits BudgetLimits, apiKey string, reservation float64, cost float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	account := limits.account(apiKey)
+	if b.reserved[account] -= reservation; b.reserved[account] <= 1e-12 {
+		delete(b.reserved, account)
+	}
+	b.charge(account, cost)
+}
+
+// Adds cost to the spend of the key's budget for today
+func (b *BudgetTracker) Charge(limits BudgetLimits, apiKey string, cost float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.charge(limits.account(apiKey), cost)
+}
+
+// The spend of the key's budget for today in USD
+func (b *BudgetTracker) Spent(limits BudgetLimits, apiKey string) float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollover()
+	return b.spent[limits.account(apiKey)]
+}
+
+// Check with mu held
+func (b *BudgetTracker) check(limits BudgetLimits, apiKey string) error {
+	limit := limits.Limit(apiKey)
+	if limit <= 0 {
+		return nil
+	}
+	b.rollover()
+	account := limits.account(apiKey)
+	if spent := b.spent[account]; spent+b.reserved[account] >= limit {
+		budgetRejections.Inc()
+		return &ExtensionError{
+			Type:        BudgetExceeded,
+			Message:     fmt.Sprintf("daily budget of $%.2f used up ($%.4f spent, $%.4f in flight)", limit, spent, b.reserved[account]),
+			Retryable:   false,
+			UserMessage: fmt.Sprintf("The daily budget of $%.2f for this API key is used up. It resets at 00:00 UTC.", limit),
+		}
+	}
+	return nil
+}
+
+// Charge with mu held
+func (b *BudgetTracker) charge(account string, cost float64) {
+	b.rollover()
+	b.spent[account] += cost
+	b.costs += cost
+	b.analyses++
+}
+
+// Time until the budgets reset
+func (b *BudgetTracker) ResetIn() time.Duration {
+	now := b.now().UTC()
+	return now.Truncate(24 * time.Hour).Add(24 * time.Hour).Sub(now)
+}
+
+// Forgets yesterday's spend, but not the reservations still in flight; must be called
+// with mu held
+func (b *BudgetTracker) rollover() {
+	if day := b.now().UTC().Format(time.DateOnly); day != b.day {
+		b.day = day
+		b.spent = map[string]float64{}
+	}
+}
+
+// The first characters of an API key, for logs and errors
+func redactKey(key string) string {
+	if len(key) <= 4 {
+		return "****"
+	}
+	return key[:4] + "****"
+}
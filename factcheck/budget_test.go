@@ -0,0 +1,135 @@
+package factcheck
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBudgetTracker(t *testing.T) {
+	now := time.Date(2025, 8, 1, 23, 0, 0, 0, time.UTC)
+	limits := BudgetLimits{Default: 1, Keys: map[string]float64{"big": 10, "free": 0}}
+	b := NewBudgetTracker()
+	b.now = func() time.Time { return now }
+
+	if err := b.Check(limits, "small"); err != nil {
+		t.Fatalf("fresh key rejected: %v", err)
+	}
+	b.Charge(limits, "small", 0.6)
+	b.Charge(limits, "small", 0.6)
+	var extErr *ExtensionError
+	if err := b.Check(limits, "small"); !errors.As(err, &extErr) || extErr.Type != BudgetExceeded || extErr.Retryable {
+		t.Errorf("expected a BUDGET_EXCEEDED error, got %v", err)
+	}
+
+	b.Charge(limits, "big", 5)
+	b.Charge(limits, "free", 100)
+	if b.Check(limits, "big") != nil || b.Check(limits, "free") != nil {
+		t.Error("keys under their own limit, or without one, should pass")
+	}
+
+	if reset := b.ResetIn(); reset != time.Hour {
+		t.Errorf("reset in %v, want 1h", reset)
+	}
+	now = now.Add(2 * time.Hour)
+	if err := b.Check(limits, "small"); err != nil || b.Spent(limits, "small") != 0 {
+		t.Errorf("spend should reset at midnight UTC, got %v spent, %v", b.Spent(limits, "small"), err)
+	}
+}
+
+func TestBudgetNewLimitsKeepSpend(t *testing.T) {
+	b := NewBudgetTracker()
+	b.Charge(BudgetLimits{Default: 1}, "key", 0.8)
+	limits := BudgetLimits{Default: 0.5, Keys: map[string]float64{"other": 2}}
+	if err := b.Check(limits, "key"); err == nil {
+		t.Error("the lower limit should apply to what was already spent")
+	}
+	if b.Spent(limits, "key") != 0.8 || limits.Limit("other") != 2 {
+		t.Errorf("spent %v, other limit %v", b.Spent(limits, "key"), limits.Limit("other"))
+	}
+}
+
+func TestBudgetUnlistedKeysShareOneBudget(t *testing.T) {
+	limits := BudgetLimits{Default: 1, Keys: map[string]float64{"listed": 5}}
+	b := NewBudgetTracker()
+	for i := range 100 {
+		b.Charge(limits, fmt.Sprintf("invented-%d", i), 0.02)
+	}
+	b.Charge(limits, "", 0.5)
+	if err := b.Check(limits, "yet-another-key"); err == nil {
+		t.Errorf("a new key got a fresh budget after $%.2f of anonymous spend", b.Spent(limits, ""))
+	}
+	if err := b.Check(limits, "listed"); err != nil || b.Spent(limits, "listed") != 0 {
+		t.Errorf("listed key charged for anonymous spend: %v, $%.2f", err, b.Spent(limits, "listed"))
+	}
+	if len(b.spent) != 1 {
+		t.Errorf("tracking %d budgets, want only the anonymous one", len(b.spent))
+	}
+
+	// Keys added on a reload get their own budget from then on
+	limits = BudgetLimits{Default: 1, Keys: map[string]float64{"listed": 5, "invented-1": 2}}
+	if err := b.Check(limits, "invented-1"); err != nil {
+		t.Errorf("newly listed key: %v", err)
+	}
+
+	// The "" entry is the limit of the shared budget
+	if got := (BudgetLimits{Default: 1, Keys: map[string]float64{"": 3}}).Limit("anyone"); got != 3 {
+		t.Errorf("shared limit = %v, want 3", got)
+	}
+}
+
+func TestBudgetReservationsHoldConcurrentRequests(t *testing.T) {
+	limits := BudgetLimits{Default: 1}
+	b := NewBudgetTracker()
+	b.Charge(limits, "key", 0.2)
+
+	// 50 requests at once, each costing the $0.20 the first one did
+	var wg sync.WaitGroup
+	var accepted atomic.Int32
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reservation, err := b.Reserve(limits, "key")
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			time.Sleep(10 * time.Millisecond)
+			b.Settle(limits, "key", reservation, 0.2)
+		}()
+	}
+	wg.Wait()
+
+	if accepted.Load() != 4 {
+		t.Errorf("%d of 50 concurrent requests accepted, want 4", accepted.Load())
+	}
+	if spent := b.Spent(limits, "key"); spent > 1+1e-9 {
+		t.Errorf("spent $%.2f of a $1 budget", spent)
+	}
+	if len(b.reserved) != 0 {
+		t.Errorf("reservations left after settling: %v", b.reserved)
+	}
+}
+
+func TestBudgetReservationEstimate(t *testing.T) {
+	limits := BudgetLimits{Default: 2}
+	b := NewBudgetTracker()
+	// 1% of the limit before any analysis was charged
+	if reservation, err := b.Reserve(limits, "key"); err != nil || reservation != 0.02 {
+		t.Errorf("first reservation = %v, %v", reservation, err)
+	} else {
+		b.Settle(limits, "key", reservation, 0.1)
+	}
+	b.Charge(limits, "key", 0.3)
+	if reservation, _ := b.Reserve(limits, "key"); reservation != 0.2 {
+		t.Errorf("reservation = %v, want the mean cost 0.2", reservation)
+	}
+	// Keys without a limit reserve nothing
+	if reservation, err := b.Reserve(BudgetLimits{}, "key"); err != nil || reservation != 0 {
+		t.Errorf("unlimited reservation = %v, %v", reservation, err)
+	}
+}
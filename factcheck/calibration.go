@@ -0,0 +1,239 @@
+package factcheck
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Maps model-reported confidence to observed accuracy when set (CALIBRATION_FILE)
+var Calibrations *CalibrationSet
+
+// A fitted confidence mapping for one provider and prompt version
+type Calibration struct {
+	Provider      string `json:"provider"`
+	PromptVersion string `json:"promptVersion"`
+	// "isotonic" or "platt"
+	Method string `json:"method"`
+	// Number of labeled answers it was fitted on
+	Samples int `json:"samples"`
+	// Isotonic: accuracy at increasing confidences, interpolated in between
+	Points []CalibrationPoint `json:"points,omitempty"`
+	// Platt: accuracy = 1 / (1 + exp(-(A * confidence/100 + B)))
+	A float64 `json:"a,omitempty"`
+	B float64 `json:"b,omitempty"`
+}
+
+type CalibrationPoint struct {
+	Confidence float64 `json:"confidence"`
+	Accuracy   float64 `json:"accuracy"`
+}
+
+// One labeled answer: the confidence the model gave and whether it was right
+type CalibrationSample struct {
+	Confidence int
+	Correct    bool
+}
+
+// Calibrations by provider and prompt version, stored as {"calibrations": [...]}
+type CalibrationSet struct {
+	mu      sync.RWMutex
+	entries map[string]*Calibration
+}
+
+type calibrationFile struct {
+	Calibrations []*Calibration `json:"calibrations"`
+}
+
+func NewCalibrationSet() *CalibrationSet {
+	return &CalibrationSet{entries: map[string]*Calibration{}}
+}
+
+func LoadCalibrations(path string) (*CalibrationSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file calibrationFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	set := NewCalibrationSet()
+	for _, c := range file.Calibrations {
+		switch {
+		case c.Method == "isotonic" && len(c.Points) > 0:
+		case c.Method == "platt":
+		default:
+			return nil, fmt.Errorf("%s: invalid calibration for %s/%s", path, c.Provider, c.PromptVersion)
+		}
+		set.Set(c)
+	}
+	return set, nil
+}
+
+// Writes the calibrations sorted by provider and prompt version
+func (s *CalibrationSet) Save(path string) error {
+	s.mu.RLock()
+	file := calibrationFile{Calibrations: []*Calibration{}}
+	for _, c := range s.entries {
+		file.Calibrations = append(file.Calibrations, c)
+	}
+	s.mu.RUnlock()
+	sort.Slice(file.Calibrations, func(i, j int) bool {
+		a, b := file.Calibrations[i], file.Calibrations[j]
+		return a.Provider < b.Provider || (a.Provider == b.Provider && a.PromptVersion < b.PromptVersion)
+	})
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// Adds or replaces the calibration for its provider and prompt version
+func (s *CalibrationSet) Set(c *Calibration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[c.Provider+"\x00"+c.PromptVersion] = c
+}
+
+// Returns nil if there is no calibration for the pair
+func (s *CalibrationSet) Lookup(provider string, promptVersion string) *Calibration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.entries[provider+"\x00"+promptVersion]
+}
+
+// Replaces the confidence with its calibrated value when there is a calibration for the
+// variant's provider (or ensemble) and the prompt version. Returns the method used.
+func calibrateConfidence(variant Variant, promptVersion string, confidence *int) string {
+	if Calibrations == nil {
+		return ""
+	}
+	provider := variant.Provider.Name()
+	if variant.Ensemble != nil {
+		provider = variant.Ensemble.String()
+	}
+	c := Calibrations.Lookup(provider, promptVersion)
+	if c == nil {
+		return ""
+	}
+	*confidence = c.Apply(*confidence)
+	return c.Method
+}
+
+// Calibrates a confidence (0-100)
+func (c *Calibration) Apply(confidence int) int {
+	x := float64(confidence)
+	var accuracy float64
+	if c.Method == "platt" {
+		accuracy = 1 / (1 + math.Exp(-(c.A*x/100 + c.B)))
+	} else {
+		points := c.Points
+		i := sort.Search(len(points), func(i int) bool { return points[i].Confidence >= x })
+		switch {
+		case i == 0:
+			accuracy = points[0].Accuracy
+		case i == len(points):
+			accuracy = points[len(points)-1].Accuracy
+		default:
+			lo, hi := points[i-1], points[i]
+			accuracy = lo.Accuracy + (hi.Accuracy-lo.Accuracy)*(x-lo.Confidence)/(hi.Confidence-lo.Confidence)
+		}
+	}
+	return min(max(int(math.Round(accuracy*100)), 0), 100)
+}
+
+// Fits a monotone mapping with pool-adjacent-violators. Each pooled block of answers
+// becomes a point at its mean confidence.
+func FitIsotonic(samples []CalibrationSample) *Calibration {
+	sorted := append([]CalibrationSample(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Confidence < sorted[j].Confidence })
+
+	type block struct {
+		confidenceSum, correct, count float64
+	}
+	mean := func(b block) float64 { return b.correct / b.count }
+
+	blocks := []block{}
+	for i, s := range sorted {
+		correct := 0.0
+		if s.Correct {
+			correct = 1
+		}
+		// Answers with the same confidence always share a block
+		if i > 0 && s.Confidence == sorted[i-1].Confidence {
+			last := &blocks[len(blocks)-1]
+			last.confidenceSum += float64(s.Confidence)
+			last.correct += correct
+			last.count++
+		} else {
+			blocks = append(blocks, block{confidenceSum: float64(s.Confidence), correct: correct, count: 1})
+		}
+		// Pool with the previous block while accuracy would go down
+		for len(blocks) > 1 && mean(blocks[len(blocks)-2]) >= mean(blocks[len(blocks)-1]) {
+			last := blocks[len(blocks)-1]
+			blocks = blocks[:len(blocks)-1]
+			prev := &blocks[len(blocks)-1]
+			prev.confidenceSum += last.confidenceSum
+			prev.correct += last.correct
+			prev.count += last.count
+		}
+	}
+
+	c := &Calibration{Method: "isotonic", Samples: len(samples)}
+	for _, b := range blocks {
+		c.Points = append(c.Points, CalibrationPoint{Confidence: b.confidenceSum / b.count, Accuracy: mean(b)})
+	}
+	return c
+}
+
+// Fits a logistic curve on confidence/100 with Newton's method, using Platt's smoothed
+// targets so that perfectly separable samples still give a finite fit
+func FitPlatt(samples []CalibrationSample) *Calibration {
+	positives := 0
+	for _, s := range samples {
+		if s.Correct {
+			positives++
+		}
+	}
+	hiTarget := (float64(positives) + 1) / (float64(positives) + 2)
+	loTarget := 1 / (float64(len(samples)-positives) + 2)
+
+	a, b := 0.0, 0.0
+	for range 100 {
+		// Gradient and Hessian of the log loss, with a little ridge for stability
+		var ga, gb, haa, hab, hbb float64 = 1e-6 * a, 0, 1e-6, 0, 1e-6
+		for _, s := range samples {
+			x := float64(s.Confidence) / 100
+			p := 1 / (1 + math.Exp(-(a*x + b)))
+			t := loTarget
+			if s.Correct {
+				t = hiTarget
+			}
+			w := p * (1 - p)
+			ga += (p - t) * x
+			gb += p - t
+			haa += w * x * x
+			hab += w * x
+			hbb += w
+		}
+		det := haa*hbb - hab*hab
+		if det == 0 {
+			break
+		}
+		da := (hbb*ga - hab*gb) / det
+		db := (haa*gb - hab*ga) / det
+		a -= da
+		b -= db
+		if math.Abs(da) < 1e-9 && math.Abs(db) < 1e-9 {
+			break
+		}
+	}
+	return &Calibration{Method: "platt", Samples: len(samples), A: a, B: b}
+}
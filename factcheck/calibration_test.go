@@ -0,0 +1,89 @@
+package factcheck
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Overconfident answers: right half the time at 90, always at 60
+func calibrationSamples() []CalibrationSample {
+	samples := []CalibrationSample{}
+	for i := range 20 {
+		samples = append(samples, CalibrationSample{Confidence: 90, Correct: i%2 == 0})
+		samples = append(samples, CalibrationSample{Confidence: 60, Correct: true})
+		samples = append(samples, CalibrationSample{Confidence: 30, Correct: i%4 == 0})
+	}
+	return samples
+}
+
+func TestFitIsotonic(t *testing.T) {
+	c := FitIsotonic(calibrationSamples())
+
+	// 60 and 90 violate monotonicity and are pooled
+	want := []CalibrationPoint{{Confidence: 30, Accuracy: 0.25}, {Confidence: 75, Accuracy: 0.75}}
+	if !reflect.DeepEqual(c.Points, want) {
+		t.Fatalf("points = %+v, want %+v", c.Points, want)
+	}
+	for confidence, wantCalibrated := range map[int]int{10: 25, 30: 25, 50: 47, 75: 75, 100: 75} {
+		if got := c.Apply(confidence); got != wantCalibrated {
+			t.Errorf("Apply(%d) = %d, want %d", confidence, got, wantCalibrated)
+		}
+	}
+}
+
+func TestFitPlatt(t *testing.T) {
+	c := FitPlatt(calibrationSamples())
+	if c.A <= 0 {
+		t.Errorf("expected accuracy to rise with confidence, got a = %v", c.A)
+	}
+	// A logistic fit can't follow the dip at 90, but it stays between the extremes
+	low, high := c.Apply(30), c.Apply(90)
+	if low < 25 || high > 85 || low >= high {
+		t.Errorf("Apply(30) = %d, Apply(90) = %d", low, high)
+	}
+}
+
+func TestCalibrationFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calibration.json")
+	set := NewCalibrationSet()
+	c := FitIsotonic(calibrationSamples())
+	c.Provider, c.PromptVersion = "stub", "text-short-v2"
+	set.Set(c)
+	if err := set.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadCalibrations(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.Lookup("stub", "text-short-v2"); !reflect.DeepEqual(got, c) {
+		t.Errorf("loaded %+v, want %+v", got, c)
+	}
+	if loaded.Lookup("stub", "text-long-v1") != nil {
+		t.Error("expected no calibration for another prompt version")
+	}
+}
+
+func TestAnalyzeAppliesCalibration(t *testing.T) {
+	prompts, err := LoadPrompts("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Calibrations = NewCalibrationSet()
+	defer func() { Calibrations = nil }()
+	c := FitIsotonic(calibrationSamples())
+	c.Provider, c.PromptVersion = "stub", "text-short-v3"
+	Calibrations.Set(c)
+
+	variant := Variant{Name: "test", Provider: stubProvider{response: `{"verdict": "fact", "reason": "True.", "confidence": 90}`}, Prompts: prompts}
+	parsed, err := AiAnalyzeTextShort(context.Background(), "some text", Languages{}, variant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.RawConfidence != 90 || parsed.Confidence != 75 || parsed.Calibration != "isotonic" {
+		t.Errorf("confidence %d (raw %d, %q), want 75 (raw 90, isotonic)", parsed.Confidence, parsed.RawConfidence, parsed.Calibration)
+	}
+}
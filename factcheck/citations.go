@@ -0,0 +1,191 @@
+package factcheck
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A cited source, numbered as in the [n] markers of the reasons
+type Source struct {
+	ID    int    `json:"id"`
+	URL   string `json:"url"`
+	Title string `json:"title"`
+	// False for sources no reason cites (only kept with UnreferencedSources = "flag")
+	Referenced bool `json:"referenced"`
+	// Liveness and reputation, when SourceCheck is enabled
+	Check *SourceStatus `json:"check,omitempty"`
+}
+
+// What to do with sources that no reason cites: "drop" (default) or "flag"
+var UnreferencedSources = "drop"
+
+var (
+	// [1] or [1, 2], a citation at the end of a sentence or clause
+	citationPattern = regexp.MustCompile(`\s*\[(\d+(?:\s*,\s*\d+)*)\]`)
+	// [1](https://...) with an optional markdown title: [1](https://... "Title")
+	markdownSourcePattern = regexp.MustCompile(`^\[(\d+)\]\s*\(\s*(\S+?)(?:\s+"([^"]*)")?\s*\)$`)
+	// 1. https://..., [1] https://... or 1: https://...
+	looseSourcePattern = regexp.MustCompile(`^\[?(\d+)[\].:)]?\s+(\S+)$`)
+)
+
+// Cross-checks the [n] markers in the reasons against the sources. Markers without a
+// matching source are removed from the reasons, malformed sources are dropped and
+// uncited ones are dropped or flagged. Returns the checked sources and what was fixed.
+func checkCitations(reasons []*[]string, rawSources []string) ([]Source, []string) {
+	warnings := []string{}
+	byID := map[int]*Source{}
+	order := []int{}
+	add := func(src Source) {
+		if !isWebURL(src.URL) {
+			warnings = append(warnings, fmt.Sprintf("dropped source %d: %q is not an http(s) URL", src.ID, src.URL))
+			return
+		}
+		if byID[src.ID] != nil {
+			warnings = append(warnings, fmt.Sprintf("dropped duplicate source %d (%s)", src.ID, src.URL))
+			return
+		}
+		if src.Title == "" {
+			src.Title = sourceTitle(src.URL)
+		}
+		byID[src.ID] = &src
+		order = append(order, src.ID)
+	}
+
+	// Numbered sources first, so that a bare URL never takes a number the model cites
+	bare := []Source{}
+	taken := map[int]bool{}
+	for _, raw := range rawSources {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		src, ok := parseSource(raw)
+		if !ok {
+			src = Source{URL: raw}
+		}
+		if src.ID == 0 {
+			bare = append(bare, src)
+			continue
+		}
+		taken[src.ID] = true
+		add(src)
+	}
+	// Then bare URLs, each with the next free number
+	nextID := 1
+	for _, src := range bare {
+		for taken[nextID] {
+			nextID++
+		}
+		taken[nextID] = true
+		src.ID = nextID
+		add(src)
+	}
+
+	// Remove markers that point at nothing, remember the ones that resolve
+	for _, list := range reasons {
+		for i, reason := range *list {
+			(*list)[i] = resolveCitations(reason, byID, &warnings)
+		}
+	}
+
+	sources := []Source{}
+	for _, id := range order {
+		src := byID[id]
+		if !src.Referenced {
+			if UnreferencedSources == "flag" {
+				warnings = append(warnings, fmt.Sprintf("source %d is not cited by any reason", id))
+			} else {
+				warnings = append(warnings, fmt.Sprintf("dropped source %d: not cited by any reason", id))
+				continue
+			}
+		}
+		sources = append(sources, *src)
+	}
+	sort.SliceStable(sources, func(i, j int) bool { return sources[i].ID < sources[j].ID })
+	return sources, warnings
+}
+
+// Keeps the markers of the reason that resolve to a source. A marker that is not at the
+// end of a sentence or clause and names an unknown source, like "Article [5] of", is
+// not a citation and is left as it is.
+func resolveCitations(reason string, byID map[int]*Source, warnings *[]string) string {
+	var out strings.Builder
+	last := 0
+	for _, m := range citationPattern.FindAllStringSubmatchIndex(reason, -1) {
+		ids := []int{}
+		known := true
+		for _, idText := range strings.Split(reason[m[2]:m[3]], ",") {
+			id, _ := strconv.Atoi(strings.TrimSpace(idText))
+			ids = append(ids, id)
+			known = known && byID[id] != nil
+		}
+		rest := strings.TrimLeft(reason[m[1]:], " \t")
+		if !known && rest != "" && !strings.ContainsRune(".,;:!?)[", rune(rest[0])) {
+			continue
+		}
+
+		kept := []string{}
+		for _, id := range ids {
+			if src := byID[id]; src != nil {
+				src.Referenced = true
+				kept = append(kept, strconv.Itoa(id))
+			} else {
+				*warnings = append(*warnings, fmt.Sprintf("removed citation [%d] without a matching source", id))
+			}
+		}
+		marker := reason[m[0]:m[1]]
+		out.WriteString(reason[last:m[0]])
+		if len(kept) > 0 {
+			out.WriteString(marker[:len(marker)-len(strings.TrimLeft(marker, " \t"))] + "[" + strings.Join(kept, ", ") + "]")
+		}
+		last = m[1]
+	}
+	out.WriteString(reason[last:])
+	return out.String()
+}
+
+func parseSource(raw string) (Source, bool) {
+	if m := markdownSourcePattern.FindStringSubmatch(raw); m != nil {
+		id, _ := strconv.Atoi(m[1])
+		return Source{ID: id, URL: m[2], Title: strings.TrimSpace(m[3])}, true
+	}
+	if m := looseSourcePattern.FindStringSubmatch(raw); m != nil {
+		id, _ := strconv.Atoi(m[1])
+		return Source{ID: id, URL: m[2]}, true
+	}
+	return Source{}, false
+}
+
+func isWebURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && !strings.ContainsAny(raw, " <>\"")
+}
+
+// Falls back to the host name when the model gave no title
+func sourceTitle(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(u.Hostname(), "www.")
+}
+
+// Formats sources the way the prompts ask for them, "[n](url)"
+func legacySources(sources []Source) []string {
+	legacy := make([]string, 0, len(sources))
+	for _, s := range sources {
+		legacy = append(legacy, fmt.Sprintf("[%d](%s)", s.ID, s.URL))
+	}
+	return legacy
+}
+
+func logCitationWarnings(warnings []string) {
+	for _, w := range warnings {
+		slog.Debug("citation fixed", "warning", w)
+	}
+}
@@ -0,0 +1,124 @@
+package factcheck
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckCitations(t *testing.T) {
+	reasons := []string{
+		"Landing date is correct. [1]",
+		"Crew is named correctly. [2, 4]",
+		"Made-up citation. [7]",
+		"No citation at all.",
+	}
+	sources := []string{
+		"[1](https://www.nasa.gov/apollo-11 \"Apollo 11 mission\")",
+		"2. https://en.wikipedia.org/wiki/Apollo_11",
+		"[3](https://example.com/uncited)",
+		"[4](javascript:alert(1))",
+		"[1](https://duplicate.example.com)",
+		"",
+	}
+
+	got, warnings := checkCitations([]*[]string{&reasons}, sources)
+
+	want := []Source{
+		{ID: 1, URL: "https://www.nasa.gov/apollo-11", Title: "Apollo 11 mission", Referenced: true},
+		{ID: 2, URL: "https://en.wikipedia.org/wiki/Apollo_11", Title: "en.wikipedia.org", Referenced: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sources = %+v\nwant %+v", got, want)
+	}
+
+	wantReasons := []string{
+		"Landing date is correct. [1]",
+		"Crew is named correctly. [2]",
+		"Made-up citation.",
+		"No citation at all.",
+	}
+	if !reflect.DeepEqual(reasons, wantReasons) {
+		t.Errorf("reasons = %q\nwant %q", reasons, wantReasons)
+	}
+
+	// invalid URL, duplicate, [4] and [7] dangling, uncited 3
+	if len(warnings) != 5 {
+		t.Errorf("expected 5 warnings, got %q", warnings)
+	}
+	if legacy := legacySources(got); !reflect.DeepEqual(legacy, []string{"[1](https://www.nasa.gov/apollo-11)", "[2](https://en.wikipedia.org/wiki/Apollo_11)"}) {
+		t.Errorf("legacy sources = %q", legacy)
+	}
+}
+
+func TestCheckCitationsLeavesOtherBrackets(t *testing.T) {
+	tests := []struct {
+		reason string
+		want   string
+	}{
+		{"Article [5] of the treaty forbids it. [1]", "Article [5] of the treaty forbids it. [1]"},
+		{"The [2019] report [1] agrees.", "The [2019] report [1] agrees."},
+		{"Known sources cite mid-sentence [1] too.", "Known sources cite mid-sentence [1] too."},
+		{"Dangling at the end [9]. Cited [1].", "Dangling at the end. Cited [1]."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.reason, func(t *testing.T) {
+			reasons := []string{tt.reason}
+			_, warnings := checkCitations([]*[]string{&reasons}, []string{"[1](https://example.com)"})
+			if reasons[0] != tt.want {
+				t.Errorf("reason = %q, want %q", reasons[0], tt.want)
+			}
+			for _, w := range warnings {
+				if w != "removed citation [9] without a matching source" {
+					t.Errorf("unexpected warning %q", w)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckCitationsFlagUnreferenced(t *testing.T) {
+	UnreferencedSources = "flag"
+	defer func() { UnreferencedSources = "drop" }()
+
+	reasons := []string{"Cited. [2]"}
+	got, warnings := checkCitations([]*[]string{&reasons}, []string{"https://bare.example.com/page", "[2](https://example.com)"})
+
+	if len(got) != 2 || got[0].ID != 1 || got[0].Referenced || !got[1].Referenced {
+		t.Errorf("expected bare URL kept as uncited source 1, got %+v", got)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("expected one warning for the uncited source, got %q", warnings)
+	}
+}
+
+func TestCheckCitationsNumbersBareURLsLast(t *testing.T) {
+	reasons := []string{"Cited. [1]", "Also cited. [2]", "The dropped one. [3]"}
+	got, _ := checkCitations([]*[]string{&reasons}, []string{"https://bare.example.com/page", "[1](https://example.com/one)", "[3](ftp://example.com)"})
+
+	want := []Source{
+		{ID: 1, URL: "https://example.com/one", Title: "example.com", Referenced: true},
+		{ID: 2, URL: "https://bare.example.com/page", Title: "bare.example.com", Referenced: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sources = %+v\nwant %+v", got, want)
+	}
+	// [3] was the model's, it doesn't move to a bare URL
+	if reasons[2] != "The dropped one." {
+		t.Errorf("reasons = %q", reasons)
+	}
+}
+
+func TestParseAnalysisResponseCitations(t *testing.T) {
+	parsed, err := parseAnalysisResponse(`{"reasoning": {"factual": ["True [1]."], "unfactual": ["Wrong date [3]."], "subjective": [], "objective": []},
+		"credibilityScore": 60, "categories": {"factuality": 60, "objectivity": 80}, "confidence": 70,
+		"sources": ["[1](https://example.com/a)", "[2](https://example.com/b)"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Reasoning.Unfactual[0] != "Wrong date." {
+		t.Errorf("dangling citation not removed: %q", parsed.Reasoning.Unfactual[0])
+	}
+	if len(parsed.StructuredSources) != 1 || len(parsed.Sources) != 1 || len(parsed.CitationWarnings) != 2 {
+		t.Errorf("unexpected sources %+v / %q / %q", parsed.StructuredSources, parsed.Sources, parsed.CitationWarnings)
+	}
+}
@@ -0,0 +1,433 @@
+package factcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fans every analysis out to several providers, or several samples of one, and merges the answers
+type Ensemble struct {
+	// Queried concurrently; a provider listed more than once is sampled more than once
+	Members []Provider
+	// "median" (default) or "trimmed-mean"
+	Aggregate string
+}
+
+// How the answers of an ensemble were combined
+type EnsembleSummary struct {
+	Members  int `json:"members"`
+	Answered int `json:"answered"`
+	// Share of answers that agree with the result (same verdict, or same credibility band), 0-1
+	Agreement float64 `json:"agreement"`
+	Aggregate string  `json:"aggregate"`
+	// Aggregated confidence before it was scaled by the agreement
+	RawConfidence int `json:"rawConfidence"`
+}
+
+// Builds an ensemble that samples each named provider, looked up in providers, `samples`
+// times. Without names, the fallback provider is sampled instead.
+func NewEnsemble(providers *Registry, providerNames []string, samples int, aggregate string, fallback Provider) (*Ensemble, error) {
+	if samples <= 0 {
+		samples = 1
+	}
+	switch aggregate {
+	case "":
+		aggregate = "median"
+	case "median", "trimmed-mean":
+	default:
+		return nil, fmt.Errorf("unknown ensemble aggregate '%s', use 'median' or 'trimmed-mean'", aggregate)
+	}
+
+	members := []Provider{}
+	for _, name := range providerNames {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		p, err := providers.ByName(name)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, p)
+	}
+	if len(members) == 0 {
+		members = []Provider{fallback}
+	}
+
+	e := &Ensemble{Aggregate: aggregate}
+	for _, p := range members {
+		for range samples {
+			e.Members = append(e.Members, p)
+		}
+	}
+	if len(e.Members) < 2 {
+		return nil, fmt.Errorf("an ensemble needs at least two members, got %d", len(e.Members))
+	}
+	return e, nil
+}
+
+// Member names, e.g. "gemini x3, pollinations"
+func (e *Ensemble) String() string {
+	names := []string{}
+	counts := map[string]int{}
+	for _, p := range e.Members {
+		if counts[p.Name()] == 0 {
+			names = append(names, p.Name())
+		}
+		counts[p.Name()]++
+	}
+	for i, name := range names {
+		if counts[name] > 1 {
+			names[i] = fmt.Sprintf("%s x%d", name, counts[name])
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// Sends the prompts to the variant's provider, or to every ensemble member concurrently,
+// and parses the answers. Fails only when no member gave a usable answer; output that
+// couldn't be parsed is returned as a *ParseError.
+func callMembers[T any](ctx context.Context, variant Variant, kind PromptKind, systemPrompt string, userPrompt string, image *Image, parse func(string) (*T, error)) ([]*T, error) {
+	members := []Provider{variant.Provider}
+	if variant.Ensemble != nil {
+		members = variant.Ensemble.Members
+	}
+
+	answers := make([]*T, len(members))
+	errs := make([]error, len(members))
+	var wg sync.WaitGroup
+	for i, provider := range members {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			member := variant
+			member.Provider = provider
+			completion, err := callProvider(ctx, provider, 0, systemPrompt, userPrompt, image)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			if answers[i], err = parseWithFix(ctx, member, completion.Text, parse); err != nil {
+				slog.WarnContext(ctx, "unusable model output", "provider", provider.Name(), "error", err)
+				parseFailures.WithLabelValues(provider.Name(), string(kind)).Inc()
+				if errors.Is(err, errMalformedJSON) {
+					err = malformedJSONError()
+				}
+				errs[i] = &ParseError{Err: err}
+			}
+		}()
+	}
+	wg.Wait()
+
+	parsed := []*T{}
+	for i, err := range errs {
+		if err == nil {
+			parsed = append(parsed, answers[i])
+		}
+	}
+	if len(parsed) == 0 {
+		return nil, errs[0]
+	}
+	return parsed, nil
+}
+
+// Calls the provider with tracing, metrics and logging. Retry is the number of calls
+// already made for the same answer (1 for the JSON fix re-prompt). The image, if not
+// nil, is sent along with the prompts.
+func callProvider(ctx context.Context, provider Provider, retry int, systemPrompt string, userPrompt string, image *Image) (Completion, error) {
+	var vision VisionProvider
+	if image != nil {
+		var err error
+		if vision, err = visionOf(provider); err != nil {
+			return Completion{}, err
+		}
+	}
+	ctx, span := startProviderSpan(ctx, provider, retry)
+	start := time.Now()
+	var completion Completion
+	var err error
+	if image != nil {
+		completion, err = vision.CallWithImage(ctx, systemPrompt, userPrompt, *image)
+	} else {
+		completion, err = provider.Call(ctx, systemPrompt, userPrompt)
+	}
+	observeProviderCall(provider.Name(), time.Since(start), err)
+	endProviderSpan(span, completion, err)
+
+	if err != nil {
+		slog.WarnContext(ctx, "provider call failed", "provider", provider.Name(), "latency", time.Since(start), "error", err)
+		return completion, err
+	}
+	costUSD := recordUsage(ctx, provider.Name(), completion)
+	slog.DebugContext(ctx, "provider answered", "provider", provider.Name(), "model", completion.Model, "latency", time.Since(start),
+		"promptTokens", completion.Usage.PromptTokens, "completionTokens", completion.Usage.CompletionTokens, "costUsd", costUSD,
+		contentAttr("response", completion.Text))
+	return completion, nil
+}
+
+// The stats outcome for an error returned by callMembers
+func failureOutcome(err error) SampleOutcome {
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) {
+		return OutcomeParseFailure
+	}
+	return OutcomeUpstreamError
+}
+
+// Merges full analyses: scores are aggregated, reasons and sources are combined without
+// duplicates, and the confidence is scaled by the share of answers in the result's
+// credibility band.
+func (e *Ensemble) aggregateAnalyses(answers []*AnalysisResponse) *AnalysisResponse {
+	scores := make([]int, len(answers))
+	factuality := make([]int, len(answers))
+	objectivity := make([]int, len(answers))
+	confidence := make([]int, len(answers))
+	for i, a := range answers {
+		scores[i] = a.CredibilityScore
+		factuality[i] = a.Categories.Factuality
+		objectivity[i] = a.Categories.Objectivity
+		confidence[i] = a.Confidence
+	}
+
+	result := &AnalysisResponse{
+		CredibilityScore: e.aggregate(scores),
+		Categories: Categories{
+			Factuality:  e.aggregate(factuality),
+			Objectivity: e.aggregate(objectivity),
+		},
+	}
+
+	agreeing := 0
+	for _, score := range scores {
+		if credibilityBand(score) == credibilityBand(result.CredibilityScore) {
+			agreeing++
+		}
+	}
+
+	merger := newSourceMerger(&result.Reasoning.Factual, &result.Reasoning.Unfactual, &result.Reasoning.Subjective, &result.Reasoning.Objective)
+	for _, a := range answers {
+		merger.add(a.StructuredSources, a.CitationWarnings, &a.Reasoning.Factual, &a.Reasoning.Unfactual, &a.Reasoning.Subjective, &a.Reasoning.Objective)
+	}
+	result.StructuredSources, result.CitationWarnings = merger.finish()
+	result.Sources = legacySources(result.StructuredSources)
+
+	result.Ensemble = e.summary(len(answers), agreeing, e.aggregate(confidence))
+	result.Confidence = scaledConfidence(result.Ensemble)
+	return result
+}
+
+// Merges short analyses by majority vote. Ties go to the verdict with the higher total
+// confidence. Only the answers that voted for the winning verdict contribute reasons,
+// sources and confidence.
+func (e *Ensemble) aggregateShortAnalyses(answers []*ShortAnalysisResponse) *ShortAnalysisResponse {
+	votes := map[Verdict]int{}
+	support := map[Verdict]int{}
+	for _, a := range answers {
+		votes[a.Verdict]++
+		support[a.Verdict] += a.Confidence
+	}
+	winner := answers[0].Verdict
+	for _, v := range verdicts {
+		if votes[v] > votes[winner] || (votes[v] == votes[winner] && support[v] > support[winner]) {
+			winner = v
+		}
+	}
+
+	result := &ShortAnalysisResponse{Verdict: winner}
+	confidence := []int{}
+	reasons := []string{}
+	merger := newSourceMerger(&reasons)
+	for _, a := range answers {
+		if a.Verdict != winner {
+			continue
+		}
+		confidence = append(confidence, a.Confidence)
+		reason := []string{a.Reason}
+		merger.add(a.StructuredSources, a.CitationWarnings, &reason)
+	}
+	result.StructuredSources, result.CitationWarnings = merger.finish()
+	result.Sources = legacySources(result.StructuredSources)
+	result.Reason = strings.Join(reasons, " ")
+	result.Analysis = legacyAnalysis(result.Verdict, result.Reason)
+
+	result.Ensemble = e.summary(len(answers), votes[winner], e.aggregate(confidence))
+	result.Confidence = scaledConfidence(result.Ensemble)
+	return result
+}
+
+func (e *Ensemble) summary(answered int, agreeing int, rawConfidence int) *EnsembleSummary {
+	return &EnsembleSummary{
+		Members:       len(e.Members),
+		Answered:      answered,
+		Agreement:     float64(agreeing) / float64(answered),
+		Aggregate:     e.Aggregate,
+		RawConfidence: rawConfidence,
+	}
+}
+
+// Lowers the confidence in proportion to the disagreement between members
+func scaledConfidence(s *EnsembleSummary) int {
+	return int(math.Round(float64(s.RawConfidence) * s.Agreement))
+}
+
+// The median, or the mean without the lowest and highest fifth (at least one each
+// side once there are three scores)
+func (e *Ensemble) aggregate(values []int) int {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]int(nil), values...)
+	sort.Ints(sorted)
+	n := len(sorted)
+
+	if e.Aggregate == "trimmed-mean" {
+		trim := n / 5
+		if trim == 0 && n >= 3 {
+			trim = 1
+		}
+		total := 0
+		for _, v := range sorted[trim : n-trim] {
+			total += v
+		}
+		return int(math.Round(float64(total) / float64(n-2*trim)))
+	}
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return int(math.Round(float64(sorted[n/2-1]+sorted[n/2]) / 2))
+}
+
+// The scoring guideline band a credibility score falls in (see prompts/partials/scoring.tmpl)
+func credibilityBand(score int) int {
+	switch {
+	case score >= 90:
+		return 4
+	case score >= 70:
+		return 3
+	case score >= 50:
+		return 2
+	case score >= 30:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Combines the reasons and sources of several answers into the merged reason lists.
+// Sources are deduplicated by URL and the citation markers of each answer are
+// renumbered to match.
+type sourceMerger struct {
+	merged   []*[]string
+	sources  []Source
+	byURL    map[string]int
+	seen     map[string]bool
+	warnings []string
+}
+
+func newSourceMerger(merged ...*[]string) *sourceMerger {
+	return &sourceMerger{merged: merged, byURL: map[string]int{}, seen: map[string]bool{}, warnings: []string{}}
+}
+
+// Appends the reasons of one answer (in the same order as the merged lists), skipping
+// reasons that are already there
+func (m *sourceMerger) add(sources []Source, warnings []string, reasons ...*[]string) {
+	ids := map[int]int{}
+	for _, s := range sources {
+		idx, ok := m.byURL[s.URL]
+		if !ok {
+			idx = len(m.sources)
+			m.byURL[s.URL] = idx
+			merged := s
+			merged.ID = idx + 1
+			merged.Referenced = false
+			m.sources = append(m.sources, merged)
+		}
+		ids[s.ID] = idx + 1
+	}
+
+	for i, list := range reasons {
+		for _, reason := range *list {
+			text := reasonKey(reason)
+			key := fmt.Sprintf("%d\x00%s", i, text)
+			if text == "" || m.seen[key] {
+				continue
+			}
+			m.seen[key] = true
+			*m.merged[i] = append(*m.merged[i], renumberCitations(reason, ids))
+		}
+	}
+	for _, w := range warnings {
+		if !m.seen["warning\x00"+w] {
+			m.seen["warning\x00"+w] = true
+			m.warnings = append(m.warnings, w)
+		}
+	}
+}
+
+// Numbers the sources the merged reasons still cite from 1 and drops (or flags) the rest
+func (m *sourceMerger) finish() ([]Source, []string) {
+	for _, list := range m.merged {
+		for _, reason := range *list {
+			for _, marker := range citationPattern.FindAllStringSubmatch(reason, -1) {
+				for _, idText := range strings.Split(marker[1], ",") {
+					id, _ := strconv.Atoi(strings.TrimSpace(idText))
+					if id >= 1 && id <= len(m.sources) {
+						m.sources[id-1].Referenced = true
+					}
+				}
+			}
+		}
+	}
+
+	sources := []Source{}
+	ids := map[int]int{}
+	for _, s := range m.sources {
+		if !s.Referenced && UnreferencedSources != "flag" {
+			continue
+		}
+		ids[s.ID] = len(sources) + 1
+		s.ID = len(sources) + 1
+		sources = append(sources, s)
+	}
+	for _, list := range m.merged {
+		for i, reason := range *list {
+			(*list)[i] = renumberCitations(reason, ids)
+		}
+	}
+	return sources, m.warnings
+}
+
+// Rewrites the [n] markers of a reason through ids, dropping numbers that aren't in it
+func renumberCitations(reason string, ids map[int]int) string {
+	return citationPattern.ReplaceAllStringFunc(reason, func(marker string) string {
+		kept := []string{}
+		done := map[int]bool{}
+		for _, idText := range strings.Split(citationPattern.FindStringSubmatch(marker)[1], ",") {
+			id, _ := strconv.Atoi(strings.TrimSpace(idText))
+			if newID, ok := ids[id]; ok && !done[newID] {
+				done[newID] = true
+				kept = append(kept, strconv.Itoa(newID))
+			}
+		}
+		if len(kept) == 0 {
+			return ""
+		}
+		leading := marker[:len(marker)-len(strings.TrimLeft(marker, " \t"))]
+		return leading + "[" + strings.Join(kept, ", ") + "]"
+	})
+}
+
+// Compares reasons without their citations, case and punctuation at the end
+func reasonKey(reason string) string {
+	text := citationPattern.ReplaceAllString(reason, "")
+	text = strings.ToLower(strings.Join(strings.Fields(text), " "))
+	return strings.TrimRight(text, ".!? ")
+}
@@ -0,0 +1,115 @@
+package factcheck
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func ensembleVariant(t *testing.T, members ...Provider) Variant {
+	t.Helper()
+	prompts, err := LoadPrompts("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Variant{Name: "test", Provider: members[0], Prompts: prompts, Ensemble: &Ensemble{Members: members, Aggregate: "median"}}
+}
+
+func TestEnsembleShortMajorityVote(t *testing.T) {
+	variant := ensembleVariant(t,
+		stubProvider{response: `{"verdict": "fact", "reason": "Confirmed by NASA [1].", "confidence": 90, "sources": ["[1](https://nasa.gov/a)"]}`},
+		stubProvider{response: `{"verdict": "false", "reason": "Never happened.", "confidence": 95, "sources": []}`},
+		stubProvider{response: `{"verdict": "fact", "reason": "Encyclopedias agree [1]. ", "confidence": 80, "sources": ["[1](https://wikipedia.org/b)"]}`},
+		stubProvider{err: &ExtensionError{Type: RateLimited, Message: "API rate limit exceeded"}},
+	)
+
+	parsed, err := AiAnalyzeTextShort(context.Background(), "some text", Languages{}, variant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Verdict != VerdictFact || parsed.Analysis.Fact == nil {
+		t.Errorf("verdict = %s, want fact", parsed.Verdict)
+	}
+	if parsed.Reason != "Confirmed by NASA [1]. Encyclopedias agree [2]." {
+		t.Errorf("reason = %q", parsed.Reason)
+	}
+	if !reflect.DeepEqual(parsed.Sources, []string{"[1](https://nasa.gov/a)", "[2](https://wikipedia.org/b)"}) {
+		t.Errorf("sources = %q", parsed.Sources)
+	}
+
+	want := EnsembleSummary{Members: 4, Answered: 3, Agreement: 2.0 / 3, Aggregate: "median", RawConfidence: 85}
+	if parsed.Ensemble == nil || *parsed.Ensemble != want {
+		t.Errorf("ensemble = %+v, want %+v", parsed.Ensemble, want)
+	}
+	if parsed.Confidence != 57 {
+		t.Errorf("confidence = %d, want 85 scaled by 2/3", parsed.Confidence)
+	}
+}
+
+func TestEnsembleMergesAnalyses(t *testing.T) {
+	variant := ensembleVariant(t,
+		stubProvider{response: `{"reasoning": {"factual": ["Date is right [1]."], "unfactual": [], "subjective": [], "objective": ["Neutral."]}, "credibilityScore": 80, "categories": {"factuality": 90, "objectivity": 70}, "confidence": 90, "sources": ["[1](https://a.example.com)"]}`},
+		stubProvider{response: `{"reasoning": {"factual": ["Place is right [1].", "date is right [2]"], "unfactual": [], "subjective": [], "objective": []}, "credibilityScore": 85, "categories": {"factuality": 80, "objectivity": 60}, "confidence": 70, "sources": ["[1](https://b.example.com)", "[2](https://a.example.com)"]}`},
+		stubProvider{response: `{"reasoning": {"factual": [], "unfactual": ["Made up [1]."], "subjective": [], "objective": []}, "credibilityScore": 20, "categories": {"factuality": 10, "objectivity": 50}, "confidence": 60, "sources": ["[1](https://c.example.com)"]}`},
+	)
+
+	parsed, err := AiAnalyzeTextLong(context.Background(), "some text", Languages{}, variant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.CredibilityScore != 80 || parsed.Categories != (Categories{Factuality: 80, Objectivity: 60}) {
+		t.Errorf("scores = %d %+v, want medians", parsed.CredibilityScore, parsed.Categories)
+	}
+	wantReasoning := Reasoning{
+		Factual:   []string{"Date is right [1].", "Place is right [2]."},
+		Unfactual: []string{"Made up [3]."},
+		Objective: []string{"Neutral."},
+	}
+	if !reflect.DeepEqual(parsed.Reasoning, wantReasoning) {
+		t.Errorf("reasoning = %+v\nwant %+v", parsed.Reasoning, wantReasoning)
+	}
+	if !reflect.DeepEqual(parsed.Sources, []string{"[1](https://a.example.com)", "[2](https://b.example.com)", "[3](https://c.example.com)"}) {
+		t.Errorf("sources = %q", parsed.Sources)
+	}
+	// 80 and 85 share a credibility band, 20 doesn't
+	if parsed.Ensemble.Agreement != 2.0/3 || parsed.Confidence != 47 {
+		t.Errorf("agreement %v, confidence %d; want 2/3 and 70 scaled to 47", parsed.Ensemble.Agreement, parsed.Confidence)
+	}
+}
+
+func TestEnsembleAllMembersFail(t *testing.T) {
+	variant := ensembleVariant(t, stubProvider{response: "no JSON here"}, stubProvider{response: "nor here"})
+
+	_, err := AiAnalyzeTextShort(context.Background(), "some text", Languages{}, variant)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Errorf("expected a parse error, got %v", err)
+	}
+}
+
+func TestEnsembleAggregate(t *testing.T) {
+	values := []int{10, 60, 70, 80, 100}
+	if got := (&Ensemble{Aggregate: "median"}).aggregate(values); got != 70 {
+		t.Errorf("median = %d, want 70", got)
+	}
+	if got := (&Ensemble{Aggregate: "median"}).aggregate([]int{60, 71}); got != 66 {
+		t.Errorf("even median = %d, want 66", got)
+	}
+	if got := (&Ensemble{Aggregate: "trimmed-mean"}).aggregate(values); got != 70 {
+		t.Errorf("trimmed mean = %d, want mean of 60, 70, 80", got)
+	}
+}
+
+func TestNewEnsemble(t *testing.T) {
+	e, err := NewEnsemble(NewRegistry(), nil, 3, "", stubProvider{})
+	if err != nil || len(e.Members) != 3 || e.Aggregate != "median" || e.String() != "stub x3" {
+		t.Errorf("unexpected ensemble %+v, %v", e, err)
+	}
+	if _, err := NewEnsemble(NewRegistry(), nil, 1, "", stubProvider{}); err == nil {
+		t.Error("expected an error for a single member")
+	}
+	if _, err := NewEnsemble(NewRegistry(), []string{"gemini", "pollinations"}, 0, "mode", nil); err == nil {
+		t.Error("expected an error for an unknown aggregate")
+	}
+}
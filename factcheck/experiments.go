@@ -0,0 +1,428 @@
+package factcheck
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A provider + prompt configuration that an analysis runs with
+type Variant struct {
+	Experiment string
+	Name       string
+	Provider   Provider
+	Prompts    *PromptSet
+	// Fans each analysis out to several providers or samples instead of Provider alone
+	Ensemble *Ensemble
+}
+
+// Identifier recorded in responses and stats, "experiment/variant" or just the name
+func (v Variant) ID() string {
+	if v.Experiment == "" {
+		return v.Name
+	}
+	return v.Experiment + "/" + v.Name
+}
+
+// Experiment definitions file (EXPERIMENTS_FILE)
+//
+//	{
+//	  "experiments": [{
+//	    "name": "short-prompt-v2",
+//	    "endpoints": ["text_short"],
+//	    "assignBy": "key",
+//	    "variants": [
+//	      {"name": "pollinations", "weight": 10, "provider": "pollinations"},
+//	      {"name": "prompt-v2", "weight": 10, "promptsDir": "experiments/prompt-v2"},
+//	      {"name": "ensemble", "weight": 10, "ensemble": {"providers": ["gemini", "pollinations"]}}
+//	    ],
+//	    "keys": {"editor-key": "prompt-v2"}
+//	  }]
+//	}
+//
+// Weights are percentages of traffic; whatever is left over uses the default variant.
+type experimentsFile struct {
+	Experiments []experimentConfig `json:"experiments"`
+}
+
+type experimentConfig struct {
+	Name string `json:"name"`
+	// Prompt kinds the experiment applies to, all of them if empty
+	Endpoints []PromptKind `json:"endpoints"`
+	// "percent" (random per request) or "key" (sticky per API key)
+	AssignBy string            `json:"assignBy"`
+	Variants []variantConfig   `json:"variants"`
+	Keys     map[string]string `json:"keys"`
+}
+
+type variantConfig struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight"`
+	// Provider name, the default provider if empty
+	Provider string `json:"provider"`
+	// Prompt override directory, the default prompts if empty
+	PromptsDir string `json:"promptsDir"`
+	// Optional ensemble, e.g. {"providers": ["gemini", "pollinations"]} or {"samples": 3}
+	Ensemble *ensembleConfig `json:"ensemble"`
+}
+
+type ensembleConfig struct {
+	// Provider names, the variant's provider if empty
+	Providers []string `json:"providers"`
+	// Samples per provider
+	Samples   int    `json:"samples"`
+	Aggregate string `json:"aggregate"`
+}
+
+type experiment struct {
+	name      string
+	endpoints map[PromptKind]bool
+	byKey     bool
+	variants  []Variant
+	weights   []int
+	keys      map[string]int
+}
+
+// Assigns analysis requests to experiment variants
+type Experiments struct {
+	Default     Variant
+	experiments []*experiment
+}
+
+// Builds experiments from a definitions file, looking up the providers it names in
+// providers; with an empty path every request gets the default variant
+func LoadExperiments(path string, defaultVariant Variant, providers *Registry) (*Experiments, error) {
+	e := &Experiments{Default: defaultVariant}
+	if path == "" {
+		return e, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file experimentsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	names := map[string]bool{}
+	for _, cfg := range file.Experiments {
+		if cfg.Name == "" {
+			return nil, fmt.Errorf("experiment without a name in %s", path)
+		}
+		if names[cfg.Name] {
+			return nil, fmt.Errorf("duplicate experiment '%s'", cfg.Name)
+		}
+		names[cfg.Name] = true
+
+		exp := &experiment{
+			name:      cfg.Name,
+			endpoints: map[PromptKind]bool{},
+			keys:      map[string]int{},
+		}
+		switch cfg.AssignBy {
+		case "", "percent":
+		case "key":
+			exp.byKey = true
+		default:
+			return nil, fmt.Errorf("experiment '%s': unknown assignBy '%s'", cfg.Name, cfg.AssignBy)
+		}
+		for _, kind := range cfg.Endpoints {
+			if !isAnalysisKind(kind) {
+				return nil, fmt.Errorf("experiment '%s': unknown endpoint '%s'", cfg.Name, kind)
+			}
+			exp.endpoints[kind] = true
+		}
+
+		total := 0
+		for _, vc := range cfg.Variants {
+			if vc.Name == "" {
+				return nil, fmt.Errorf("experiment '%s': variant without a name", cfg.Name)
+			}
+			if vc.Weight < 0 {
+				return nil, fmt.Errorf("experiment '%s': variant '%s' has a negative weight", cfg.Name, vc.Name)
+			}
+			total += vc.Weight
+
+			v := Variant{Experiment: cfg.Name, Name: vc.Name, Provider: defaultVariant.Provider, Prompts: defaultVariant.Prompts, Ensemble: defaultVariant.Ensemble}
+			if vc.Provider != "" {
+				// Naming a provider opts the variant out of the default ensemble
+				v.Ensemble = nil
+				if v.Provider, err = providers.ByName(vc.Provider); err != nil {
+					return nil, fmt.Errorf("experiment '%s' variant '%s': %w", cfg.Name, vc.Name, err)
+				}
+			}
+			if vc.PromptsDir != "" {
+				if v.Prompts, err = LoadPrompts(vc.PromptsDir); err != nil {
+					return nil, fmt.Errorf("experiment '%s' variant '%s': %w", cfg.Name, vc.Name, err)
+				}
+			}
+			if ec := vc.Ensemble; ec != nil {
+				if v.Ensemble, err = NewEnsemble(providers, ec.Providers, ec.Samples, ec.Aggregate, v.Provider); err != nil {
+					return nil, fmt.Errorf("experiment '%s' variant '%s': %w", cfg.Name, vc.Name, err)
+				}
+			}
+			exp.variants = append(exp.variants, v)
+			exp.weights = append(exp.weights, vc.Weight)
+		}
+		if total > 100 {
+			return nil, fmt.Errorf("experiment '%s': variant weights add up to %d%%", cfg.Name, total)
+		}
+
+		for key, variantName := range cfg.Keys {
+			idx := -1
+			for i, v := range exp.variants {
+				if v.Name == variantName {
+					idx = i
+				}
+			}
+			if idx < 0 {
+				return nil, fmt.Errorf("experiment '%s': key assigned to unknown variant '%s'", cfg.Name, variantName)
+			}
+			exp.keys[key] = idx
+		}
+
+		e.experiments = append(e.experiments, exp)
+	}
+	return e, nil
+}
+
+// Every provider the default and experiment variants can call, including ensemble members,
+// once each and in order of appearance
+func (e *Experiments) Providers() []Provider {
+	seen := map[string]bool{}
+	result := []Provider{}
+	add := func(v Variant) {
+		members := []Provider{v.Provider}
+		if v.Ensemble != nil {
+			members = v.Ensemble.Members
+		}
+		for _, p := range members {
+			if !seen[p.Name()] {
+				seen[p.Name()] = true
+				result = append(result, p)
+			}
+		}
+	}
+	add(e.Default)
+	for _, exp := range e.experiments {
+		for _, v := range exp.variants {
+			add(v)
+		}
+	}
+	return result
+}
+
+// Picks the variant for a request. The first experiment covering the endpoint decides;
+// requests that fall outside its variants' share get the default variant.
+func (e *Experiments) Assign(ctx context.Context, kind PromptKind, apiKey string) Variant {
+	for _, exp := range e.experiments {
+		if len(exp.endpoints) > 0 && !exp.endpoints[kind] {
+			continue
+		}
+
+		variant := e.Default
+		if idx, ok := exp.keys[apiKey]; ok && apiKey != "" {
+			variant = exp.variants[idx]
+		} else {
+			var bucket int
+			if exp.byKey && apiKey != "" {
+				h := fnv.New32a()
+				h.Write([]byte(exp.name + "\x00" + apiKey))
+				bucket = int(h.Sum32() % 100)
+			} else {
+				bucket = rand.IntN(100)
+			}
+			for i, w := range exp.weights {
+				if bucket < w {
+					variant = exp.variants[i]
+					break
+				}
+				bucket -= w
+			}
+		}
+
+		slog.InfoContext(ctx, "assigned experiment variant", "experiment", exp.name, "endpoint", kind, "variant", variant.ID())
+		return variant
+	}
+	return e.Default
+}
+
+func isAnalysisKind(kind PromptKind) bool {
+	for _, k := range analysisKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+type SampleOutcome int
+
+const (
+	OutcomeSuccess SampleOutcome = iota
+	OutcomeUpstreamError
+	OutcomeParseFailure
+)
+
+// The result of one analysis call, for per-variant stats
+type VariantSample struct {
+	Kind        PromptKind
+	Latency     time.Duration
+	Outcome     SampleOutcome
+	Credibility *int
+	Confidence  int
+}
+
+// Number of latencies kept per variant for percentiles
+const latencyWindow = 1000
+
+type variantCounters struct {
+	requests      int
+	upstreamErrs  int
+	parseFailures int
+	latencies     []time.Duration
+	latencyNext   int
+	credibility   scoreHistogram
+	confidence    scoreHistogram
+}
+
+// Counts of scores in 10-point buckets (the last bucket is 90-100)
+type scoreHistogram struct {
+	buckets [10]int
+	sum     int
+	count   int
+}
+
+func (h *scoreHistogram) add(score int) {
+	idx := min(max(score/10, 0), 9)
+	h.buckets[idx]++
+	h.sum += score
+	h.count++
+}
+
+// In-memory per-variant stats for the /experiments endpoint
+type VariantStats struct {
+	mu       sync.Mutex
+	variants map[string]*variantCounters
+}
+
+// Stats for every variant, recorded by the AiAnalyze* functions
+var Stats = &VariantStats{variants: map[string]*variantCounters{}}
+
+func (s *VariantStats) Record(v Variant, sample VariantSample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.variants[v.ID()]
+	if !ok {
+		c = &variantCounters{}
+		s.variants[v.ID()] = c
+	}
+	c.requests++
+	if len(c.latencies) < latencyWindow {
+		c.latencies = append(c.latencies, sample.Latency)
+	} else {
+		c.latencies[c.latencyNext] = sample.Latency
+		c.latencyNext = (c.latencyNext + 1) % latencyWindow
+	}
+
+	switch sample.Outcome {
+	case OutcomeUpstreamError:
+		c.upstreamErrs++
+	case OutcomeParseFailure:
+		c.parseFailures++
+	case OutcomeSuccess:
+		if sample.Credibility != nil {
+			c.credibility.add(*sample.Credibility)
+		}
+		c.confidence.add(sample.Confidence)
+	}
+}
+
+type LatencySummary struct {
+	P50Ms  int64 `json:"p50Ms"`
+	P95Ms  int64 `json:"p95Ms"`
+	MeanMs int64 `json:"meanMs"`
+}
+
+type ScoreDistribution struct {
+	// Counts for 0-9, 10-19, ..., 90-100
+	Buckets [10]int `json:"buckets"`
+	Mean    float64 `json:"mean"`
+	Count   int     `json:"count"`
+}
+
+type VariantSummary struct {
+	Variant          string            `json:"variant"`
+	Requests         int               `json:"requests"`
+	UpstreamErrors   int               `json:"upstreamErrors"`
+	ParseFailures    int               `json:"parseFailures"`
+	ParseFailureRate float64           `json:"parseFailureRate"`
+	Latency          LatencySummary    `json:"latency"`
+	CredibilityScore ScoreDistribution `json:"credibilityScore"`
+	Confidence       ScoreDistribution `json:"confidence"`
+}
+
+// Summarizes every variant that has seen traffic, sorted by ID
+func (s *VariantStats) Summary() []VariantSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	summaries := []VariantSummary{}
+	for id, c := range s.variants {
+		summary := VariantSummary{
+			Variant:          id,
+			Requests:         c.requests,
+			UpstreamErrors:   c.upstreamErrs,
+			ParseFailures:    c.parseFailures,
+			Latency:          summarizeLatencies(c.latencies),
+			CredibilityScore: c.credibility.distribution(),
+			Confidence:       c.confidence.distribution(),
+		}
+		// Parse failures are only possible when the provider answered
+		if answered := c.requests - c.upstreamErrs; answered > 0 {
+			summary.ParseFailureRate = float64(c.parseFailures) / float64(answered)
+		}
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Variant < summaries[j].Variant })
+	return summaries
+}
+
+func (h scoreHistogram) distribution() ScoreDistribution {
+	d := ScoreDistribution{Buckets: h.buckets, Count: h.count}
+	if h.count > 0 {
+		d.Mean = float64(h.sum) / float64(h.count)
+	}
+	return d
+}
+
+func summarizeLatencies(latencies []time.Duration) LatencySummary {
+	if len(latencies) == 0 {
+		return LatencySummary{}
+	}
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, l := range sorted {
+		total += l
+	}
+	percentile := func(p float64) int64 {
+		return sorted[int(p*float64(len(sorted)-1))].Milliseconds()
+	}
+	return LatencySummary{
+		P50Ms:  percentile(0.50),
+		P95Ms:  percentile(0.95),
+		MeanMs: (total / time.Duration(len(sorted))).Milliseconds(),
+	}
+}
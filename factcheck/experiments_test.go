@@ -0,0 +1,189 @@
+package factcheck
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var defaultVariant = Variant{Name: "default", Provider: stubProvider{}}
+
+// Loads experiments from a definitions file with the given JSON
+func loadExperiments(t *testing.T, definitions string) (*Experiments, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "experiments.json")
+	if err := os.WriteFile(path, []byte(definitions), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return LoadExperiments(path, defaultVariant, NewRegistry())
+}
+
+func TestLoadExperimentsValidation(t *testing.T) {
+	// A prompt override without a version
+	brokenPrompts := t.TempDir()
+	os.WriteFile(filepath.Join(brokenPrompts, "text_short.tmpl"), []byte(`{{define "system"}}s{{end}}{{define "user"}}u{{end}}`), 0o644)
+
+	tests := []struct {
+		name        string
+		definitions string
+		wantErr     string
+	}{
+		{"valid", `{"experiments": [{"name": "a", "endpoints": ["text_short"], "assignBy": "key", "variants": [{"name": "p", "weight": 60, "provider": "pollinations"}, {"name": "g", "weight": 40, "provider": "gemini"}], "keys": {"k": "g"}}]}`, ""},
+		{"empty", `{}`, ""},
+		{"not json", `{"experiments": [`, "parsing"},
+		{"no name", `{"experiments": [{"variants": []}]}`, "experiment without a name"},
+		{"duplicate name", `{"experiments": [{"name": "a"}, {"name": "a"}]}`, "duplicate experiment 'a'"},
+		{"unknown assignBy", `{"experiments": [{"name": "a", "assignBy": "cookie"}]}`, "unknown assignBy 'cookie'"},
+		{"unknown endpoint", `{"experiments": [{"name": "a", "endpoints": ["tweet"]}]}`, "unknown endpoint 'tweet'"},
+		{"variant without a name", `{"experiments": [{"name": "a", "variants": [{"weight": 10}]}]}`, "variant without a name"},
+		{"negative weight", `{"experiments": [{"name": "a", "variants": [{"name": "v", "weight": -5}]}]}`, "negative weight"},
+		{"over 100 percent", `{"experiments": [{"name": "a", "variants": [{"name": "v", "weight": 70}, {"name": "w", "weight": 50}]}]}`, "add up to 120%"},
+		{"unknown provider", `{"experiments": [{"name": "a", "variants": [{"name": "v", "weight": 10, "provider": "nonexistent"}]}]}`, "unknown provider 'nonexistent'"},
+		{"broken prompts", `{"experiments": [{"name": "a", "variants": [{"name": "v", "weight": 10, "promptsDir": "` + brokenPrompts + `"}]}]}`, `does not define "version"`},
+		{"key to unknown variant", `{"experiments": [{"name": "a", "variants": [{"name": "v", "weight": 10}], "keys": {"k": "w"}}]}`, "unknown variant 'w'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadExperiments(t, tt.definitions)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := LoadExperiments(filepath.Join(t.TempDir(), "missing.json"), defaultVariant, NewRegistry()); err == nil {
+		t.Error("missing file accepted")
+	}
+}
+
+func TestAssign(t *testing.T) {
+	logger := slog.Default()
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	defer slog.SetDefault(logger)
+	ctx := context.Background()
+	experiments, err := loadExperiments(t, `{"experiments": [
+		{"name": "short", "endpoints": ["text_short"], "assignBy": "key", "variants": [{"name": "half", "weight": 50}, {"name": "pinned", "weight": 0}], "keys": {"editor": "pinned"}},
+		{"name": "all", "variants": [{"name": "third", "weight": 30}]},
+		{"name": "never", "variants": [{"name": "unused", "weight": 100}]}
+	]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Count the variants over many requests
+	counts := func(kind PromptKind, apiKey func(i int) string) map[string]int {
+		seen := map[string]int{}
+		for i := range 2000 {
+			seen[experiments.Assign(ctx, kind, apiKey(i)).ID()]++
+		}
+		return seen
+	}
+	within := func(name string, got int, want int) {
+		t.Helper()
+		if got < want*8/10 || got > want*12/10 {
+			t.Errorf("%s: %d of 2000, want about %d", name, got, want)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		kind   PromptKind
+		apiKey string
+		want   string
+	}{
+		{"pinned key", PromptTextShort, "editor", "short/pinned"},
+		{"pinned key on another endpoint", PromptArticle, "editor", ""},
+		{"weight 0 without a pin", PromptTextShort, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen := counts(tt.kind, func(int) string { return tt.apiKey })
+			if tt.want != "" && seen[tt.want] != 2000 {
+				t.Errorf("variants = %v, want only %s", seen, tt.want)
+			}
+			if seen["short/pinned"] > 0 && tt.want == "" {
+				t.Errorf("variants = %v, pinned variant assigned without its key", seen)
+			}
+		})
+	}
+
+	t.Run("sticky per key", func(t *testing.T) {
+		for _, key := range []string{"a", "b", "c", "d"} {
+			first := experiments.Assign(ctx, PromptTextShort, key).ID()
+			for range 20 {
+				if got := experiments.Assign(ctx, PromptTextShort, key).ID(); got != first {
+					t.Fatalf("key %s moved from %s to %s", key, first, got)
+				}
+			}
+		}
+		seen := counts(PromptTextShort, func(i int) string { return "key-" + string(rune('a'+i%26)) + string(rune('a'+i/26)) })
+		within("keys on half", seen["short/half"], 1000)
+		within("keys on default", seen["default"], 1000)
+	})
+
+	t.Run("percent split", func(t *testing.T) {
+		// The first experiment covering the endpoint decides, so "never" is never reached
+		seen := counts(PromptArticle, func(int) string { return "" })
+		within("third", seen["all/third"], 600)
+		within("default", seen["default"], 1400)
+		if seen["never/unused"] > 0 {
+			t.Errorf("a later experiment assigned %d requests", seen["never/unused"])
+		}
+	})
+
+	t.Run("no experiments", func(t *testing.T) {
+		none, _ := LoadExperiments("", defaultVariant, NewRegistry())
+		if got := none.Assign(ctx, PromptTextShort, "editor"); got.ID() != "default" {
+			t.Errorf("variant = %s, want default", got.ID())
+		}
+	})
+}
+
+func TestVariantStats(t *testing.T) {
+	stats := &VariantStats{variants: map[string]*variantCounters{}}
+	a := Variant{Experiment: "exp", Name: "a"}
+	credibility := 85
+	for i := range 10 {
+		stats.Record(a, VariantSample{Kind: PromptArticle, Latency: time.Duration(i+1) * 10 * time.Millisecond, Outcome: OutcomeSuccess, Credibility: &credibility, Confidence: 95})
+	}
+	stats.Record(a, VariantSample{Kind: PromptArticle, Latency: time.Second, Outcome: OutcomeUpstreamError})
+	stats.Record(a, VariantSample{Kind: PromptArticle, Latency: time.Second, Outcome: OutcomeParseFailure})
+	stats.Record(defaultVariant, VariantSample{Kind: PromptTextShort, Latency: time.Millisecond, Outcome: OutcomeSuccess, Confidence: 100})
+
+	summary := stats.Summary()
+	if len(summary) != 2 || summary[0].Variant != "default" || summary[1].Variant != "exp/a" {
+		t.Fatalf("summary = %+v", summary)
+	}
+	got := summary[1]
+	if got.Requests != 12 || got.UpstreamErrors != 1 || got.ParseFailures != 1 {
+		t.Errorf("counts = %+v", got)
+	}
+	// One parse failure out of the 11 answered requests
+	if got.ParseFailureRate != 1.0/11 {
+		t.Errorf("parse failure rate = %v", got.ParseFailureRate)
+	}
+	if got.CredibilityScore.Buckets[8] != 10 || got.CredibilityScore.Mean != 85 || got.Confidence.Buckets[9] != 10 {
+		t.Errorf("distributions = %+v / %+v", got.CredibilityScore, got.Confidence)
+	}
+	if got.Latency.P50Ms != 60 || got.Latency.P95Ms != 1000 {
+		t.Errorf("latency = %+v", got.Latency)
+	}
+	if summary[0].Confidence.Buckets[9] != 1 || summary[0].CredibilityScore.Count != 0 {
+		t.Errorf("short text distributions = %+v", summary[0])
+	}
+
+	// Only the latest latencies are kept
+	for range latencyWindow {
+		stats.Record(defaultVariant, VariantSample{Latency: 5 * time.Millisecond, Outcome: OutcomeSuccess})
+	}
+	if latency := stats.Summary()[0].Latency; latency.P95Ms != 5 || latency.MeanMs != 5 {
+		t.Errorf("latency after the window = %+v", latency)
+	}
+}
@@ -0,0 +1,130 @@
+package factcheck
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Health of one dependency as reported by /health/ready
+type DependencyStatus struct {
+	Name string `json:"name"`
+	// "ok", or "error" when the provider is misconfigured or its last probe failed
+	Status string `json:"status"`
+	// Whether an upstream probe backs the status, or only the configuration was checked
+	Probed    bool       `json:"probed"`
+	LatencyMs int64      `json:"latencyMs,omitempty"`
+	CheckedAt *time.Time `json:"checkedAt,omitempty"`
+	// Most recent failure, "misconfigured" or "unreachable", kept after the dependency
+	// recovers. The error itself is only logged, as it may name URLs or keys.
+	LastError   string     `json:"lastError,omitempty"`
+	LastErrorAt *time.Time `json:"lastErrorAt,omitempty"`
+}
+
+// What LastError reports instead of the error
+const (
+	healthMisconfigured = "misconfigured"
+	healthUnreachable   = "unreachable"
+)
+
+// Checks provider configuration and, if ProbeInterval is set, probes the upstream APIs
+type HealthChecker struct {
+	Providers []Provider
+	// Probes each provider at most this often; 0 disables probes
+	ProbeInterval time.Duration
+	ProbeTimeout  time.Duration
+
+	mu     sync.Mutex
+	probes map[string]*probeState
+}
+
+// Last probe of one provider. Its lock is held while probing, so concurrent
+// readiness checks share one probe.
+type probeState struct {
+	mu          sync.Mutex
+	checkedAt   time.Time
+	latency     time.Duration
+	err         error
+	lastError   string
+	lastErrorAt time.Time
+}
+
+// Checks every provider concurrently; ready only if all of them are ok
+func (h *HealthChecker) Check(ctx context.Context) (bool, []DependencyStatus) {
+	statuses := make([]DependencyStatus, len(h.Providers))
+	var wg sync.WaitGroup
+	for i, p := range h.Providers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i] = h.checkProvider(ctx, p)
+		}()
+	}
+	wg.Wait()
+
+	ready := true
+	for _, s := range statuses {
+		if s.Status != "ok" {
+			ready = false
+		}
+	}
+	return ready, statuses
+}
+
+func (h *HealthChecker) checkProvider(ctx context.Context, p Provider) DependencyStatus {
+	status := DependencyStatus{Name: p.Name(), Status: "ok"}
+	if checker, ok := p.(ConfigChecker); ok {
+		if err := checker.CheckConfig(); err != nil {
+			slog.WarnContext(ctx, "provider misconfigured", "provider", p.Name(), "error", err)
+			now := time.Now()
+			status.Status, status.LastError, status.CheckedAt, status.LastErrorAt = "error", healthMisconfigured, &now, &now
+			return status
+		}
+	}
+	prober, ok := p.(Prober)
+	if !ok || h.ProbeInterval <= 0 {
+		return status
+	}
+
+	state := h.probeState(p.Name())
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if time.Since(state.checkedAt) >= h.ProbeInterval {
+		// The result is cached, so a client going away must not fail the probe
+		probeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), h.ProbeTimeout)
+		start := time.Now()
+		state.err = prober.Probe(probeCtx)
+		cancel()
+		state.checkedAt, state.latency = time.Now(), time.Since(start)
+		if state.err != nil {
+			slog.WarnContext(ctx, "provider probe failed", "provider", p.Name(), "error", state.err)
+			state.lastError, state.lastErrorAt = healthUnreachable, state.checkedAt
+		}
+	}
+
+	status.Probed = true
+	status.LatencyMs = state.latency.Milliseconds()
+	checkedAt := state.checkedAt
+	status.CheckedAt = &checkedAt
+	if state.err != nil {
+		status.Status = "error"
+	}
+	if state.lastError != "" {
+		lastErrorAt := state.lastErrorAt
+		status.LastError, status.LastErrorAt = state.lastError, &lastErrorAt
+	}
+	return status
+}
+
+func (h *HealthChecker) probeState(name string) *probeState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.probes == nil {
+		h.probes = map[string]*probeState{}
+	}
+	if h.probes[name] == nil {
+		h.probes[name] = &probeState{}
+	}
+	return h.probes[name]
+}
@@ -0,0 +1,78 @@
+package factcheck
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A provider whose probe fails while err is set
+type probedProvider struct {
+	stubProvider
+	name      string
+	configErr error
+	err       error
+	probes    int
+}
+
+func (p *probedProvider) Name() string { return p.name }
+
+func (p *probedProvider) CheckConfig() error { return p.configErr }
+
+func (p *probedProvider) Probe(ctx context.Context) error {
+	p.probes++
+	return p.err
+}
+
+func TestHealthProbesAreCached(t *testing.T) {
+	upstream := &probedProvider{name: "upstream", err: errors.New("connection refused")}
+	h := &HealthChecker{Providers: []Provider{upstream, stubProvider{}}, ProbeInterval: time.Hour, ProbeTimeout: time.Second}
+
+	ready, statuses := h.Check(context.Background())
+	if ready || statuses[0].Status != "error" || statuses[0].LastError != "unreachable" || !statuses[0].Probed {
+		t.Errorf("expected the failing probe to make the server not ready, got %+v", statuses[0])
+	}
+	if statuses[1].Status != "ok" || statuses[1].Probed {
+		t.Errorf("a provider without a probe is ok unprobed, got %+v", statuses[1])
+	}
+
+	// Within the interval the cached result is reported
+	upstream.err = nil
+	if ready, _ := h.Check(context.Background()); ready || upstream.probes != 1 {
+		t.Errorf("ready = %v after %d probes, want the cached failure from one probe", ready, upstream.probes)
+	}
+
+	h.probes["upstream"].checkedAt = time.Now().Add(-2 * time.Hour)
+	ready, statuses = h.Check(context.Background())
+	if !ready || upstream.probes != 2 {
+		t.Errorf("ready = %v after %d probes, want a fresh successful probe", ready, upstream.probes)
+	}
+	if statuses[0].LastError != "unreachable" || statuses[0].LastErrorAt == nil {
+		t.Errorf("expected the last error to be kept after recovery, got %+v", statuses[0])
+	}
+}
+
+func TestHealthHidesUpstreamErrors(t *testing.T) {
+	leaky := &probedProvider{name: "gemini", err: errors.New("GET https://generativelanguage.googleapis.com/v1beta/models?key=AIzaSecret: 403")}
+	h := &HealthChecker{Providers: []Provider{leaky}, ProbeInterval: time.Hour, ProbeTimeout: time.Second}
+	_, statuses := h.Check(context.Background())
+	if data, _ := json.Marshal(statuses); strings.Contains(string(data), "AIzaSecret") || strings.Contains(string(data), "googleapis") {
+		t.Errorf("upstream error in the readiness report: %s", data)
+	}
+}
+
+func TestHealthChecksConfigWithoutProbes(t *testing.T) {
+	misconfigured := &probedProvider{name: "gemini", configErr: errors.New("GEMINI_API_KEY is not set")}
+	h := &HealthChecker{Providers: []Provider{misconfigured}}
+
+	ready, statuses := h.Check(context.Background())
+	if ready || statuses[0].LastError != "misconfigured" {
+		t.Errorf("expected a configuration error, got %+v", statuses[0])
+	}
+	if misconfigured.probes != 0 {
+		t.Error("probes should be off without a ProbeInterval")
+	}
+}
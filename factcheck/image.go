@@ -0,0 +1,185 @@
+package factcheck
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// An image to analyze, e.g. a screenshot of a post, a meme or a chart
+type Image struct {
+	MIMEType string
+	Data     []byte
+}
+
+// Largest image accepted, in bytes
+var MaxImageBytes = 5 << 20
+
+// Image types that can be analyzed, as detected from the data
+var imageTypes = []string{"image/png", "image/jpeg", "image/webp"}
+
+// Image analysis request. Multipart forms have the same fields, with the image as a file.
+type AnalyzeImageRequest struct {
+	// Base64-encoded image, optionally as a data: URL
+	Image string `json:"image"`
+	// "short" (default) for a verdict like /analyze/text/short, "long" for a full analysis
+	Mode string `json:"mode,omitempty"`
+	// ISO 639-1 code of the language of the text in the image; the model tells when empty
+	Language string `json:"language,omitempty"`
+	// ISO 639-1 code of the language to write the reasons in; the image text's when empty
+	ResponseLanguage string `json:"responseLanguage,omitempty"`
+}
+
+// The languages the request asks for
+func (r AnalyzeImageRequest) Languages() Languages {
+	return Languages{Content: r.Language, Response: r.ResponseLanguage}
+}
+
+// The prompt kind of the requested mode
+func (r AnalyzeImageRequest) Kind() PromptKind {
+	if r.Mode == "long" {
+		return PromptImageLong
+	}
+	return PromptImageShort
+}
+
+// Checks the mode and language codes; the image is checked by NewImage once decoded
+func (r AnalyzeImageRequest) Validate() error {
+	v := &validator{}
+	if r.Mode != "" && r.Mode != "short" && r.Mode != "long" {
+		v.add("mode", FieldInvalidValue, "mode must be short or long")
+	}
+	v.language("language", r.Language)
+	v.language("responseLanguage", r.ResponseLanguage)
+	return v.err()
+}
+
+// Checks the size of data and detects its type. The type the client claims is
+// ignored, as it is often wrong for screenshots.
+func NewImage(data []byte) (Image, error) {
+	v := &validator{}
+	mimeType := http.DetectContentType(data)
+	switch {
+	case len(data) == 0:
+		v.add("image", FieldRequired, "image is required")
+	case len(data) > MaxImageBytes:
+		v.add("image", FieldTooLarge, "image must be at most %d MB", MaxImageBytes>>20)
+	case !slices.Contains(imageTypes, mimeType):
+		v.add("image", FieldUnsupportedType, "image must be a PNG, JPEG or WebP image")
+	}
+	if err := v.err(); err != nil {
+		return Image{}, err
+	}
+	return Image{MIMEType: mimeType, Data: data}, nil
+}
+
+// Hex SHA-256 of the image data, part of the key of image fixtures
+func (img Image) SHA256() string {
+	sum := sha256.Sum256(img.Data)
+	return hex.EncodeToString(sum[:])
+}
+
+// The provider as a VisionProvider, or a capability error if it can't look at images
+func visionOf(provider Provider) (VisionProvider, error) {
+	vision, ok := provider.(VisionProvider)
+	// A recording provider can look at images if the provider it records can
+	if recording, isRecording := provider.(*RecordingProvider); isRecording {
+		_, ok = recording.Inner.(VisionProvider)
+	}
+	if ok {
+		return vision, nil
+	}
+	return nil, &ExtensionError{
+		Type:        CapabilityUnsupported,
+		Message:     fmt.Sprintf("Provider %s can't analyze images", provider.Name()),
+		Retryable:   false,
+		UserMessage: "Image analysis is not available on this server",
+	}
+}
+
+// Fails unless every provider of the variant can look at images
+func checkVision(variant Variant) error {
+	members := []Provider{variant.Provider}
+	if variant.Ensemble != nil {
+		members = variant.Ensemble.Members
+	}
+	for _, provider := range members {
+		if _, err := visionOf(provider); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Claims of every ensemble answer, without duplicates; nil when there are none
+func mergeClaims(claims ...[]string) []string {
+	var merged []string
+	for _, list := range claims {
+		for _, claim := range list {
+			if !slices.Contains(merged, claim) {
+				merged = append(merged, claim)
+			}
+		}
+	}
+	return merged
+}
+
+// Extracts the claims in the image and gives a verdict on them
+func AiAnalyzeImageShort(ctx context.Context, image Image, requested Languages, variant Variant) (*ShortAnalysisResponse, error) {
+	if err := checkVision(variant); err != nil {
+		return nil, err
+	}
+	ctx, meter := usageMeterFor(ctx)
+	prompt, err := variant.Prompts.Get(PromptImageShort)
+	if err != nil {
+		return nil, err
+	}
+	languages, _ := resolveLanguages(ctx, "", requested)
+	systemPrompt, analysisPrompt, err := renderPrompt(ctx, prompt, PromptData{
+		Language:         LanguageName(languages.Content),
+		ResponseLanguage: LanguageName(languages.Response),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	call := analysisCall{variant: variant, kind: PromptImageShort, prompt: prompt, languages: languages, meter: meter, start: time.Now()}
+	answers, err := callMembers(ctx, variant, PromptImageShort, systemPrompt, analysisPrompt, &image, parseShortAnalysisResponse)
+	if err != nil {
+		call.fail(err)
+		return nil, err
+	}
+	return call.finishShort(ctx, answers), nil
+}
+
+// Extracts the claims in the image and analyzes their credibility
+func AiAnalyzeImageLong(ctx context.Context, image Image, requested Languages, variant Variant) (*AnalysisResponse, error) {
+	if err := checkVision(variant); err != nil {
+		return nil, err
+	}
+	ctx, meter := usageMeterFor(ctx)
+	prompt, err := variant.Prompts.Get(PromptImageLong)
+	if err != nil {
+		return nil, err
+	}
+	languages, _ := resolveLanguages(ctx, "", requested)
+	systemPrompt, analysisPrompt, err := renderPrompt(ctx, prompt, PromptData{
+		Language:         LanguageName(languages.Content),
+		ResponseLanguage: LanguageName(languages.Response),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	call := analysisCall{variant: variant, kind: PromptImageLong, prompt: prompt, languages: languages, meter: meter, start: time.Now()}
+	answers, err := callMembers(ctx, variant, PromptImageLong, systemPrompt, analysisPrompt, &image, parseAnalysisResponse)
+	if err != nil {
+		call.fail(err)
+		return nil, err
+	}
+	return call.finishLong(ctx, answers), nil
+}
@@ -0,0 +1,129 @@
+package factcheck
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Enough of a PNG for its type to be detected
+var pngData = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+// A stub provider that can look at images
+type visionStub struct {
+	stubProvider
+	images int
+}
+
+func (p *visionStub) CallWithImage(ctx context.Context, systemPrompt string, userPrompt string, image Image) (Completion, error) {
+	p.images++
+	return p.Call(ctx, systemPrompt, userPrompt)
+}
+
+func TestNewImage(t *testing.T) {
+	image, err := NewImage(pngData)
+	if err != nil || image.MIMEType != "image/png" {
+		t.Errorf("NewImage(png) = %+v, %v", image, err)
+	}
+
+	defer func(max int) { MaxImageBytes = max }(MaxImageBytes)
+	MaxImageBytes = len(pngData) + 1
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"empty", nil, "image:required"},
+		{"too large", append(pngData, "more than allowed"...), "image:too_large"},
+		{"text", []byte("not an image"), "image:unsupported_type"},
+		{"gif", []byte("GIF89a"), "image:unsupported_type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewImage(tt.data)
+			if got := fieldCodes(t, err); len(got) != 1 || got[0] != tt.want {
+				t.Errorf("problems = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateImageRequest(t *testing.T) {
+	if err := (AnalyzeImageRequest{Mode: "long", ResponseLanguage: "es"}).Validate(); err != nil {
+		t.Errorf("valid request rejected: %v", err)
+	}
+	got := fieldCodes(t, (AnalyzeImageRequest{Mode: "medium", Language: "xx"}).Validate())
+	if strings.Join(got, ",") != "mode:invalid_value,language:invalid_value" {
+		t.Errorf("problems = %v", got)
+	}
+}
+
+func TestVisionOf(t *testing.T) {
+	var extErr *ExtensionError
+	if _, err := visionOf(stubProvider{}); !errors.As(err, &extErr) || extErr.Type != CapabilityUnsupported || extErr.Retryable {
+		t.Errorf("text-only provider: %v", err)
+	}
+	if _, err := visionOf(&RecordingProvider{Inner: stubProvider{}}); err == nil {
+		t.Error("recording a text-only provider can look at images")
+	}
+	if _, err := visionOf(&RecordingProvider{Inner: &visionStub{}}); err != nil {
+		t.Errorf("recording a vision provider: %v", err)
+	}
+}
+
+func TestRecordThenReplayImage(t *testing.T) {
+	dir := t.TempDir()
+	image, _ := NewImage(pngData)
+	recorder := &RecordingProvider{Inner: &visionStub{stubProvider: stubProvider{response: `{"confidence": 80}`}}, Dir: dir}
+	if _, err := recorder.CallWithImage(context.Background(), "system", "user", image); err != nil {
+		t.Fatal(err)
+	}
+
+	replay := &ReplayProvider{Dir: dir}
+	if got, err := replay.CallWithImage(context.Background(), "system", "user", image); err != nil || got.Text != `{"confidence": 80}` {
+		t.Errorf("replay = %+v, %v", got, err)
+	}
+	// The same prompts without the image, or with another one, are other exchanges
+	if _, err := replay.Call(context.Background(), "system", "user"); err == nil {
+		t.Error("image fixture replayed without the image")
+	}
+	other, _ := NewImage(append(pngData, 0))
+	if _, err := replay.CallWithImage(context.Background(), "system", "user", other); err == nil {
+		t.Error("image fixture replayed for another image")
+	}
+}
+
+func TestAiAnalyzeImage(t *testing.T) {
+	prompts, err := LoadPrompts("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, _ := NewImage(pngData)
+
+	_, err = AiAnalyzeImageShort(context.Background(), image, Languages{}, Variant{Name: "text", Provider: stubProvider{}, Prompts: prompts})
+	var extErr *ExtensionError
+	if !errors.As(err, &extErr) || extErr.Type != CapabilityUnsupported {
+		t.Errorf("text-only provider: %v", err)
+	}
+
+	stub := &visionStub{stubProvider: stubProvider{response: `{"claims": ["The Moon is made of cheese"], "verdict": "false", "reason": "It is rock [1].", "confidence": 95, "sources": ["[1](https://science.nasa.gov/moon/)"]}`}}
+	result, err := AiAnalyzeImageShort(context.Background(), image, Languages{Response: "en"}, Variant{Name: "vision", Provider: stub, Prompts: prompts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stub.images != 1 || result.Verdict != VerdictFalse || len(result.Claims) != 1 || result.ResponseLanguage != "en" || result.PromptVersion != "image-short-v1" {
+		t.Errorf("unexpected result %+v after %d image calls", result, stub.images)
+	}
+}
+
+func TestMergeClaims(t *testing.T) {
+	got := mergeClaims([]string{"a", "b"}, nil, []string{"b", "c"})
+	if strings.Join(got, ",") != "a,b,c" {
+		t.Errorf("merged = %v", got)
+	}
+	// Text answers have no claims to report
+	if got := mergeClaims(nil, nil); got != nil {
+		t.Errorf("merged without claims = %v", got)
+	}
+}
@@ -0,0 +1,279 @@
+package factcheck
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Asks the provider to fix its own output once when it can't be repaired locally
+var JSONFixReprompt bool
+
+// Returned by the parsers when no usable JSON object could be recovered from the model
+// output. It triggers the JSON fix re-prompt and is replaced by malformedJSONError before
+// it reaches callers.
+var errMalformedJSON = errors.New("no JSON object in the model output")
+
+// The error callers get for errMalformedJSON; a new one each time, as callers may change it
+func malformedJSONError() *ExtensionError {
+	return &ExtensionError{
+		Type:        InvalidResponse,
+		Message:     "Failed to parse analysis response",
+		Retryable:   true,
+		UserMessage: "Try analyzing the content again",
+	}
+}
+
+// Fields the models sometimes answer with "85", "85%" or 85.5
+var numericFields = map[string]bool{
+	"credibilityScore": true,
+	"confidence":       true,
+	"factuality":       true,
+	"objectivity":      true,
+}
+
+var fencePattern = regexp.MustCompile("(?s)```[a-zA-Z]*\\s*(.*?)```")
+
+// Recovers a JSON object from model output into v. Candidates are taken from markdown
+// fences first and then from the whole text; the first one that decodes (after lenient
+// repair) and has one of expectedKeys wins.
+func decodeModelJSON(content string, v interface{}, expectedKeys ...string) error {
+	for _, candidate := range jsonCandidates(content) {
+		obj, ok := decodeLenient(candidate)
+		if !ok || !hasAnyKey(obj, expectedKeys) {
+			continue
+		}
+		coerceNumbers(obj)
+
+		data, err := json.Marshal(obj)
+		if err != nil {
+			continue
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			slog.Debug("JSON candidate did not match the response structure", "error", err)
+			continue
+		}
+		slog.Debug("extracted JSON", contentAttr("json", string(data)))
+		return nil
+	}
+	return errMalformedJSON
+}
+
+// Lists the top-level JSON objects in the content, fenced ones first
+func jsonCandidates(content string) []string {
+	seen := map[string]bool{}
+	candidates := []string{}
+	add := func(objects []string) {
+		for _, o := range objects {
+			if !seen[o] {
+				seen[o] = true
+				candidates = append(candidates, o)
+			}
+		}
+	}
+
+	for _, m := range fencePattern.FindAllStringSubmatch(content, -1) {
+		add(scanJSONObjects(m[1]))
+	}
+	add(scanJSONObjects(content))
+	return candidates
+}
+
+// Returns every top-level {...} block in s, ignoring braces inside string literals
+func scanJSONObjects(s string) []string {
+	var objects []string
+	depth, start := 0, 0
+	var quote byte
+	escaped := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if quote != 0 {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == quote:
+				quote = 0
+			}
+			continue
+		}
+
+		switch c {
+		case '"', '\'':
+			// Quotes only start strings inside an object, prose outside may have apostrophes
+			if depth > 0 {
+				quote = c
+			}
+		case '{':
+			if depth == 0 {
+				start = i
+			}
+			depth++
+		case '}':
+			if depth > 0 {
+				depth--
+				if depth == 0 {
+					objects = append(objects, s[start:i+1])
+				}
+			}
+		}
+	}
+	return objects
+}
+
+// Decodes a candidate object, repairing common LLM mistakes if it isn't valid JSON
+func decodeLenient(candidate string) (map[string]interface{}, bool) {
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(candidate), &obj); err == nil {
+		return obj, true
+	}
+
+	repaired := repairJSON(candidate)
+	if err := json.Unmarshal([]byte(repaired), &obj); err != nil {
+		slog.Debug("failed to repair JSON", "error", err)
+		return nil, false
+	}
+	return obj, true
+}
+
+// Fixes trailing commas, single-quoted strings and Python-style literals outside of strings
+func repairJSON(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '"':
+			end, _ := stringEnd(s, i, '"')
+			b.WriteString(s[i:end])
+			i = end
+
+		case c == '\'':
+			end, closed := stringEnd(s, i, '\'')
+			inner := s[i+1 : end]
+			if closed {
+				inner = s[i+1 : end-1]
+			}
+			b.WriteString(`"` + requoteString(inner) + `"`)
+			i = end
+
+		case c == ',':
+			j := i + 1
+			for j < len(s) && strings.ContainsRune(" \t\r\n", rune(s[j])) {
+				j++
+			}
+			if j < len(s) && (s[j] == '}' || s[j] == ']') {
+				i++
+				continue
+			}
+			b.WriteByte(c)
+			i++
+
+		case isIdentByte(c):
+			j := i
+			for j < len(s) && isIdentByte(s[j]) {
+				j++
+			}
+			switch word := s[i:j]; word {
+			case "True":
+				b.WriteString("true")
+			case "False":
+				b.WriteString("false")
+			case "None":
+				b.WriteString("null")
+			default:
+				b.WriteString(word)
+			}
+			i = j
+
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
+
+// Index just past the string literal starting at s[start], or len(s) and false if unterminated
+func stringEnd(s string, start int, quote byte) (int, bool) {
+	for i := start + 1; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] == quote {
+			return i + 1, true
+		}
+	}
+	return len(s), false
+}
+
+// The contents of a single-quoted string as the contents of a double-quoted one: \'
+// becomes ' and unescaped " are escaped
+func requoteString(inner string) string {
+	var b strings.Builder
+	for i := 0; i < len(inner); i++ {
+		switch {
+		case inner[i] == '\\':
+			// A lone backslash at the end of a cut-off string would escape the closing quote
+			if i+1 < len(inner) {
+				if inner[i+1] != '\'' {
+					b.WriteByte('\\')
+				}
+				b.WriteByte(inner[i+1])
+			}
+			i++
+		case inner[i] == '"':
+			b.WriteString(`\"`)
+		default:
+			b.WriteByte(inner[i])
+		}
+	}
+	return b.String()
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func hasAnyKey(obj map[string]interface{}, keys []string) bool {
+	if len(keys) == 0 {
+		return true
+	}
+	for _, k := range keys {
+		if _, ok := obj[k]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Turns numeric strings and fractional numbers in score fields into whole numbers
+func coerceNumbers(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if numericFields[key] {
+				switch n := value.(type) {
+				case float64:
+					v[key] = math.Round(n)
+				case string:
+					trimmed := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(n), "%"))
+					if f, err := strconv.ParseFloat(trimmed, 64); err == nil {
+						v[key] = math.Round(f)
+					}
+				}
+				continue
+			}
+			coerceNumbers(value)
+		}
+	case []interface{}:
+		for _, item := range v {
+			coerceNumbers(item)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package factcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const validAnalysis = `{"reasoning": {"factual": ["a [1]"], "unfactual": [], "subjective": [], "objective": ["b"]}, "credibilityScore": 85, "categories": {"factuality": 90, "objectivity": 70}, "confidence": 80, "sources": ["[1](https://example.com)"]}`
+
+func TestParseAnalysisResponseRecovery(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"plain", validAnalysis},
+		{"surrounding prose", "Here is the analysis:\n" + validAnalysis + "\nLet me know if you need more."},
+		{"markdown fence", "```json\n" + validAnalysis + "\n```"},
+		{"fence without language", "```\n" + validAnalysis + "\n```"},
+		{"trailing commas", `{"reasoning": {"factual": ["a [1]",], "unfactual": [], "subjective": [], "objective": ["b"],}, "credibilityScore": 85, "categories": {"factuality": 90, "objectivity": 70,}, "confidence": 80, "sources": ["[1](https://example.com)",],}`},
+		{"single quotes", `{'reasoning': {'factual': ['a [1]'], 'unfactual': [], 'subjective': [], 'objective': ['b']}, 'credibilityScore': 85, 'categories': {'factuality': 90, 'objectivity': 70}, 'confidence': 80, 'sources': ['[1](https://example.com)']}`},
+		{"scores as strings", `{"reasoning": {"factual": ["a [1]"], "unfactual": [], "subjective": [], "objective": ["b"]}, "credibilityScore": "85", "categories": {"factuality": "90%", "objectivity": " 70 "}, "confidence": "80", "sources": ["[1](https://example.com)"]}`},
+		{"fractional scores", `{"reasoning": {"factual": ["a [1]"], "unfactual": [], "subjective": [], "objective": ["b"]}, "credibilityScore": 84.6, "categories": {"factuality": 90.0, "objectivity": 70.2}, "confidence": 79.5, "sources": ["[1](https://example.com)"]}`},
+		{"two objects", `{"note": "searching the web"} ` + validAnalysis + ` {"done": true}`},
+		{"braces inside strings", strings.Replace(validAnalysis, `["a [1]"]`, `["uses {curly} braces } in text [1]"]`, 1)},
+		{"fenced object wins over prose object", `I considered {"credibilityScore": 10} first.` + "\n```json\n" + validAnalysis + "\n```"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := parseAnalysisResponse(tt.content)
+			if err != nil {
+				t.Fatalf("parse failed: %v", err)
+			}
+			if parsed.CredibilityScore != 85 || parsed.Confidence != 80 ||
+				parsed.Categories.Factuality != 90 || parsed.Categories.Objectivity != 70 {
+				t.Errorf("unexpected scores %+v", parsed)
+			}
+			if len(parsed.Reasoning.Factual) != 1 || len(parsed.Sources) != 1 {
+				t.Errorf("unexpected reasoning/sources %+v", parsed)
+			}
+		})
+	}
+}
+
+func TestParseAnalysisResponseUnrecoverable(t *testing.T) {
+	for _, content := range []string{
+		"The article seems accurate.",
+		`{"reasoning": {"factual": ["truncated`,
+		`{"unrelated": true}`,
+	} {
+		if _, err := parseAnalysisResponse(content); !errors.Is(err, errMalformedJSON) {
+			t.Errorf("%q: expected errMalformedJSON, got %v", content, err)
+		}
+	}
+}
+
+func TestRepairJSON(t *testing.T) {
+	tests := []struct{ name, in, want string }{
+		{"trailing commas", `{"a": [1, 2,], }`, `{"a": [1, 2] }`},
+		{"single quotes", `{'a': 'it\'s "quoted"'}`, `{"a": "it's \"quoted\""}`},
+		{"python literals", `{"a": True, "b": None, "c": "True, None,]"}`, `{"a": true, "b": null, "c": "True, None,]"}`},
+		{"unterminated single-quoted value", `{'a': 'cut off`, `{"a": "cut off"`},
+		{"unterminated after a backslash", `{'a': 'cut off\`, `{"a": "cut off"`},
+		{"escaped double quote inside single quotes", `{'a': 'say \"hi\"'}`, `{"a": "say \"hi\""}`},
+		{"escaped backslash inside single quotes", `{'a': 'C:\\dir'}`, `{"a": "C:\\dir"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := repairJSON(tt.in); got != tt.want {
+				t.Errorf("repairJSON(%s) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseShortAnalysisResponseRecovery(t *testing.T) {
+	parsed, err := parseShortAnalysisResponse("```json\n{'analysis': {'fact': ['ok',]}, 'confidence': '75', 'sources': []}\n```")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Analysis.Fact == nil || parsed.Confidence != 75 {
+		t.Errorf("unexpected result %+v", parsed)
+	}
+}
+
+// Answers each call with the next scripted response
+type scriptedProvider struct {
+	responses []string
+	calls     int
+}
+
+func (p *scriptedProvider) Name() string { return "scripted" }
+
+func (p *scriptedProvider) Call(ctx context.Context, systemPrompt string, userPrompt string) (Completion, error) {
+	resp := p.responses[min(p.calls, len(p.responses)-1)]
+	p.calls++
+	return Completion{Text: resp}, nil
+}
+
+func TestJSONFixReprompt(t *testing.T) {
+	prompts, err := LoadPrompts("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, enabled := range []bool{false, true} {
+		JSONFixReprompt = enabled
+		provider := &scriptedProvider{responses: []string{"credibility is high, confidence 80", validAnalysis}}
+		variant := Variant{Name: "test", Provider: provider, Prompts: prompts}
+
+		parsed, err := AiAnalyzeTextLong(context.Background(), "some text", Languages{}, variant)
+		if enabled {
+			if err != nil || parsed.CredibilityScore != 85 || provider.calls != 2 {
+				t.Errorf("with re-prompt: got %+v, %v after %d calls", parsed, err, provider.calls)
+			}
+		} else if err == nil || provider.calls != 1 {
+			t.Errorf("without re-prompt: expected a parse error after one call, got %v after %d calls", err, provider.calls)
+		}
+	}
+	JSONFixReprompt = false
+}
+
+func TestMalformedJSONError(t *testing.T) {
+	prompts, err := LoadPrompts("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	analyze := func() *ExtensionError {
+		t.Helper()
+		variant := Variant{Name: "test", Provider: &scriptedProvider{responses: []string{"no JSON here"}}, Prompts: prompts}
+		_, err := AiAnalyzeTextLong(context.Background(), "some text", Languages{}, variant)
+		var extErr *ExtensionError
+		if !errors.As(err, &extErr) || extErr.Type != InvalidResponse || !extErr.Retryable {
+			t.Fatalf("expected a retryable INVALID_RESPONSE error, got %v", err)
+		}
+		return extErr
+	}
+
+	// Callers changing the error they got must not affect later requests
+	analyze().Message = "changed by a caller"
+	if got := analyze().Message; got != "Failed to parse analysis response" {
+		t.Errorf("message = %q", got)
+	}
+
+	// The re-prompt still happens when the parser wraps the error
+	JSONFixReprompt = true
+	defer func() { JSONFixReprompt = false }()
+	provider := &scriptedProvider{responses: []string{validAnalysis}}
+	parse := func(content string) (*AnalysisResponse, error) {
+		parsed, err := parseAnalysisResponse(content)
+		if err != nil {
+			return nil, fmt.Errorf("long analysis: %w", err)
+		}
+		return parsed, nil
+	}
+	parsed, err := parseWithFix(context.Background(), Variant{Name: "test", Provider: provider, Prompts: prompts}, "no JSON here", parse)
+	if err != nil || parsed.CredibilityScore != 85 || provider.calls != 1 {
+		t.Errorf("got %+v, %v after %d re-prompts", parsed, err, provider.calls)
+	}
+}
@@ -0,0 +1,68 @@
+package factcheck
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/abadojack/whatlanggo"
+)
+
+// Languages of an analysis as ISO 639-1 codes, e.g. "es"
+type Languages struct {
+	// Language of the content; detected when empty
+	Content string
+	// Language to write the reasons in; the content's when empty
+	Response string
+}
+
+// English names of the ISO 639-1 codes of the languages that can be detected
+var languageNames = func() map[string]string {
+	names := map[string]string{}
+	for lang, name := range whatlanggo.Langs {
+		if code := lang.Iso6391(); code != "" {
+			names[code] = name
+		}
+	}
+	return names
+}()
+
+// English name of an ISO 639-1 code, "" if the code is unknown
+func LanguageName(code string) string {
+	return languageNames[strings.ToLower(code)]
+}
+
+// ISO 639-1 code of the language text is written in, "" if it can't be told
+// reliably, as is common for short texts
+func DetectLanguage(text string) string {
+	info := whatlanggo.Detect(text)
+	if !info.IsReliable() {
+		return ""
+	}
+	return info.Lang.Iso6391()
+}
+
+// Fills in the languages that weren't requested: the content's is detected and the
+// response's follows it. Also returns the detected language.
+func resolveLanguages(ctx context.Context, content string, requested Languages) (Languages, string) {
+	detected := DetectLanguage(content)
+	languages := Languages{Content: strings.ToLower(requested.Content), Response: strings.ToLower(requested.Response)}
+	if languages.Content == "" {
+		languages.Content = detected
+	}
+	if languages.Response == "" {
+		languages.Response = languages.Content
+	}
+	slog.DebugContext(ctx, "languages", "detected", detected, "content", languages.Content, "response", languages.Response)
+	return languages, detected
+}
+
+// The languages the request asks for
+func (r AnalyzeArticleRequest) Languages() Languages {
+	return Languages{Content: r.Language, Response: r.ResponseLanguage}
+}
+
+// The languages the request asks for
+func (r AnalyzeTextRequest) Languages() Languages {
+	return Languages{Content: r.Language, Response: r.ResponseLanguage}
+}
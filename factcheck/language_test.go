@@ -0,0 +1,78 @@
+package factcheck
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+const germanText = "Der Bundestag hat heute ein neues Gesetz zur Förderung erneuerbarer Energien beschlossen, das ab dem nächsten Jahr gilt."
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{germanText, "de"},
+		{"Apollo 11 landed on the Moon in 1969, as NASA records and many independent observers confirm.", "en"},
+		// Too short to tell
+		{"some text", ""},
+	}
+	for _, tt := range tests {
+		if got := DetectLanguage(tt.text); got != tt.want {
+			t.Errorf("DetectLanguage(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestResolveLanguages(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		requested Languages
+		want      Languages
+	}{
+		{"detected", germanText, Languages{}, Languages{Content: "de", Response: "de"}},
+		{"response requested", germanText, Languages{Response: "EN"}, Languages{Content: "de", Response: "en"}},
+		{"content given", "some text", Languages{Content: "es"}, Languages{Content: "es", Response: "es"}},
+		{"unknown", "some text", Languages{}, Languages{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := resolveLanguages(context.Background(), tt.content, tt.requested)
+			if got != tt.want {
+				t.Errorf("languages = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLanguageName(t *testing.T) {
+	if got := LanguageName("ES"); got != "Spanish" {
+		t.Errorf("LanguageName(ES) = %q", got)
+	}
+	if got := LanguageName("xx"); got != "" {
+		t.Errorf("LanguageName(xx) = %q", got)
+	}
+}
+
+func TestPromptsMentionLanguages(t *testing.T) {
+	prompts, err := LoadPrompts("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kind := range analysisKinds {
+		prompt, _ := prompts.Get(kind)
+		system, _, err := prompt.Render(PromptData{Content: germanText, Language: "German", ResponseLanguage: "English"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(system, "The content is written in German. Write every reason in English.") {
+			t.Errorf("%s system prompt does not mention the languages:\n%s", kind, system)
+		}
+		system, _, _ = prompt.Render(PromptData{Content: "some text"})
+		if !strings.Contains(system, "Write every reason in the language of the content.") {
+			t.Errorf("%s system prompt without languages:\n%s", kind, system)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package factcheck
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type contextKey int
+
+const requestIDKey contextKey = iota
+
+// Returns a context carrying the request ID, which is added to every log record made with it
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// The request ID of the context, "" if there is none
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// A random 16-byte hex ID
+func NewRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Adds the request ID and trace ID of the context to each record
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("requestId", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("traceId", sc.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// Builds a logger writing "text" or "json" records at the given level ("debug", "info",
+// "warn" or "error")
+func NewLogger(w io.Writer, level string, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unknown log level '%s'", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "", "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format '%s', use 'text' or 'json'", format)
+	}
+	return slog.New(requestIDHandler{handler}), nil
+}
+
+// How prompts and model output appear in logs: "redact" (default, only the length),
+// "truncate" (the first LogContentLimit characters) or "full"
+var LogContent = "redact"
+
+var LogContentLimit = 200
+
+// A log attribute for user content or model output, redacted or truncated per LogContent
+func contentAttr(key string, content string) slog.Attr {
+	switch LogContent {
+	case "full":
+		return slog.String(key, content)
+	case "truncate":
+		runes := []rune(content)
+		if len(runes) <= LogContentLimit {
+			return slog.String(key, content)
+		}
+		return slog.String(key, fmt.Sprintf("%s... (%d more characters)", string(runes[:LogContentLimit]), len(runes)-LogContentLimit))
+	default:
+		return slog.String(key, fmt.Sprintf("[%d characters redacted]", len([]rune(content))))
+	}
+}
@@ -0,0 +1,55 @@
+package factcheck
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestLoggerAddsRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, "info", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger.DebugContext(context.Background(), "hidden")
+	logger.InfoContext(WithRequestID(context.Background(), "abc123"), "shown", "key", "value")
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("expected exactly one JSON record, got %q: %v", buf.String(), err)
+	}
+	if record["msg"] != "shown" || record["requestId"] != "abc123" || record["key"] != "value" {
+		t.Errorf("unexpected record %v", record)
+	}
+
+	if _, err := NewLogger(&buf, "loud", "text"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+	if _, err := NewLogger(&buf, "info", "xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestContentAttr(t *testing.T) {
+	defer func() { LogContent, LogContentLimit = "redact", 200 }()
+	content := strings.Repeat("é", 30)
+
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{"redact", "[30 characters redacted]"},
+		{"truncate", strings.Repeat("é", 10) + "... (20 more characters)"},
+		{"full", content},
+	}
+	for _, tt := range tests {
+		LogContent, LogContentLimit = tt.mode, 10
+		if got := contentAttr("prompt", content); got.Value.String() != tt.want || got.Key != "prompt" {
+			t.Errorf("%s: got %v", tt.mode, got)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package factcheck
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry behind /metrics, with Go runtime and process metrics
+var metricsRegistry = prometheus.NewRegistry()
+
+// Buckets for 0-100 scores, matching the /experiments histograms
+var scoreBuckets = prometheus.LinearBuckets(10, 10, 10)
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "falsefact_http_requests_total",
+		Help: "HTTP requests by endpoint, method and status.",
+	}, []string{"endpoint", "method", "status"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "falsefact_http_request_duration_seconds",
+		Help:    "HTTP request latency by endpoint, method and status.",
+		Buckets: []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 20, 30, 60},
+	}, []string{"endpoint", "method", "status"})
+	httpInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "falsefact_http_requests_in_flight",
+		Help: "HTTP requests currently being served.",
+	})
+
+	providerDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "falsefact_provider_request_duration_seconds",
+		Help:    "Upstream provider call latency, including failed calls.",
+		Buckets: []float64{.25, .5, 1, 2, 4, 8, 15, 30, 60},
+	}, []string{"provider"})
+	providerErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "falsefact_provider_errors_total",
+		Help: "Failed upstream provider calls by AnalysisErrorType.",
+	}, []string{"provider", "type"})
+	parseFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "falsefact_parse_failures_total",
+		Help: "Model answers that could not be parsed, after any JSON fix re-prompt.",
+	}, []string{"provider", "kind"})
+
+	tokens = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "falsefact_tokens_total",
+		Help: "Tokens used by provider, model and type (input or output).",
+	}, []string{"provider", "model", "type"})
+	cost = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "falsefact_cost_usd_total",
+		Help: "Provider cost in USD by provider and model, from the price table.",
+	}, []string{"provider", "model"})
+	budgetRejections = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "falsefact_budget_rejections_total",
+		Help: "Requests rejected because the API key's daily budget was used up.",
+	})
+
+	configReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "falsefact_config_reloads_total",
+		Help: "Configuration reloads by result (applied or rejected).",
+	}, []string{"result"})
+
+	sourceCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "falsefact_source_check_cache_lookups_total",
+		Help: "Source check cache lookups by result (hit or miss).",
+	}, []string{"result"})
+
+	credibilityScores = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "falsefact_credibility_score",
+		Help:    "Returned credibilityScore by prompt kind and variant.",
+		Buckets: scoreBuckets,
+	}, []string{"kind", "variant"})
+	confidenceScores = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "falsefact_confidence",
+		Help:    "Returned confidence by prompt kind and variant.",
+		Buckets: scoreBuckets,
+	}, []string{"kind", "variant"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration, httpInFlight,
+		providerDuration, providerErrors, parseFailures,
+		tokens, cost, budgetRejections,
+		configReloads,
+		sourceCacheLookups,
+		credibilityScores, confidenceScores,
+	)
+}
+
+// Serves the metrics in Prometheus exposition format
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// Counts a request as in flight until the returned function is called
+func TrackInFlight() func() {
+	httpInFlight.Inc()
+	return httpInFlight.Dec
+}
+
+// Records a finished HTTP request. The endpoint should be the route pattern, not the raw path.
+func ObserveHTTPRequest(endpoint string, method string, status int, duration time.Duration) {
+	labels := prometheus.Labels{"endpoint": endpoint, "method": method, "status": strconv.Itoa(status)}
+	httpRequests.With(labels).Inc()
+	httpDuration.With(labels).Observe(duration.Seconds())
+}
+
+// Counts a configuration reload, applied or rejected
+func ObserveConfigReload(applied bool) {
+	result := "applied"
+	if !applied {
+		result = "rejected"
+	}
+	configReloads.WithLabelValues(result).Inc()
+}
+
+func observeProviderCall(provider string, duration time.Duration, err error) {
+	providerDuration.WithLabelValues(provider).Observe(duration.Seconds())
+	if err != nil {
+		providerErrors.WithLabelValues(provider, string(errorType(err))).Inc()
+	}
+}
+
+func observeUsage(provider string, model string, usage Usage, costUSD float64) {
+	tokens.WithLabelValues(provider, model, "input").Add(float64(usage.PromptTokens))
+	tokens.WithLabelValues(provider, model, "output").Add(float64(usage.CompletionTokens))
+	cost.WithLabelValues(provider, model).Add(costUSD)
+}
+
+// The AnalysisErrorType of an error; errors without one are network or transport failures
+func errorType(err error) AnalysisErrorType {
+	var extErr *ExtensionError
+	if errors.As(err, &extErr) {
+		return extErr.Type
+	}
+	return NetworkError
+}
+
+// Records a sample in the /experiments stats and the score histograms
+func recordSample(variant Variant, sample VariantSample) {
+	Stats.Record(variant, sample)
+	if sample.Outcome != OutcomeSuccess {
+		return
+	}
+	if sample.Credibility != nil {
+		credibilityScores.WithLabelValues(string(sample.Kind), variant.ID()).Observe(float64(*sample.Credibility))
+	}
+	confidenceScores.WithLabelValues(string(sample.Kind), variant.ID()).Observe(float64(sample.Confidence))
+}
@@ -0,0 +1,30 @@
+package factcheck
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestProviderMetrics(t *testing.T) {
+	rateLimited := providerErrors.WithLabelValues("stub", string(RateLimited))
+	parseFailed := parseFailures.WithLabelValues("stub", string(PromptTextShort))
+	errorsBefore, parseBefore := testutil.ToFloat64(rateLimited), testutil.ToFloat64(parseFailed)
+
+	variant := ensembleVariant(t,
+		stubProvider{err: &ExtensionError{Type: RateLimited, Message: "API rate limit exceeded"}},
+		stubProvider{response: "not JSON"},
+		stubProvider{response: `{"verdict": "fact", "reason": "True.", "confidence": 90}`},
+	)
+	if _, err := AiAnalyzeTextShort(context.Background(), "some text", Languages{}, variant); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := testutil.ToFloat64(rateLimited) - errorsBefore; got != 1 {
+		t.Errorf("rate limit errors increased by %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(parseFailed) - parseBefore; got != 1 {
+		t.Errorf("parse failures increased by %v, want 1", got)
+	}
+}
@@ -0,0 +1,184 @@
+package factcheck
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Default prompt templates, compiled into the binary
+//
+//go:embed prompts
+var embeddedPrompts embed.FS
+
+type PromptKind string
+
+const (
+	PromptArticle    PromptKind = "article"
+	PromptTextLong   PromptKind = "text_long"
+	PromptTextShort  PromptKind = "text_short"
+	PromptImageLong  PromptKind = "image_long"
+	PromptImageShort PromptKind = "image_short"
+	// Asks the provider to repair output that could not be parsed
+	PromptJSONFix PromptKind = "json_fix"
+)
+
+var promptKinds = []PromptKind{PromptArticle, PromptTextLong, PromptTextShort, PromptImageLong, PromptImageShort, PromptJSONFix}
+
+// Prompt kinds that back an analysis endpoint
+var analysisKinds = []PromptKind{PromptArticle, PromptTextLong, PromptTextShort, PromptImageLong, PromptImageShort}
+
+// Values available to prompt templates
+type PromptData struct {
+	Content    string
+	Title      string
+	URL        string
+	LastEdited time.Time
+	// English name of the content's language, e.g. "Spanish"; empty when unknown
+	Language string
+	// English name of the language to write the reasons in; empty to use the content's
+	ResponseLanguage string
+}
+
+// A parsed prompt template. Each template file defines "version", "system" and "user".
+type Prompt struct {
+	Kind    PromptKind
+	Version string
+	tmpl    *template.Template
+}
+
+// The full set of prompts used by the analysis functions
+type PromptSet struct {
+	prompts map[PromptKind]*Prompt
+}
+
+// Loads the prompt templates. Files in dir (if set) override the embedded defaults
+// with the same name, e.g. dir/article.tmpl or dir/partials/scoring.tmpl.
+func LoadPrompts(dir string) (*PromptSet, error) {
+	partialNames, err := promptPartialNames(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &PromptSet{prompts: map[PromptKind]*Prompt{}}
+	for _, kind := range promptKinds {
+		tmpl := template.New(string(kind)).Option("missingkey=error")
+		for _, name := range partialNames {
+			if err := parsePromptFile(tmpl, dir, path.Join("partials", name)); err != nil {
+				return nil, err
+			}
+		}
+		if err := parsePromptFile(tmpl, dir, string(kind)+".tmpl"); err != nil {
+			return nil, err
+		}
+
+		for _, name := range []string{"version", "system", "user"} {
+			if tmpl.Lookup(name) == nil {
+				return nil, fmt.Errorf("prompt %s does not define %q", kind, name)
+			}
+		}
+		var version bytes.Buffer
+		if err := tmpl.ExecuteTemplate(&version, "version", nil); err != nil {
+			return nil, fmt.Errorf("prompt %s: %w", kind, err)
+		}
+		if strings.TrimSpace(version.String()) == "" {
+			return nil, fmt.Errorf("prompt %s has an empty version", kind)
+		}
+
+		set.prompts[kind] = &Prompt{
+			Kind:    kind,
+			Version: strings.TrimSpace(version.String()),
+			tmpl:    tmpl,
+		}
+	}
+	return set, nil
+}
+
+// Returns the prompt of the given kind
+func (s *PromptSet) Get(kind PromptKind) (*Prompt, error) {
+	p, ok := s.prompts[kind]
+	if !ok {
+		return nil, fmt.Errorf("no prompt loaded for %s", kind)
+	}
+	return p, nil
+}
+
+// Returns the version string of every loaded prompt
+func (s *PromptSet) Versions() map[PromptKind]string {
+	versions := map[PromptKind]string{}
+	for kind, p := range s.prompts {
+		versions[kind] = p.Version
+	}
+	return versions
+}
+
+// Renders the system and user prompts
+func (p *Prompt) Render(data PromptData) (systemPrompt string, userPrompt string, err error) {
+	var system, user bytes.Buffer
+	if err := p.tmpl.ExecuteTemplate(&system, "system", data); err != nil {
+		return "", "", fmt.Errorf("rendering %s system prompt: %w", p.Kind, err)
+	}
+	if err := p.tmpl.ExecuteTemplate(&user, "user", data); err != nil {
+		return "", "", fmt.Errorf("rendering %s user prompt: %w", p.Kind, err)
+	}
+	return strings.TrimSpace(system.String()), strings.TrimSpace(user.String()), nil
+}
+
+// Lists partial templates from the embedded defaults and the override directory
+func promptPartialNames(dir string) ([]string, error) {
+	seen := map[string]bool{}
+	names := []string{}
+	add := func(entries []fs.DirEntry) {
+		for _, e := range entries {
+			if !e.IsDir() && strings.HasSuffix(e.Name(), ".tmpl") && !seen[e.Name()] {
+				seen[e.Name()] = true
+				names = append(names, e.Name())
+			}
+		}
+	}
+
+	entries, err := embeddedPrompts.ReadDir("prompts/partials")
+	if err != nil {
+		return nil, err
+	}
+	add(entries)
+
+	if dir != "" {
+		entries, err := os.ReadDir(filepath.Join(dir, "partials"))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		add(entries)
+	}
+	return names, nil
+}
+
+// Parses a template file into tmpl, preferring the override directory over the embedded copy
+func parsePromptFile(tmpl *template.Template, dir string, name string) error {
+	var data []byte
+	var err error
+	if dir != "" {
+		data, err = os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	if data == nil {
+		data, err = embeddedPrompts.ReadFile(path.Join("prompts", name))
+		if err != nil {
+			return fmt.Errorf("prompt template %s: %w", name, err)
+		}
+	}
+	if _, err := tmpl.Parse(string(data)); err != nil {
+		return fmt.Errorf("parsing prompt template %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package factcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// An upstream model that analysis prompts are sent to
+type Provider interface {
+	// Lowercase name used in configuration, e.g. "gemini"
+	Name() string
+	// Sends the system and user prompts and returns the raw model output. The context
+	// carries the request ID and cancels the call when the client goes away.
+	Call(ctx context.Context, systemPrompt string, userPrompt string) (Completion, error)
+}
+
+// Raw model output with the model that produced it and the tokens it took
+type Completion struct {
+	Text string
+	// Model version reported by the provider, or the requested model
+	Model string
+	Usage Usage
+}
+
+// Token counts reported by the provider, zero when it reports none
+type Usage struct {
+	PromptTokens     int `json:"promptTokens"`
+	CompletionTokens int `json:"completionTokens"`
+	TotalTokens      int `json:"totalTokens"`
+}
+
+// Implemented by providers that can tell whether they are configured without calling upstream
+type ConfigChecker interface {
+	CheckConfig() error
+}
+
+// Implemented by providers with a cheap upstream check that uses no tokens
+type Prober interface {
+	Probe(ctx context.Context) error
+}
+
+// Implemented by providers that can look at an image along with the prompts
+type VisionProvider interface {
+	CallWithImage(ctx context.Context, systemPrompt string, userPrompt string, image Image) (Completion, error)
+}
+
+type geminiProvider struct {
+	apiKey string
+}
+
+// A Gemini provider using the given API key. Calls in flight keep the key of the
+// provider they started with when it is replaced.
+func NewGeminiProvider(apiKey string) Provider {
+	return geminiProvider{apiKey: apiKey}
+}
+
+func (geminiProvider) Name() string { return "gemini" }
+
+// Gemini gets a single prompt, so the system prompt is prepended
+func (p geminiProvider) Call(ctx context.Context, systemPrompt string, userPrompt string) (Completion, error) {
+	return geminiApiCall(ctx, p.apiKey, systemPrompt+"\n\n\n"+userPrompt, nil)
+}
+
+func (p geminiProvider) CallWithImage(ctx context.Context, systemPrompt string, userPrompt string, image Image) (Completion, error) {
+	return geminiApiCall(ctx, p.apiKey, systemPrompt+"\n\n\n"+userPrompt, &image)
+}
+
+func (p geminiProvider) CheckConfig() error {
+	if p.apiKey == "" {
+		return errors.New("GEMINI_API_KEY is not set")
+	}
+	return nil
+}
+
+func (p geminiProvider) Probe(ctx context.Context) error { return geminiProbe(ctx, p.apiKey) }
+
+type pollinationsProvider struct{}
+
+func (pollinationsProvider) Name() string { return "pollinations" }
+
+func (pollinationsProvider) Call(ctx context.Context, systemPrompt string, userPrompt string) (Completion, error) {
+	return pollinationsApiCall(ctx, systemPrompt, userPrompt)
+}
+
+func (pollinationsProvider) Probe(ctx context.Context) error { return pollinationsProbe(ctx) }
+
+// Gemini has no API key until one is registered with NewGeminiProvider
+var (
+	Gemini       Provider = geminiProvider{}
+	Pollinations Provider = pollinationsProvider{}
+)
+
+// Providers by lowercase name. Each runtime configuration builds its own with
+// NewRegistry, so registering a provider for a configuration that is then rejected
+// changes nothing in use.
+type Registry struct {
+	mu        sync.RWMutex
+	providers map[string]Provider
+}
+
+// A registry with the built-in providers and then the given ones, which replace
+// built-ins of the same name
+func NewRegistry(providers ...Provider) *Registry {
+	r := &Registry{providers: map[string]Provider{}}
+	for _, p := range append([]Provider{Gemini, Pollinations}, providers...) {
+		r.Register(p)
+	}
+	return r
+}
+
+// Makes a provider available by name to MODEL and experiment definitions
+func (r *Registry) Register(p Provider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.providers[strings.ToLower(p.Name())] = p
+}
+
+// Looks up a registered provider by its (case-insensitive) name
+func (r *Registry) ByName(name string) (Provider, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.providers[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider '%s' (available: %s)", name, strings.Join(r.namesLocked(), ", "))
+	}
+	return p, nil
+}
+
+// Names of all registered providers, sorted
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.namesLocked()
+}
+
+func (r *Registry) namesLocked() []string {
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// The registry of RegisterProvider and ProviderByName, for tools like cmd/eval that
+// set up their providers once
+var defaultRegistry = NewRegistry()
+
+// Registers a provider in the default registry
+func RegisterProvider(p Provider) { defaultRegistry.Register(p) }
+
+// Looks up a provider in the default registry
+func ProviderByName(name string) (Provider, error) { return defaultRegistry.ByName(name) }
+
+// Names of the providers in the default registry, sorted
+func ProviderNames() []string { return defaultRegistry.Names() }
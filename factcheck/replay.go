@@ -0,0 +1,189 @@
+package factcheck
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// A recorded provider exchange, stored as <prompt hash>.json
+type Fixture struct {
+	Provider     string `json:"provider"`
+	SystemPrompt string `json:"systemPrompt"`
+	UserPrompt   string `json:"userPrompt"`
+	// Set when an image was sent with the prompts
+	ImageSHA256 string `json:"imageSha256,omitempty"`
+	Response    string `json:"response"`
+	Model       string `json:"model,omitempty"`
+	Usage       *Usage `json:"usage,omitempty"`
+	// Set when the provider call failed
+	Error *FixtureError `json:"error,omitempty"`
+}
+
+type FixtureError struct {
+	Type        AnalysisErrorType `json:"type"`
+	Message     string            `json:"message"`
+	Retryable   bool              `json:"retryable"`
+	UserMessage string            `json:"userMessage"`
+}
+
+// Key that fixtures are stored and looked up under
+func PromptHash(systemPrompt string, userPrompt string) string {
+	sum := sha256.Sum256([]byte(systemPrompt + "\x00" + userPrompt))
+	return hex.EncodeToString(sum[:])
+}
+
+// Key of an exchange, which includes the image for image analyses
+func fixtureHash(systemPrompt string, userPrompt string, imageSHA256 string) string {
+	if imageSHA256 == "" {
+		return PromptHash(systemPrompt, userPrompt)
+	}
+	return PromptHash(systemPrompt, userPrompt+"\x00"+imageSHA256)
+}
+
+// Writes a fixture for the given prompts into dir
+func WriteFixture(dir string, f Fixture) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(dir, fixtureHash(f.SystemPrompt, f.UserPrompt, f.ImageSHA256)+".json")
+	return os.WriteFile(name, data, 0o644)
+}
+
+// Wraps a provider and saves every exchange to Dir
+type RecordingProvider struct {
+	Inner Provider
+	Dir   string
+}
+
+func (p *RecordingProvider) Name() string { return p.Inner.Name() }
+
+func (p *RecordingProvider) CheckConfig() error {
+	if checker, ok := p.Inner.(ConfigChecker); ok {
+		return checker.CheckConfig()
+	}
+	return nil
+}
+
+func (p *RecordingProvider) Probe(ctx context.Context) error {
+	if prober, ok := p.Inner.(Prober); ok {
+		return prober.Probe(ctx)
+	}
+	return nil
+}
+
+func (p *RecordingProvider) Call(ctx context.Context, systemPrompt string, userPrompt string) (Completion, error) {
+	completion, err := p.Inner.Call(ctx, systemPrompt, userPrompt)
+	p.record(ctx, Fixture{SystemPrompt: systemPrompt, UserPrompt: userPrompt}, completion, err)
+	return completion, err
+}
+
+// Fails with a capability error when Inner can't look at images
+func (p *RecordingProvider) CallWithImage(ctx context.Context, systemPrompt string, userPrompt string, image Image) (Completion, error) {
+	vision, err := visionOf(p.Inner)
+	if err != nil {
+		return Completion{}, err
+	}
+	completion, err := vision.CallWithImage(ctx, systemPrompt, userPrompt, image)
+	p.record(ctx, Fixture{SystemPrompt: systemPrompt, UserPrompt: userPrompt, ImageSHA256: image.SHA256()}, completion, err)
+	return completion, err
+}
+
+// Saves the exchange of the prompts in fixture
+func (p *RecordingProvider) record(ctx context.Context, fixture Fixture, completion Completion, err error) {
+	fixture.Provider = p.Inner.Name()
+	fixture.Response = completion.Text
+	fixture.Model = completion.Model
+	if completion.Usage != (Usage{}) {
+		fixture.Usage = &completion.Usage
+	}
+	if err != nil {
+		fixture.Error = &FixtureError{Type: NetworkError, Message: err.Error(), Retryable: true}
+		var extErr *ExtensionError
+		if errors.As(err, &extErr) {
+			fixture.Error = &FixtureError{
+				Type:        extErr.Type,
+				Message:     extErr.Message,
+				Retryable:   extErr.Retryable,
+				UserMessage: extErr.UserMessage,
+			}
+		}
+	}
+	if writeErr := WriteFixture(p.Dir, fixture); writeErr != nil {
+		slog.ErrorContext(ctx, "failed to write fixture", "error", writeErr)
+	} else {
+		slog.DebugContext(ctx, "recorded exchange", "provider", p.Inner.Name(), "hash", fixtureHash(fixture.SystemPrompt, fixture.UserPrompt, fixture.ImageSHA256))
+	}
+}
+
+// Answers from fixtures recorded by RecordingProvider, without any network
+type ReplayProvider struct {
+	Dir string
+}
+
+func (p *ReplayProvider) Name() string { return "replay" }
+
+func (p *ReplayProvider) CheckConfig() error {
+	info, err := os.Stat(p.Dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", p.Dir)
+	}
+	return nil
+}
+
+func (p *ReplayProvider) Call(ctx context.Context, systemPrompt string, userPrompt string) (Completion, error) {
+	return p.replay(ctx, PromptHash(systemPrompt, userPrompt))
+}
+
+func (p *ReplayProvider) CallWithImage(ctx context.Context, systemPrompt string, userPrompt string, image Image) (Completion, error) {
+	return p.replay(ctx, fixtureHash(systemPrompt, userPrompt, image.SHA256()))
+}
+
+// Answers with the fixture stored under hash
+func (p *ReplayProvider) replay(ctx context.Context, hash string) (Completion, error) {
+	data, err := os.ReadFile(filepath.Join(p.Dir, hash+".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Completion{}, &ExtensionError{
+			Type:        ApiUnavailable,
+			Message:     "No recorded response for prompt " + hash,
+			Retryable:   false,
+			UserMessage: "No recorded response for this content",
+		}
+	}
+	if err != nil {
+		return Completion{}, err
+	}
+
+	var fixture Fixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return Completion{}, fmt.Errorf("fixture %s: %w", hash, err)
+	}
+	slog.DebugContext(ctx, "replaying exchange", "provider", fixture.Provider, "hash", hash)
+	if fixture.Error != nil {
+		return Completion{}, &ExtensionError{
+			Type:        fixture.Error.Type,
+			Message:     fixture.Error.Message,
+			Retryable:   fixture.Error.Retryable,
+			UserMessage: fixture.Error.UserMessage,
+		}
+	}
+	completion := Completion{Text: fixture.Response, Model: fixture.Model}
+	if fixture.Usage != nil {
+		completion.Usage = *fixture.Usage
+	}
+	return completion, nil
+}
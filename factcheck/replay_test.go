@@ -0,0 +1,67 @@
+package factcheck
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+)
+
+type stubProvider struct {
+	response string
+	model    string
+	usage    Usage
+	err      error
+}
+
+func (p stubProvider) Name() string { return "stub" }
+
+func (p stubProvider) Call(ctx context.Context, systemPrompt string, userPrompt string) (Completion, error) {
+	return Completion{Text: p.response, Model: p.model, Usage: p.usage}, p.err
+}
+
+func TestRecordThenReplay(t *testing.T) {
+	dir := t.TempDir()
+	stub := stubProvider{response: `{"confidence": 80}`, model: "stub-1", usage: Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15}}
+	recorder := &RecordingProvider{Inner: stub, Dir: dir}
+	if _, err := recorder.Call(context.Background(), "system", "user"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 || entries[0].Name() != PromptHash("system", "user")+".json" {
+		t.Fatalf("expected one fixture named after the prompt hash, got %v (%v)", entries, err)
+	}
+
+	replay := &ReplayProvider{Dir: dir}
+	got, err := replay.Call(context.Background(), "system", "user")
+	if err != nil || got != (Completion{Text: stub.response, Model: stub.model, Usage: stub.usage}) {
+		t.Errorf("replay = %+v, %v", got, err)
+	}
+}
+
+func TestReplayRecordedError(t *testing.T) {
+	dir := t.TempDir()
+	recorder := &RecordingProvider{Inner: stubProvider{err: &ExtensionError{Type: RateLimited, Message: "API rate limit exceeded", Retryable: true}}, Dir: dir}
+	recorder.Call(context.Background(), "system", "user")
+
+	_, err := (&ReplayProvider{Dir: dir}).Call(context.Background(), "system", "user")
+	var extErr *ExtensionError
+	if !errors.As(err, &extErr) || extErr.Type != RateLimited || !extErr.Retryable {
+		t.Errorf("expected replayed RATE_LIMITED error, got %v", err)
+	}
+}
+
+func TestReplayMissingFixture(t *testing.T) {
+	_, err := (&ReplayProvider{Dir: t.TempDir()}).Call(context.Background(), "system", "other user prompt")
+	var extErr *ExtensionError
+	if !errors.As(err, &extErr) || extErr.Type != ApiUnavailable {
+		t.Errorf("expected API_UNAVAILABLE for a missing fixture, got %v", err)
+	}
+}
+
+func TestPromptHashSeparatesPrompts(t *testing.T) {
+	if PromptHash("ab", "c") == PromptHash("a", "bc") {
+		t.Error("hash should depend on where the system prompt ends")
+	}
+}
@@ -0,0 +1,292 @@
+package factcheck
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Checks cited sources after parsing when set (SOURCE_CHECK=true)
+var SourceCheck *SourceChecker
+
+// Result of checking one source URL
+type SourceStatus struct {
+	// HTTP status of the final response, 0 if the request failed
+	Status    int    `json:"status"`
+	FinalURL  string `json:"finalUrl"`
+	Reachable bool   `json:"reachable"`
+	// allow, deny, tier1-tier3 or unknown
+	Reputation string `json:"reputation"`
+	Reliable   bool   `json:"reliable"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Summary of the source checks for one analysis
+type SourceCheckSummary struct {
+	Checked    int `json:"checked"`
+	Unreliable int `json:"unreliable"`
+	// Points taken off the model's confidence
+	ConfidencePenalty int `json:"confidencePenalty"`
+}
+
+type SourceCheckConfig struct {
+	Timeout     time.Duration
+	Concurrency int
+	CacheTTL    time.Duration
+	// Most results kept in the cache
+	CacheSize int
+	// Domain reputation list, may be nil
+	Reputation *ReputationList
+	// Confidence is lowered by ConfidencePenalty when more than this share of sources is unreliable
+	UnreliableThreshold float64
+	ConfidencePenalty   int
+	// Allows checking loopback and private network addresses (for tests)
+	AllowPrivateNetworks bool
+}
+
+type cachedStatus struct {
+	status  SourceStatus
+	expires time.Time
+}
+
+// Checks that sources are reachable and rates their domains, with a TTL cache
+type SourceChecker struct {
+	cfg    SourceCheckConfig
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]cachedStatus
+}
+
+func NewSourceChecker(cfg SourceCheckConfig) *SourceChecker {
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 4
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.CacheTTL <= 0 {
+		cfg.CacheTTL = time.Hour
+	}
+	if cfg.CacheSize <= 0 {
+		cfg.CacheSize = 10000
+	}
+
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if !cfg.AllowPrivateNetworks {
+		// Sources come from model output, never let them reach internal services
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+				return fmt.Errorf("refusing to connect to %s", host)
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+
+	return &SourceChecker{
+		cfg: cfg,
+		client: &http.Client{
+			Timeout:   cfg.Timeout,
+			Transport: transport,
+		},
+		cache: map[string]cachedStatus{},
+	}
+}
+
+// Checks every source concurrently and lowers the confidence if too many are unreliable
+func (c *SourceChecker) Annotate(ctx context.Context, sources []Source, confidence *int) *SourceCheckSummary {
+	summary := &SourceCheckSummary{Checked: len(sources)}
+	if len(sources) == 0 {
+		return summary
+	}
+
+	sem := make(chan struct{}, c.cfg.Concurrency)
+	var wg sync.WaitGroup
+	for i := range sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			status := c.Check(ctx, sources[i].URL)
+			sources[i].Check = &status
+		}()
+	}
+	wg.Wait()
+
+	for _, s := range sources {
+		if !s.Check.Reliable {
+			summary.Unreliable++
+		}
+	}
+	if float64(summary.Unreliable)/float64(len(sources)) > c.cfg.UnreliableThreshold {
+		summary.ConfidencePenalty = min(c.cfg.ConfidencePenalty, *confidence)
+		*confidence -= summary.ConfidencePenalty
+	}
+	return summary
+}
+
+// Checks one URL, using the cache when possible. The check stops when ctx is done, and
+// a check cut short that way is not cached.
+func (c *SourceChecker) Check(ctx context.Context, rawURL string) SourceStatus {
+	if err := ctx.Err(); err != nil {
+		return SourceStatus{FinalURL: rawURL, Reputation: "unknown", Error: err.Error()}
+	}
+	c.mu.Lock()
+	cached, ok := c.cache[rawURL]
+	c.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		sourceCacheLookups.WithLabelValues("hit").Inc()
+		return cached.status
+	}
+	sourceCacheLookups.WithLabelValues("miss").Inc()
+
+	status := c.fetch(ctx, rawURL)
+	status.Reputation = "unknown"
+	if c.cfg.Reputation != nil {
+		status.Reputation = c.cfg.Reputation.Rate(status.FinalURL)
+	}
+	status.Reliable = status.Reachable && status.Reputation != "deny" && status.Reputation != "tier3"
+	slog.DebugContext(ctx, "checked source", "url", rawURL, "status", status.Status, "finalUrl", status.FinalURL, "reputation", status.Reputation)
+
+	if !status.Reachable && ctx.Err() != nil {
+		return status
+	}
+	c.store(rawURL, status)
+	return status
+}
+
+// Caches the status. A full cache drops its expired entries, then the one closest to expiring.
+func (c *SourceChecker) store(rawURL string, status SourceStatus) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if _, ok := c.cache[rawURL]; !ok && len(c.cache) >= c.cfg.CacheSize {
+		for key, entry := range c.cache {
+			if now.After(entry.expires) {
+				delete(c.cache, key)
+			}
+		}
+		for len(c.cache) >= c.cfg.CacheSize {
+			oldest := ""
+			for key, entry := range c.cache {
+				if oldest == "" || entry.expires.Before(c.cache[oldest].expires) {
+					oldest = key
+				}
+			}
+			delete(c.cache, oldest)
+		}
+	}
+	c.cache[rawURL] = cachedStatus{status: status, expires: now.Add(c.cfg.CacheTTL)}
+}
+
+// Tries HEAD first and falls back to GET for servers that don't support it. Both take
+// at most twice the timeout, and stop early when ctx is done.
+func (c *SourceChecker) fetch(ctx context.Context, rawURL string) SourceStatus {
+	fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 2*c.cfg.Timeout)
+	defer cancel()
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+
+	status := SourceStatus{FinalURL: rawURL}
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequestWithContext(fetchCtx, method, rawURL, nil)
+		if err != nil {
+			status.Error = err.Error()
+			return status
+		}
+		req.Header.Set("User-Agent", "false-fact-server source check")
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			status.Error = err.Error()
+			continue
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+		resp.Body.Close()
+
+		status.Status = resp.StatusCode
+		status.FinalURL = resp.Request.URL.String()
+		status.Reachable = resp.StatusCode >= 200 && resp.StatusCode < 400
+		status.Error = ""
+		if status.Reachable || (resp.StatusCode != http.StatusMethodNotAllowed &&
+			resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusNotImplemented) {
+			break
+		}
+	}
+	return status
+}
+
+// Domain ratings from a reputation file. Each line is "<rating> <domain>", where the
+// rating is allow, deny or tier1 (best) to tier3; "#" starts a comment. A domain
+// also covers its subdomains, and the most specific entry wins.
+type ReputationList struct {
+	ratings map[string]string
+}
+
+func LoadReputationList(path string) (*ReputationList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	list := &ReputationList{ratings: map[string]string{}}
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(strings.SplitN(scanner.Text(), "#", 2)[0])
+		if text == "" {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected '<rating> <domain>'", path, line)
+		}
+		rating, domain := strings.ToLower(fields[0]), strings.ToLower(strings.TrimPrefix(fields[1], "www."))
+		switch rating {
+		case "allow", "deny", "tier1", "tier2", "tier3":
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown rating '%s'", path, line, rating)
+		}
+		list.ratings[domain] = rating
+	}
+	return list, scanner.Err()
+}
+
+// Rates the URL's host by its most specific listed domain, "unknown" if none match
+func (l *ReputationList) Rate(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "unknown"
+	}
+	host := strings.ToLower(u.Hostname())
+	for {
+		if rating, ok := l.ratings[host]; ok {
+			return rating
+		}
+		dot := strings.IndexByte(host, '.')
+		if dot < 0 {
+			return "unknown"
+		}
+		host = host[dot+1:]
+	}
+}
@@ -0,0 +1,219 @@
+package factcheck
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestChecker(t *testing.T, reputation string) *SourceChecker {
+	t.Helper()
+	cfg := SourceCheckConfig{
+		UnreliableThreshold:  0.5,
+		ConfidencePenalty:    20,
+		AllowPrivateNetworks: true,
+	}
+	if reputation != "" {
+		path := filepath.Join(t.TempDir(), "reputation.txt")
+		if err := os.WriteFile(path, []byte(reputation), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		list, err := LoadReputationList(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.Reputation = list
+	}
+	return NewSourceChecker(cfg)
+}
+
+func TestSourceCheckerStatuses(t *testing.T) {
+	var headRequests, getRequests atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			headRequests.Add(1)
+		}
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ok", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/gone", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/no-head", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		getRequests.Add(1)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	checker := newTestChecker(t, "")
+	tests := []struct {
+		path          string
+		wantStatus    int
+		wantFinalPath string
+		wantReachable bool
+	}{
+		{"/ok", 200, "/ok", true},
+		{"/moved", 200, "/ok", true},
+		{"/gone", 404, "/gone", false},
+		{"/no-head", 200, "/no-head", true},
+	}
+	for _, tt := range tests {
+		status := checker.Check(context.Background(), server.URL+tt.path)
+		if status.Status != tt.wantStatus || status.FinalURL != server.URL+tt.wantFinalPath || status.Reachable != tt.wantReachable {
+			t.Errorf("%s: got %+v", tt.path, status)
+		}
+		if status.Reliable != tt.wantReachable || status.Reputation != "unknown" {
+			t.Errorf("%s: reliability %+v", tt.path, status)
+		}
+	}
+	if getRequests.Load() != 1 {
+		t.Errorf("expected a GET fallback for /no-head, got %d", getRequests.Load())
+	}
+
+	// Cached results don't hit the server again
+	before := headRequests.Load()
+	checker.Check(context.Background(), server.URL+"/ok")
+	if headRequests.Load() != before {
+		t.Error("expected a cached result for /ok")
+	}
+}
+
+func TestSourceCheckerCacheSize(t *testing.T) {
+	checker := newTestChecker(t, "")
+	checker.cfg.CacheSize = 3
+	now := time.Now()
+	checker.cache["expired"] = cachedStatus{expires: now.Add(-time.Minute)}
+	checker.cache["soon"] = cachedStatus{expires: now.Add(time.Minute)}
+	checker.cache["later"] = cachedStatus{expires: now.Add(time.Hour)}
+
+	// The expired entry makes room first, then the one closest to expiring
+	checker.store("a", SourceStatus{})
+	if _, ok := checker.cache["expired"]; ok || len(checker.cache) != 3 {
+		t.Errorf("cache after the first insert = %v", checker.cache)
+	}
+	checker.store("b", SourceStatus{})
+	if _, ok := checker.cache["soon"]; ok || len(checker.cache) != 3 {
+		t.Errorf("cache after the second insert = %v", checker.cache)
+	}
+	// Updating an entry makes no room
+	checker.store("b", SourceStatus{Status: 200})
+	if _, ok := checker.cache["later"]; !ok || checker.cache["b"].status.Status != 200 {
+		t.Errorf("cache after an update = %v", checker.cache)
+	}
+}
+
+func TestSourceCheckerStopsWithTheRequest(t *testing.T) {
+	var requests atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	checker := newTestChecker(t, "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	if status := checker.Check(ctx, server.URL+"/slow"); status.Reachable || status.Error == "" {
+		t.Errorf("cancelled check = %+v", status)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancelled check took %v", elapsed)
+	}
+	if len(checker.cache) != 0 {
+		t.Errorf("cancelled check was cached: %v", checker.cache)
+	}
+
+	// A request that is already done checks nothing
+	before := requests.Load()
+	checker.Check(ctx, server.URL+"/other")
+	if requests.Load() != before {
+		t.Error("checked a source for a cancelled request")
+	}
+}
+
+func TestSourceCheckerUnreachableHost(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL + "/page"
+	server.Close()
+
+	status := newTestChecker(t, "").Check(context.Background(), url)
+	if status.Reachable || status.Reliable || status.Error == "" {
+		t.Errorf("expected an unreachable source with an error, got %+v", status)
+	}
+}
+
+func TestSourceCheckerBlocksPrivateNetworks(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	status := NewSourceChecker(SourceCheckConfig{}).Check(context.Background(), server.URL)
+	if status.Reachable || status.Error == "" {
+		t.Errorf("expected loopback to be refused, got %+v", status)
+	}
+}
+
+func TestReputationList(t *testing.T) {
+	checker := newTestChecker(t, "# ratings\nallow reuters.com\ndeny  bad.example\ntier1 nasa.gov\ntier3 blog.nasa.gov\n")
+	list := checker.cfg.Reputation
+	tests := map[string]string{
+		"https://www.reuters.com/world/":    "allow",
+		"https://news.bad.example/story":    "deny",
+		"https://science.nasa.gov/moon":     "tier1",
+		"https://blog.nasa.gov/post":        "tier3",
+		"https://unlisted.example.org/page": "unknown",
+	}
+	for url, want := range tests {
+		if got := list.Rate(url); got != want {
+			t.Errorf("Rate(%s) = %s, want %s", url, got, want)
+		}
+	}
+
+	if _, err := LoadReputationList("does-not-exist.txt"); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestAnnotateLowersConfidence(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	checker := newTestChecker(t, "deny 127.0.0.1\n")
+	sources := []Source{{ID: 1, URL: server.URL + "/a"}, {ID: 2, URL: server.URL + "/gone"}}
+	confidence := 90
+	summary := checker.Annotate(context.Background(), sources, &confidence)
+	if summary.Unreliable != 2 || summary.ConfidencePenalty != 20 || confidence != 70 {
+		t.Errorf("summary %+v, confidence %d", summary, confidence)
+	}
+	if sources[0].Check == nil || sources[0].Check.Reputation != "deny" || sources[1].Check.Status != 404 {
+		t.Errorf("sources not annotated: %+v %+v", sources[0].Check, sources[1].Check)
+	}
+
+	// At or below the threshold the confidence is kept
+	checker = newTestChecker(t, "")
+	confidence = 90
+	summary = checker.Annotate(context.Background(), sources, &confidence)
+	if summary.Unreliable != 1 || summary.ConfidencePenalty != 0 || confidence != 90 {
+		t.Errorf("summary %+v, confidence %d", summary, confidence)
+	}
+}
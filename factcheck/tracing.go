@@ -0,0 +1,131 @@
+package factcheck
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Spans are no-ops until SetupTracing installs a tracer provider
+var tracer = otel.Tracer("false-fact-server/factcheck")
+
+// Installs a global tracer provider exporting to "otlp" (OTLP over HTTP, configured by the
+// standard OTEL_EXPORTER_OTLP_* variables or the given endpoint) or "stdout". Returns a
+// function that flushes and stops the exporter.
+func SetupTracing(ctx context.Context, exporter string, endpoint string) (func(context.Context) error, error) {
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case "otlp":
+		opts := []otlptracehttp.Option{}
+		if endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(endpoint))
+		}
+		spanExporter, err = otlptracehttp.New(ctx, opts...)
+	case "stdout":
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("unknown trace exporter '%s', use 'otlp' or 'stdout'", exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName("false-fact-server")))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(spanExporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// Starts the server span of an HTTP request, continuing a trace passed in the W3C
+// traceparent header
+func StartServerSpan(r *http.Request) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	return tracer.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+		attribute.String("http.request.method", r.Method),
+		attribute.String("url.path", r.URL.Path),
+		attribute.String("falsefact.request_id", RequestID(r.Context())),
+	))
+}
+
+// Names the server span after the matched route and records the response status
+func EndServerSpan(span trace.Span, method string, route string, status int) {
+	span.SetName(method + " " + route)
+	span.SetAttributes(attribute.String("http.route", route), attribute.Int("http.response.status_code", status))
+	if status >= 500 {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
+
+// Starts a span; the name and attributes follow the OpenTelemetry conventions where there are any
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Marks the span as failed if err is set, then ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Span for one provider call; retry counts the calls made before it for the same answer
+func startProviderSpan(ctx context.Context, provider Provider, retry int) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "provider.call "+provider.Name(),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("gen_ai.system", provider.Name()),
+			attribute.Int("falsefact.retry", retry),
+		))
+}
+
+func endProviderSpan(span trace.Span, completion Completion, err error) {
+	if err == nil {
+		span.SetAttributes(
+			attribute.String("gen_ai.response.model", completion.Model),
+			attribute.Int("gen_ai.usage.input_tokens", completion.Usage.PromptTokens),
+			attribute.Int("gen_ai.usage.output_tokens", completion.Usage.CompletionTokens),
+		)
+	} else {
+		span.SetAttributes(attribute.String("error.type", string(errorType(err))))
+	}
+	endSpan(span, err)
+}
+
+// Runs a parser inside a "parse" span
+func tracedParse[T any](ctx context.Context, parse func(string) (*T, error), content string) (*T, error) {
+	_, span := tracer.Start(ctx, "parse", trace.WithAttributes(attribute.Int("falsefact.content_length", len(content))))
+	parsed, err := parse(content)
+	endSpan(span, err)
+	return parsed, err
+}
+
+// Renders the prompt inside a "prompt.render" span
+func renderPrompt(ctx context.Context, prompt *Prompt, data PromptData) (string, string, error) {
+	_, span := tracer.Start(ctx, "prompt.render", trace.WithAttributes(
+		attribute.String("falsefact.prompt.kind", string(prompt.Kind)),
+		attribute.String("falsefact.prompt.version", prompt.Version),
+	))
+	systemPrompt, userPrompt, err := prompt.Render(data)
+	endSpan(span, err)
+	return systemPrompt, userPrompt, err
+}
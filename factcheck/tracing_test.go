@@ -0,0 +1,93 @@
+package factcheck
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// Records the spans of the test in memory
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	previous := tracer
+	tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+	t.Cleanup(func() { tracer = previous })
+	return recorder
+}
+
+func spanAttr(span sdktrace.ReadOnlySpan, key string) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestAnalyzeSpans(t *testing.T) {
+	recorder := recordSpans(t)
+	prompts, err := LoadPrompts("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	JSONFixReprompt = true
+	defer func() { JSONFixReprompt = false }()
+
+	provider := &scriptedProvider{responses: []string{"credibility is high, confidence 80", validAnalysis}}
+	ctx, root := StartSpan(context.Background(), "request")
+	if _, err := AiAnalyzeTextLong(ctx, "some text", Languages{}, Variant{Name: "test", Provider: provider, Prompts: prompts}); err != nil {
+		t.Fatal(err)
+	}
+	root.End()
+
+	spans := recorder.Ended()
+	names := []string{}
+	for _, span := range spans {
+		names = append(names, span.Name())
+		if span.SpanContext().TraceID() != root.SpanContext().TraceID() {
+			t.Errorf("span %s is not part of the request trace", span.Name())
+		}
+	}
+	// In order of ending: the malformed answer fails to parse and is sent back once to be fixed
+	want := []string{"prompt.render", "provider.call scripted", "parse", "prompt.render", "provider.call scripted", "parse", "request"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("spans = %q, want %q", names, want)
+	}
+	if retry := spanAttr(spans[1], "falsefact.retry").AsInt64(); retry != 0 {
+		t.Errorf("first call retry = %d, want 0", retry)
+	}
+	if retry := spanAttr(spans[4], "falsefact.retry").AsInt64(); retry != 1 {
+		t.Errorf("fix call retry = %d, want 1", retry)
+	}
+	if spans[2].Status().Code != codes.Error {
+		t.Errorf("expected the failed parse span to be marked as an error")
+	}
+}
+
+func TestProviderSpanRecordsUsage(t *testing.T) {
+	recorder := recordSpans(t)
+	stub := stubProvider{response: "{}", model: "stub-1", usage: Usage{PromptTokens: 120, CompletionTokens: 30, TotalTokens: 150}}
+	if _, err := callProvider(context.Background(), stub, 0, "system", "user", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	span := recorder.Ended()[0]
+	if model := spanAttr(span, "gen_ai.response.model").AsString(); model != "stub-1" {
+		t.Errorf("model = %q", model)
+	}
+	if in, out := spanAttr(span, "gen_ai.usage.input_tokens").AsInt64(), spanAttr(span, "gen_ai.usage.output_tokens").AsInt64(); in != 120 || out != 30 {
+		t.Errorf("usage = %d in, %d out; want 120 and 30", in, out)
+	}
+
+	failing := stubProvider{err: &ExtensionError{Type: RateLimited, Message: "API rate limit exceeded"}}
+	callProvider(context.Background(), failing, 0, "system", "user", nil)
+	if errType := spanAttr(recorder.Ended()[1], "error.type").AsString(); errType != string(RateLimited) {
+		t.Errorf("error.type = %q, want %s", errType, RateLimited)
+	}
+}
@@ -0,0 +1,151 @@
+package factcheck
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Price of a model in USD per million tokens
+type ModelPrice struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// Prices by model name. A model also matches the longest entry it starts with, so
+// "gemini-2.5-flash" covers "gemini-2.5-flash-001".
+type PriceTable map[string]ModelPrice
+
+// Prices used for costs, DefaultPrices unless PRICES_FILE is set
+var Prices = DefaultPrices()
+
+// Published list prices of the models the providers use. Pollinations is free.
+func DefaultPrices() PriceTable {
+	return PriceTable{
+		"gemini-2.5-flash":      {Input: 0.30, Output: 2.50},
+		"gemini-2.5-flash-lite": {Input: 0.10, Output: 0.40},
+		"gemini-2.5-pro":        {Input: 1.25, Output: 10.00},
+		"openai-fast":           {},
+	}
+}
+
+// Reads a JSON object of model names to {"input", "output"} prices and adds it to the defaults
+func LoadPrices(path string) (PriceTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file PriceTable
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	prices := DefaultPrices()
+	for model, price := range file {
+		if price.Input < 0 || price.Output < 0 {
+			return nil, fmt.Errorf("%s: negative price for %s", path, model)
+		}
+		prices[strings.ToLower(model)] = price
+	}
+	return prices, nil
+}
+
+// The price of the model, false if the table has none
+func (t PriceTable) Lookup(model string) (ModelPrice, bool) {
+	model = strings.ToLower(model)
+	if price, ok := t[model]; ok {
+		return price, true
+	}
+	names := make([]string, 0, len(t))
+	for name := range t {
+		names = append(names, name)
+	}
+	// Longest prefix first
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	for _, name := range names {
+		if strings.HasPrefix(model, name) {
+			return t[name], true
+		}
+	}
+	return ModelPrice{}, false
+}
+
+// Cost of the usage in USD, false if the model has no price
+func (t PriceTable) Cost(model string, usage Usage) (float64, bool) {
+	price, ok := t.Lookup(model)
+	if !ok {
+		return 0, false
+	}
+	return (float64(usage.PromptTokens)*price.Input + float64(usage.CompletionTokens)*price.Output) / 1e6, true
+}
+
+// Adds usage and cost to the analysis responses when set (REPORT_USAGE=true)
+var ReportUsage bool
+
+// Tokens and cost of all provider calls made for one analysis
+type UsageReport struct {
+	Calls            int     `json:"calls"`
+	PromptTokens     int     `json:"promptTokens"`
+	CompletionTokens int     `json:"completionTokens"`
+	TotalTokens      int     `json:"totalTokens"`
+	CostUSD          float64 `json:"costUsd"`
+}
+
+// Adds up the usage of the provider calls made with its context, including ensemble
+// members and JSON fix re-prompts
+type UsageMeter struct {
+	mu     sync.Mutex
+	report UsageReport
+}
+
+type usageMeterKey struct{}
+
+// Returns a context whose provider calls are counted by the returned meter
+func WithUsageMeter(ctx context.Context) (context.Context, *UsageMeter) {
+	meter := &UsageMeter{}
+	return context.WithValue(ctx, usageMeterKey{}, meter), meter
+}
+
+// The meter of the context, or a new one if it has none
+func usageMeterFor(ctx context.Context) (context.Context, *UsageMeter) {
+	if meter, ok := ctx.Value(usageMeterKey{}).(*UsageMeter); ok {
+		return ctx, meter
+	}
+	return WithUsageMeter(ctx)
+}
+
+func (m *UsageMeter) add(usage Usage, cost float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.report.Calls++
+	m.report.PromptTokens += usage.PromptTokens
+	m.report.CompletionTokens += usage.CompletionTokens
+	m.report.TotalTokens += usage.TotalTokens
+	m.report.CostUSD += cost
+}
+
+func (m *UsageMeter) Report() UsageReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.report
+}
+
+// Models already warned about, so the warning is logged once per model
+var unpricedModels sync.Map
+
+// Prices the completion and counts it in the metrics and the meter of the context
+func recordUsage(ctx context.Context, provider string, completion Completion) float64 {
+	cost, priced := Prices.Cost(completion.Model, completion.Usage)
+	if _, warned := unpricedModels.LoadOrStore(completion.Model, true); !priced && !warned && completion.Usage != (Usage{}) {
+		slog.WarnContext(ctx, "no price for model, counting it as free", "provider", provider, "model", completion.Model)
+	}
+	observeUsage(provider, completion.Model, completion.Usage, cost)
+	if meter, ok := ctx.Value(usageMeterKey{}).(*UsageMeter); ok {
+		meter.add(completion.Usage, cost)
+	}
+	return cost
+}
@@ -0,0 +1,74 @@
+package factcheck
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPriceLookup(t *testing.T) {
+	prices := DefaultPrices()
+	for model, want := range map[string]float64{
+		"gemini-2.5-flash":          0.30,
+		"gemini-2.5-flash-001":      0.30,
+		"gemini-2.5-flash-lite-001": 0.10,
+		"Gemini-2.5-Pro":            1.25,
+	} {
+		if price, ok := prices.Lookup(model); !ok || price.Input != want {
+			t.Errorf("Lookup(%s) = %+v, %v; want input price %v", model, price, ok, want)
+		}
+	}
+	if _, ok := prices.Lookup("gpt-5"); ok {
+		t.Error("expected no price for an unknown model")
+	}
+
+	cost, _ := prices.Cost("gemini-2.5-flash", Usage{PromptTokens: 2000, CompletionTokens: 400})
+	if math.Abs(cost-0.0016) > 1e-12 {
+		t.Errorf("cost = %v, want 2000 x $0.30/M + 400 x $2.50/M", cost)
+	}
+}
+
+func TestLoadPrices(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prices.json")
+	os.WriteFile(path, []byte(`{"gemini-2.5-flash": {"input": 0.15, "output": 0.6}, "my-model": {"input": 1, "output": 2}}`), 0o644)
+	prices, err := LoadPrices(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prices["gemini-2.5-flash"].Input != 0.15 || prices["my-model"].Output != 2 || prices["gemini-2.5-pro"].Input != 1.25 {
+		t.Errorf("prices = %+v, want the file merged over the defaults", prices)
+	}
+
+	os.WriteFile(path, []byte(`{"my-model": {"input": -1}}`), 0o644)
+	if _, err := LoadPrices(path); err == nil {
+		t.Error("expected an error for a negative price")
+	}
+}
+
+func TestUsageMeterCountsEnsembleAndReport(t *testing.T) {
+	ReportUsage = true
+	defer func() { ReportUsage = false }()
+	usage := Usage{PromptTokens: 1000, CompletionTokens: 100, TotalTokens: 1100}
+	answer := `{"verdict": "fact", "reason": "True.", "confidence": 90}`
+	variant := ensembleVariant(t,
+		stubProvider{response: answer, model: "gemini-2.5-flash", usage: usage},
+		stubProvider{response: answer, model: "gemini-2.5-flash", usage: usage},
+		stubProvider{response: answer, model: "openai-fast", usage: usage},
+	)
+
+	ctx, meter := WithUsageMeter(context.Background())
+	parsed, err := AiAnalyzeTextShort(ctx, "some text", Languages{}, variant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := UsageReport{Calls: 3, PromptTokens: 3000, CompletionTokens: 300, TotalTokens: 3300, CostUSD: 2 * 0.00055}
+	got := meter.Report()
+	if got.Calls != want.Calls || got.TotalTokens != want.TotalTokens || math.Abs(got.CostUSD-want.CostUSD) > 1e-12 {
+		t.Errorf("meter = %+v, want %+v", got, want)
+	}
+	if parsed.Usage == nil || *parsed.Usage != got {
+		t.Errorf("response usage = %+v, want the meter's %+v", parsed.Usage, got)
+	}
+}
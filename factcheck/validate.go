@@ -0,0 +1,132 @@
+package factcheck
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Content length limits in characters, after trimming surrounding whitespace
+var (
+	MinContentLength = 3
+	// For articles and long texts
+	MaxContentLength = 100000
+	// For short texts
+	MaxShortContentLength = 5000
+)
+
+const (
+	maxTitleLength = 500
+	maxURLLength   = 2048
+	// last_edited may be this far ahead of the server clock
+	maxClockSkew = 5 * time.Minute
+)
+
+// Codes of the problems a FieldError reports
+const (
+	FieldRequired        = "required"
+	FieldTooShort        = "too_short"
+	FieldTooLong         = "too_long"
+	FieldInvalidURL      = "invalid_url"
+	FieldInFuture        = "in_future"
+	FieldInvalidType     = "invalid_type"
+	FieldUnknown         = "unknown_field"
+	FieldInvalidJSON     = "invalid_json"
+	FieldInvalidValue    = "invalid_value"
+	FieldTooLarge        = "too_large"
+	FieldUnsupportedType = "unsupported_type"
+	FieldMalformedBody   = "malformed_body"
+)
+
+// A problem with one field of a request, named by its JSON name; Field is empty
+// when the body as a whole is the problem
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Returned by the Validate methods with every problem found
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := []string{}
+	for _, f := range e.Fields {
+		messages = append(messages, f.Message)
+	}
+	return "invalid request: " + strings.Join(messages, "; ")
+}
+
+// Collects field errors
+type validator struct {
+	fields []FieldError
+}
+
+func (v *validator) add(field string, code string, format string, args ...any) {
+	v.fields = append(v.fields, FieldError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+// Checks that s is within min and max characters; min 0 makes it optional
+func (v *validator) length(field string, s string, min int, max int) {
+	n := utf8.RuneCountInString(strings.TrimSpace(s))
+	switch {
+	case n == 0 && min > 0:
+		v.add(field, FieldRequired, "%s is required", field)
+	case n < min:
+		v.add(field, FieldTooShort, "%s must be at least %d characters", field, min)
+	case n > max:
+		v.add(field, FieldTooLong, "%s must be at most %d characters", field, max)
+	}
+}
+
+// Checks that code, if set, is the ISO 639-1 code of a known language
+func (v *validator) language(field string, code string) {
+	if code != "" && LanguageName(code) == "" {
+		v.add(field, FieldInvalidValue, "%s must be the ISO 639-1 code of a supported language, e.g. en or es", field)
+	}
+}
+
+func (v *validator) err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: v.fields}
+}
+
+// Checks the required fields and their lengths, that url is an absolute http(s) URL,
+// that last_edited is not in the future and the language codes
+func (r AnalyzeArticleRequest) Validate(now time.Time) error {
+	v := &validator{}
+	v.length("content", r.Content, MinContentLength, MaxContentLength)
+	v.length("title", r.Title, 1, maxTitleLength)
+	if r.URL != "" {
+		if len(r.URL) > maxURLLength {
+			v.add("url", FieldTooLong, "url must be at most %d characters", maxURLLength)
+		} else if u, err := url.Parse(r.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.add("url", FieldInvalidURL, "url must be an absolute http or https URL")
+		}
+	}
+	if r.LastEdited.After(now.Add(maxClockSkew)) {
+		v.add("last_edited", FieldInFuture, "last_edited must not be in the future")
+	}
+	v.language("language", r.Language)
+	v.language("responseLanguage", r.ResponseLanguage)
+	return v.err()
+}
+
+// Checks that content is there and not too long for kind, and the language codes
+func (r AnalyzeTextRequest) Validate(kind PromptKind) error {
+	v := &validator{}
+	max := MaxContentLength
+	if kind == PromptTextShort {
+		max = MaxShortContentLength
+	}
+	v.length("content", r.Content, MinContentLength, max)
+	v.language("language", r.Language)
+	v.language("responseLanguage", r.ResponseLanguage)
+	return v.err()
+}
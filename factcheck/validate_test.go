@@ -0,0 +1,72 @@
+package factcheck
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Field and code of every problem in err
+func fieldCodes(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("error %v is not a ValidationError", err)
+	}
+	codes := []string{}
+	for _, f := range validationErr.Fields {
+		codes = append(codes, f.Field+":"+f.Code)
+	}
+	return codes
+}
+
+func TestValidateArticle(t *testing.T) {
+	now := time.Date(2025, 8, 1, 12, 0, 0, 0, time.UTC)
+	valid := AnalyzeArticleRequest{Content: "Apollo 11 landed in 1969.", Title: "Apollo 11", URL: "https://example.com/apollo", LastEdited: now.Add(-time.Hour)}
+
+	tests := []struct {
+		name   string
+		modify func(r *AnalyzeArticleRequest)
+		want   []string
+	}{
+		{"valid", func(r *AnalyzeArticleRequest) {}, nil},
+		{"no url or last edited", func(r *AnalyzeArticleRequest) { r.URL = ""; r.LastEdited = time.Time{} }, nil},
+		{"empty", func(r *AnalyzeArticleRequest) { *r = AnalyzeArticleRequest{} }, []string{"content:required", "title:required"}},
+		{"blank content", func(r *AnalyzeArticleRequest) { r.Content = " \n\t" }, []string{"content:required"}},
+		{"short content", func(r *AnalyzeArticleRequest) { r.Content = "ok" }, []string{"content:too_short"}},
+		{"long content", func(r *AnalyzeArticleRequest) { r.Content = strings.Repeat("a", MaxContentLength+1) }, []string{"content:too_long"}},
+		{"long title", func(r *AnalyzeArticleRequest) { r.Title = strings.Repeat("é", maxTitleLength+1) }, []string{"title:too_long"}},
+		{"relative url", func(r *AnalyzeArticleRequest) { r.URL = "/apollo" }, []string{"url:invalid_url"}},
+		{"ftp url", func(r *AnalyzeArticleRequest) { r.URL = "ftp://example.com/apollo" }, []string{"url:invalid_url"}},
+		{"bad url", func(r *AnalyzeArticleRequest) { r.URL = "http://exa mple.com" }, []string{"url:invalid_url"}},
+		{"future", func(r *AnalyzeArticleRequest) { r.LastEdited = now.Add(time.Hour) }, []string{"last_edited:in_future"}},
+		{"within clock skew", func(r *AnalyzeArticleRequest) { r.LastEdited = now.Add(time.Minute) }, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := valid
+			tt.modify(&req)
+			got := fieldCodes(t, req.Validate(now))
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("problems = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateText(t *testing.T) {
+	long := AnalyzeTextRequest{Content: strings.Repeat("a", MaxShortContentLength+1)}
+	if err := long.Validate(PromptTextLong); err != nil {
+		t.Errorf("long text rejected: %v", err)
+	}
+	if got := fieldCodes(t, long.Validate(PromptTextShort)); len(got) != 1 || got[0] != "content:too_long" {
+		t.Errorf("short text problems = %v", got)
+	}
+	if got := fieldCodes(t, (AnalyzeTextRequest{}).Validate(PromptTextShort)); len(got) != 1 || got[0] != "content:required" {
+		t.Errorf("empty text problems = %v", got)
+	}
+}
@@ -2,34 +2,108 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"reflect"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/joho/godotenv"
+	"false-fact-server/factcheck"
 )
 
 // possible status values
 type StatusType string
 
 const (
-	StatusHealthy StatusType = "healthy"
-	StatusSuccess StatusType = "success"
-	StatusError   StatusType = "error"
-	StatusOnline  StatusType = "online"
+	StatusHealthy  StatusType = "healthy"
+	StatusSuccess  StatusType = "success"
+	StatusError    StatusType = "error"
+	StatusOnline   StatusType = "online"
+	StatusReady    StatusType = "ready"
+	StatusNotReady StatusType = "not_ready"
 )
 
+// Set at build time with -ldflags "-X main.version=1.2.0 -X main.commit=abc123"
+var (
+	version = "dev"
+	commit  = ""
+)
+
+// Build and configuration details reported by /health/ready
+type BuildInfo struct {
+	Version   string                          `json:"version"`
+	Commit    string                          `json:"commit"`
+	GoVersion string                          `json:"goVersion"`
+	Providers []string                        `json:"providers"`
+	Prompts   map[factcheck.PromptKind]string `json:"prompts"`
+}
+
+func buildInfo(experiments *factcheck.Experiments) BuildInfo {
+	info := BuildInfo{Version: version, Commit: commit, GoVersion: runtime.Version(), Providers: []string{}}
+	// Binaries built from a git checkout know their commit
+	if bi, ok := debug.ReadBuildInfo(); ok && info.Commit == "" {
+		for _, setting := range bi.Settings {
+			if setting.Key == "vcs.revision" {
+				info.Commit = setting.Value
+			}
+		}
+	}
+	for _, p := range experiments.Providers() {
+		info.Providers = append(info.Providers, p.Name())
+	}
+	info.Prompts = experiments.Default.Prompts.Versions()
+	return info
+}
+
 // API response structure
 // Standard API response structure
 type APIResponse struct {
 	Success bool        `json:"success"`
 	Data    interface{} `json:"data,omitempty"`
-	Error   interface{} `json:"error,omitempty"`
+	Error   *APIError   `json:"error,omitempty"`
+}
+
+// Data of /health
+type HealthData struct {
+	Message   string    `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Data of /health/live
+type LiveData struct {
+	Status    StatusType `json:"status"`
+	Timestamp time.Time  `json:"timestamp"`
+}
+
+// Data of /health/ready
+type ReadyData struct {
+	Status       StatusType                   `json:"status"`
+	Draining     bool                         `json:"draining"`
+	Dependencies []factcheck.DependencyStatus `json:"dependencies"`
+	Build        BuildInfo                    `json:"build"`
+	Timestamp    time.Time                    `json:"timestamp"`
+}
+
+// Data of /experiments
+type ExperimentsData struct {
+	Variants  []factcheck.VariantSummary `json:"variants"`
+	Timestamp time.Time                  `json:"timestamp"`
 }
 
 // /health (health check) endpoint
@@ -37,57 +111,91 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	response := APIResponse{
 		Success: true,
-		Data: map[string]interface{}{
-			"message":   "Server is running successfully!",
-			"timestamp": time.Now(),
+		Data: HealthData{
+			Message:   "Server is running successfully!",
+			Timestamp: time.Now(),
 		},
 	}
 	json.NewEncoder(w).Encode(response)
 }
 
-// Handles root and unknown endpoints
-func rootHandler(w http.ResponseWriter, r *http.Request) {
+// /health/live endpoint: the process is up and serving
+func liveHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusNotFound)
-	response := APIResponse{
-		Success: false,
-		Error: map[string]interface{}{
-			"message":   fmt.Sprintf("Endpoint '%s' does not exist", r.URL.Path),
-			"timestamp": time.Now(),
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Data: LiveData{
+			Status:    StatusOnline,
+			Timestamp: time.Now(),
 		},
+	})
+}
+
+// /health/ready endpoint: 503 while shutting down, or unless every provider is configured
+// and, with probes enabled, reachable
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	state := current.Load()
+	ready, dependencies := state.health.Check(r.Context())
+	if draining.Load() {
+		ready = false
 	}
-	json.NewEncoder(w).Encode(response)
+	status := StatusReady
+	if !ready {
+		status = StatusNotReady
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: ready,
+		Data: ReadyData{
+			Status:       status,
+			Draining:     draining.Load(),
+			Dependencies: dependencies,
+			Build:        buildInfo(state.experiments),
+			Timestamp:    time.Now(),
+		},
+	})
+}
+
+// Handles root and unknown endpoints
+func rootHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, APIError{
+		Type:        factcheck.NotFound,
+		Message:     fmt.Sprintf("Endpoint '%s' does not exist", r.URL.Path),
+		UserMessage: "This request is not supported",
+	})
 }
 
 // /analyze/article endpoint handler
 func analyzeArticleHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(APIResponse{
-			Success: false,
-			Error:   "Method not allowed",
-		})
+		methodNotAllowed(w, r, http.MethodPost)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 
-	var req AnalyzeArticleRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(APIResponse{
-			Success: false,
-			Error:   "Invalid request body",
-		})
+	var req factcheck.AnalyzeArticleRequest
+	limitTextBody(w, r)
+	if err := decodeRequest(r, &req); err != nil {
+		invalidRequest(w, r, err)
+		return
+	}
+	if err := req.Validate(time.Now()); err != nil {
+		invalidRequest(w, r, err)
 		return
 	}
 
-	result, err := AiAnalyzeArticle(req.Content, req.Title, req.URL, req.LastEdited, selectedModel)
+	state := current.Load()
+	reservation, ok := reserveBudget(w, r, state)
+	if !ok {
+		return
+	}
+	ctx, meter := factcheck.WithUsageMeter(r.Context())
+	variant := state.experiments.Assign(ctx, factcheck.PromptArticle, apiKeyFromRequest(r))
+	result, err := factcheck.AiAnalyzeArticle(ctx, req.Content, req.Title, req.URL, req.LastEdited, req.Languages(), variant)
+	chargeUsage(r, state, meter, reservation)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(APIResponse{
-			Success: false,
-			Error:   map[string]interface{}{"message": "AI analysis failed", "error": err.Error()},
-		})
+		writeAnalysisError(w, r, err)
 		return
 	}
 
@@ -100,32 +208,33 @@ func analyzeArticleHandler(w http.ResponseWriter, r *http.Request) {
 // /analyze/text/long endpoint handler
 func analyzeLongTextHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(APIResponse{
-			Success: false,
-			Error:   "Method not allowed",
-		})
+		methodNotAllowed(w, r, http.MethodPost)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 
-	var req AnalyzeTextRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(APIResponse{
-			Success: false,
-			Error:   "Invalid request body",
-		})
+	var req factcheck.AnalyzeTextRequest
+	limitTextBody(w, r)
+	if err := decodeRequest(r, &req); err != nil {
+		invalidRequest(w, r, err)
+		return
+	}
+	if err := req.Validate(factcheck.PromptTextLong); err != nil {
+		invalidRequest(w, r, err)
 		return
 	}
 
-	result, err := AiAnalyzeTextLong(req.Content, selectedModel)
+	state := current.Load()
+	reservation, ok := reserveBudget(w, r, state)
+	if !ok {
+		return
+	}
+	ctx, meter := factcheck.WithUsageMeter(r.Context())
+	variant := state.experiments.Assign(ctx, factcheck.PromptTextLong, apiKeyFromRequest(r))
+	result, err := factcheck.AiAnalyzeTextLong(ctx, req.Content, req.Languages(), variant)
+	chargeUsage(r, state, meter, reservation)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(APIResponse{
-			Success: false,
-			Error:   map[string]interface{}{"message": "AI analysis failed", "error": err.Error()},
-		})
+		writeAnalysisError(w, r, err)
 		return
 	}
 
@@ -138,32 +247,72 @@ func analyzeLongTextHandler(w http.ResponseWriter, r *http.Request) {
 // /analyze/text/short endpoint handler
 func analyzeShortTextHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(APIResponse{
-			Success: false,
-			Error:   "Method not allowed",
-		})
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	var req factcheck.AnalyzeTextRequest
+	limitTextBody(w, r)
+	if err := decodeRequest(r, &req); err != nil {
+		invalidRequest(w, r, err)
+		return
+	}
+	if err := req.Validate(factcheck.PromptTextShort); err != nil {
+		invalidRequest(w, r, err)
+		return
+	}
+
+	state := current.Load()
+	reservation, ok := reserveBudget(w, r, state)
+	if !ok {
+		return
+	}
+	ctx, meter := factcheck.WithUsageMeter(r.Context())
+	variant := state.experiments.Assign(ctx, factcheck.PromptTextShort, apiKeyFromRequest(r))
+	result, err := factcheck.AiAnalyzeTextShort(ctx, req.Content, req.Languages(), variant)
+	chargeUsage(r, state, meter, reservation)
+	if err != nil {
+		writeAnalysisError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Data:    result,
+	})
+}
+
+// /analyze/image endpoint handler, takes a multipart upload or a JSON body with a base64 image
+func analyzeImageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 
-	var req AnalyzeTextRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(APIResponse{
-			Success: false,
-			Error:   "Invalid request body",
-		})
+	req, image, err := readImageRequest(w, r)
+	if err != nil {
+		invalidRequest(w, r, err)
 		return
 	}
 
-	result, err := AiAnalyzeTextShort(req.Content, selectedModel)
+	state := current.Load()
+	reservation, ok := reserveBudget(w, r, state)
+	if !ok {
+		return
+	}
+	ctx, meter := factcheck.WithUsageMeter(r.Context())
+	variant := state.experiments.Assign(ctx, req.Kind(), apiKeyFromRequest(r))
+	var result any
+	if req.Kind() == factcheck.PromptImageLong {
+		result, err = factcheck.AiAnalyzeImageLong(ctx, image, req.Languages(), variant)
+	} else {
+		result, err = factcheck.AiAnalyzeImageShort(ctx, image, req.Languages(), variant)
+	}
+	chargeUsage(r, state, meter, reservation)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(APIResponse{
-			Success: false,
-			Error:   map[string]interface{}{"message": "AI analysis failed", "error": err.Error()},
-		})
+		writeAnalysisError(w, r, err)
 		return
 	}
 
@@ -173,14 +322,239 @@ func analyzeShortTextHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-var selectedModel Model
+// Reads and checks an image request, from a multipart form with the image in the
+// "image" file or from JSON. The body is limited to what a base64 image of
+// MAX_IMAGE_BYTES takes.
+func readImageRequest(w http.ResponseWriter, r *http.Request) (factcheck.AnalyzeImageRequest, factcheck.Image, error) {
+	var req factcheck.AnalyzeImageRequest
+	r.Body = http.MaxBytesReader(w, r.Body, int64(factcheck.MaxImageBytes)*4/3+64<<10)
+
+	var data []byte
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		if err := r.ParseMultipartForm(int64(factcheck.MaxImageBytes) + 1<<20); err != nil {
+			return req, factcheck.Image{}, &factcheck.ValidationError{Fields: []factcheck.FieldError{unreadableBody(r, err)}}
+		}
+		defer r.MultipartForm.RemoveAll()
+		if current.Load().cfg.StrictRequests {
+			for name := range r.MultipartForm.Value {
+				if name != "mode" && name != "language" && name != "responseLanguage" {
+					return req, factcheck.Image{}, &factcheck.ValidationError{Fields: []factcheck.FieldError{{Field: name, Code: factcheck.FieldUnknown, Message: "unknown field " + name}}}
+				}
+			}
+		}
+		req.Mode = r.FormValue("mode")
+		req.Language = r.FormValue("language")
+		req.ResponseLanguage = r.FormValue("responseLanguage")
+		// A missing file is left to NewImage
+		file, _, err := r.FormFile("image")
+		if err == nil {
+			data, err = io.ReadAll(file)
+			file.Close()
+		}
+		if err != nil && !errors.Is(err, http.ErrMissingFile) {
+			return req, factcheck.Image{}, &factcheck.ValidationError{Fields: []factcheck.FieldError{unreadableBody(r, err)}}
+		}
+	} else {
+		if err := decodeRequest(r, &req); err != nil {
+			return req, factcheck.Image{}, err
+		}
+		// Data URLs are accepted as they come from canvas.toDataURL()
+		encoded := req.Image
+		if strings.HasPrefix(encoded, "data:") {
+			_, encoded, _ = strings.Cut(encoded, ",")
+		}
+		var err error
+		if data, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			return req, factcheck.Image{}, &factcheck.ValidationError{Fields: []factcheck.FieldError{{Field: "image", Code: factcheck.FieldInvalidValue, Message: "image must be base64-encoded"}}}
+		}
+	}
+
+	if err := req.Validate(); err != nil {
+		return req, factcheck.Image{}, err
+	}
+	image, err := factcheck.NewImage(data)
+	return req, image, err
+}
+
+// /experiments endpoint handler, summarizes per-variant stats
+func experimentsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Data: ExperimentsData{
+			Variants:  factcheck.Stats.Summary(),
+			Timestamp: time.Now(),
+		},
+	})
+}
+
+// Decodes the JSON body into v. With STRICT_REQUESTS, unknown fields and data after the
+// JSON value are rejected. Errors are a *factcheck.ValidationError naming the field.
+func decodeRequest(r *http.Request, v any) error {
+	strict := current.Load().cfg.StrictRequests
+	decoder := json.NewDecoder(r.Body)
+	if strict {
+		decoder.DisallowUnknownFields()
+	}
+	err := decoder.Decode(v)
+	if err == nil && strict {
+		if _, extra := decoder.Token(); extra != io.EOF {
+			err = errors.New("trailing data")
+		}
+	}
+	if err == nil {
+		return nil
+	}
+
+	fieldErr := factcheck.FieldError{Code: factcheck.FieldInvalidJSON, Message: "the request body is not valid JSON"}
+	var typeErr *json.UnmarshalTypeError
+	var timeErr *time.ParseError
+	var sizeErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &sizeErr):
+		fieldErr = bodyTooLarge(sizeErr)
+	case errors.Is(err, io.EOF):
+		fieldErr = factcheck.FieldError{Code: factcheck.FieldRequired, Message: "the request body is empty"}
+	case errors.As(err, &typeErr):
+		fieldErr = factcheck.FieldError{Field: typeErr.Field, Code: factcheck.FieldInvalidType, Message: fmt.Sprintf("%s must be a %s", typeErr.Field, jsonTypeName(typeErr.Type))}
+	case errors.As(err, &timeErr):
+		// last_edited is the only time in the requests
+		fieldErr = factcheck.FieldError{Field: "last_edited", Code: factcheck.FieldInvalidValue, Message: "last_edited must be an RFC 3339 time like 2025-07-25T18:05:27Z"}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		name, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		fieldErr = factcheck.FieldError{Field: name, Code: factcheck.FieldUnknown, Message: fmt.Sprintf("unknown field %s", name)}
+	}
+	return &factcheck.ValidationError{Fields: []factcheck.FieldError{fieldErr}}
+}
+
+// Caps the body of an article or text request at what its longest valid content can take.
+// A character takes at most 12 bytes in JSON, as an escaped surrogate pair.
+func limitTextBody(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, int64(max(factcheck.MaxContentLength, factcheck.MaxShortContentLength))*12+64<<10)
+}
+
+// The problem with a body cut by http.MaxBytesReader
+func bodyTooLarge(err *http.MaxBytesError) factcheck.FieldError {
+	return factcheck.FieldError{Code: factcheck.FieldTooLarge, Message: fmt.Sprintf("the request body must be at most %d bytes", err.Limit)}
+}
+
+// The problem with a body that could not be read. The error itself is only logged.
+func unreadableBody(r *http.Request, err error) factcheck.FieldError {
+	var sizeErr *http.MaxBytesError
+	if errors.As(err, &sizeErr) {
+		return bodyTooLarge(sizeErr)
+	}
+	slog.WarnContext(r.Context(), "reading the request body failed", "error", err)
+	return factcheck.FieldError{Code: factcheck.FieldMalformedBody, Message: "the request body could not be read"}
+}
+
+// How a Go type is called in JSON
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int64, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+	return "object"
+}
+
+// Client API key, used for sticky experiment assignment and budgets
+func apiKeyFromRequest(r *http.Request) string {
+	return r.Header.Get("X-API-Key")
+}
+
+// Reserves the estimated cost of an analysis against the API key's daily budget, or rejects
+// the request with 429 if the budget is used up. The reservation goes to chargeUsage.
+func reserveBudget(w http.ResponseWriter, r *http.Request, state *runtimeState) (float64, bool) {
+	if factcheck.Budgets == nil {
+		return 0, true
+	}
+	reservation, err := factcheck.Budgets.Reserve(state.budgets, apiKeyFromRequest(r))
+	var extErr *factcheck.ExtensionError
+	if !errors.As(err, &extErr) {
+		return reservation, true
+	}
+	slog.WarnContext(r.Context(), "daily budget exceeded", "error", err)
+	w.Header().Set("Retry-After", strconv.Itoa(int(factcheck.Budgets.ResetIn().Seconds())))
+	writeAnalysisError(w, r, extErr)
+	return 0, false
+}
+
+// Charges the cost of an analysis to the API key's budget in place of its reservation,
+// and logs the usage
+func chargeUsage(r *http.Request, state *runtimeState, meter *factcheck.UsageMeter, reservation float64) {
+	usage := meter.Report()
+	args := []any{"calls", usage.Calls, "promptTokens", usage.PromptTokens, "completionTokens", usage.CompletionTokens, "costUsd", usage.CostUSD}
+	if factcheck.Budgets != nil {
+		apiKey := apiKeyFromRequest(r)
+		factcheck.Budgets.Settle(state.budgets, apiKey, reservation, usage.CostUSD)
+		args = append(args, "spentTodayUsd", factcheck.Budgets.Spent(state.budgets, apiKey))
+	}
+	slog.InfoContext(r.Context(), "usage", args...)
+}
+
+// Incoming request IDs are only kept if they look like IDs
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// Request ID, access log, metrics and tracing middleware. The ID comes from X-Request-ID or is generated,
+// is returned in the X-Request-ID response header and is added to every log line of the request.
+func instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			id = factcheck.NewRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		r = r.WithContext(factcheck.WithRequestID(r.Context(), id))
+		ctx, span := factcheck.StartServerSpan(r)
+
+		start := time.Now()
+		done := factcheck.TrackInFlight()
+		defer done()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		r = r.WithContext(ctx)
+		next.ServeHTTP(rec, r)
+
+		// The mux sets the matched route, which keeps the endpoint label bounded
+		endpoint := r.Pattern
+		if endpoint == "" {
+			endpoint = "unmatched"
+		}
+		factcheck.ObserveHTTPRequest(endpoint, r.Method, rec.status, time.Since(start))
+		factcheck.EndServerSpan(span, r.Method, endpoint, rec.status)
+		slog.InfoContext(ctx, "request", "method", r.Method, "path", r.URL.Path, "status", rec.status, "duration", time.Since(start))
+	})
+}
+
+// Remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
 
 // CORS middleware
 func withCORS(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-API-Key, X-Request-ID, traceparent, tracestate")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
 			return
@@ -189,55 +563,186 @@ func withCORS(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// Runs the server, or with "analyze" a single analysis from the command line
 func main() {
-	flag.BoolVar(&verbose, "verbose", false, "Enable verbose debug output")
-	flag.Parse()
-	http.HandleFunc("/", withCORS(rootHandler))
-	http.HandleFunc("/health", withCORS(healthHandler))
-	http.HandleFunc("/analyze/article", withCORS(analyzeArticleHandler))
-	http.HandleFunc("/analyze/text/short", withCORS(analyzeShortTextHandler))
-	http.HandleFunc("/analyze/text/long", withCORS(analyzeLongTextHandler))
-
-	err := godotenv.Load()
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case "analyze":
+			os.Exit(runAnalyze(args[1:], os.Stdin, os.Stdout, os.Stderr))
+		case "serve":
+			args = args[1:]
+		}
+	}
+	runServer(args)
+}
+
+// Serves the API until SIGINT or SIGTERM
+func runServer(args []string) {
+	cfg, opts, err := LoadConfig(args, os.LookupEnv, ".env")
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if opts.PrintConfig {
+		fmt.Print(cfg.Redacted())
+		return
+	}
+
+	registerRoutes(http.DefaultServeMux)
+
+	// Structured logging, text or JSON
+	logger, err := factcheck.NewLogger(os.Stdout, cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		fatal("Invalid logging configuration", "error", err)
+	}
+	slog.SetDefault(logger)
+	factcheck.LogContent = cfg.LogContent
+	factcheck.LogContentLimit = cfg.LogContentLimit
+	slog.Info("configuration loaded", "file", opts.ConfigFile, "envFile", opts.EnvFile)
+	slog.Debug("effective configuration\n" + cfg.Redacted())
+
+	// Optional OpenTelemetry tracing; the OTLP exporter also reads the standard OTEL_EXPORTER_OTLP_* variables
+	shutdownTracing := func(context.Context) error { return nil }
+	if cfg.Tracing != "" {
+		shutdownTracing, err = factcheck.SetupTracing(context.Background(), cfg.Tracing, cfg.TracingEndpoint)
+		if err != nil {
+			fatal("Failed to set up tracing", "error", err)
+		}
+		slog.Info("tracing enabled", "exporter", cfg.Tracing)
+	}
+
+	if err := configureAnalysis(cfg); err != nil {
+		fatal("Failed to set up analysis", "error", err)
+	}
+
+	// Per-API-key daily spend; the limits are part of the runtime state so they can be
+	// reloaded
+	factcheck.Budgets = factcheck.NewBudgetTracker()
+
+	// Providers, prompts, experiments, budget limits and readiness checks, replaced on reload
+	state, err := buildRuntime(cfg)
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	installRuntime(state)
+
+	// Start the server; SIGINT and SIGTERM drain it
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	srvCfg := cfg.serverConfig()
+
+	// SIGHUP, and with RELOAD_INTERVAL changes to the files, reload the configuration
+	reloads := &reloader{args: args, lookupEnv: os.LookupEnv, envFile: ".env", files: watchedFiles(cfg, opts.ConfigFile, ".env")}
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go reloads.handleSignals(ctx, hangups)
+	if cfg.ReloadInterval > 0 {
+		go reloads.watch(ctx, cfg.ReloadInterval)
+	}
+
+	srv := newServer(instrument(http.DefaultServeMux), srvCfg)
+	scheme := "http"
+
+	// Optional TLS, reloaded when certbot or similar renews the files
+	if cfg.TLSCertFile != "" {
+		certs, err := newCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			fatal("Failed to load TLS certificate", "error", err)
+		}
+		go certs.watch(ctx, cfg.TLSReloadInterval)
+		enableTLS(srv, certs)
+		scheme = "https"
+
+		if cfg.HTTPRedirectPort != "" {
+			redirect := &http.Server{Addr: ":" + cfg.HTTPRedirectPort, Handler: redirectToHTTPS(cfg.TLSRedirectHost, cfg.Port), ReadHeaderTimeout: cfg.ReadHeaderTimeout}
+			context.AfterFunc(ctx, func() { redirect.Close() })
+			go func() {
+				if err := redirect.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+					fatal("HTTP redirect listener stopped", "addr", redirect.Addr, "error", err)
+				}
+			}()
+			slog.Info("redirecting HTTP to HTTPS", "addr", "http://localhost:"+cfg.HTTPRedirectPort)
+		}
+	}
+
+	ln, err := net.Listen("tcp", ":"+cfg.Port)
+	if err != nil {
+		fatal("Failed to listen", "addr", ":"+cfg.Port, "error", err)
+	}
+	slog.Info("server starting", "addr", scheme+"://localhost:"+cfg.Port, "level", cfg.LogLevel,
+		"endpoints", endpointNames())
+	err = serve(ctx, srv, ln, srvCfg)
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if tracingErr := shutdownTracing(flushCtx); tracingErr != nil {
+		slog.Warn("failed to flush traces", "error", tracingErr)
+	}
 	if err != nil {
-		log.Fatal("No .env file found or failed to load .env")
-	}
-
-	// Model selection via env file
-	modelEnv := strings.ToLower(os.Getenv("MODEL"))
-	switch modelEnv {
-	case "pollinations":
-		selectedModel = Pollinations
-		fmt.Println("[main] Using model: Pollinations")
-	case "gemini":
-		selectedModel = Gemini
-		fmt.Println("[main] Using model: Gemini")
-		if os.Getenv("GEMINI_API_KEY") == "" {
-			log.Fatal("GEMINI_API_KEY is not set in the environment. Please set it to use the Gemini model.")
-		}
-	case "":
-		log.Fatal("No MODEL set in env file, please set MODEL to 'Pollinations' or 'Gemini'")
-	default:
-		log.Fatal(fmt.Sprintf("[main] Unknown MODEL '%s'", modelEnv))
-	}
-
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("No PORT variable set in env file\n")
-	}
-	port = ":" + port
-
-	fmt.Printf("🚀 Server starting on port %s\n", port)
-	fmt.Printf("📡 API endpoints:\n")
-	fmt.Printf("   - POST /analyze/article\n")
-	fmt.Printf("   - POST /analyze/text/short\n")
-	fmt.Printf("   - POST /analyze/text/long\n")
-	fmt.Printf("   - GET  /health\n")
-	fmt.Printf("\n💡 Access your server at: http://localhost%s\n", port)
-	if verbose {
-		fmt.Printf("[main] Verbose mode enabled\n")
-	}
-
-	// Start the server
-	log.Fatal(http.ListenAndServe(port, nil))
+		fatal("Server stopped", "error", err)
+	}
+}
+
+// Applies the analysis settings that are read once at startup, for the server and the CLI
+func configureAnalysis(cfg *Config) error {
+	var err error
+	// Ask the provider to fix output that can't be repaired locally
+	factcheck.JSONFixReprompt = cfg.JSONFixReprompt
+
+	// Length limits of analysis requests
+	factcheck.MinContentLength = cfg.MinContentLength
+	factcheck.MaxContentLength = cfg.MaxContentLength
+	factcheck.MaxShortContentLength = cfg.MaxShortContentLength
+	factcheck.MaxImageBytes = cfg.MaxImageBytes
+
+	// Sources that no reason cites are dropped unless UNREFERENCED_SOURCES=flag
+	factcheck.UnreferencedSources = cfg.UnreferencedSources
+
+	// Optional liveness and reputation checks of cited sources
+	if cfg.SourceCheck {
+		checkCfg := factcheck.SourceCheckConfig{
+			Timeout:             cfg.SourceCheckTimeout,
+			Concurrency:         cfg.SourceCheckConcurrency,
+			CacheTTL:            cfg.SourceCheckCacheTTL,
+			CacheSize:           cfg.SourceCheckCacheSize,
+			UnreliableThreshold: cfg.UnreliableSourceThreshold,
+			ConfidencePenalty:   cfg.UnreliableSourcePenalty,
+		}
+		if cfg.DomainReputationFile != "" {
+			checkCfg.Reputation, err = factcheck.LoadReputationList(cfg.DomainReputationFile)
+			if err != nil {
+				return fmt.Errorf("loading domain reputation list: %w", err)
+			}
+		}
+		factcheck.SourceCheck = factcheck.NewSourceChecker(checkCfg)
+		slog.Info("checking cited sources")
+	}
+
+	// Optional confidence calibration, fitted with cmd/eval -fit-calibration
+	if cfg.CalibrationFile != "" {
+		factcheck.Calibrations, err = factcheck.LoadCalibrations(cfg.CalibrationFile)
+		if err != nil {
+			return fmt.Errorf("loading calibrations: %w", err)
+		}
+		slog.Info("calibrating confidence", "file", cfg.CalibrationFile)
+	}
+
+	// Token prices and per-response usage
+	if cfg.PricesFile != "" {
+		factcheck.Prices, err = factcheck.LoadPrices(cfg.PricesFile)
+		if err != nil {
+			return fmt.Errorf("loading prices: %w", err)
+		}
+	}
+	factcheck.ReportUsage = cfg.ReportUsage
+	return nil
+}
+
+// Logs the error and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
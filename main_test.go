@@ -0,0 +1,766 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"false-fact-server/factcheck"
+)
+
+const (
+	articleResponse = `{"reasoning": {"factual": ["Landing date matches NASA records [1]."], "unfactual": [], "subjective": [], "objective": ["Neutral event reporting."]}, "credibilityScore": 95, "categories": {"factuality": 97, "objectivity": 92}, "confidence": 90, "sources": ["[1](https://www.nasa.gov/mission/apollo-11/)"]}`
+	shortResponse   = `{"verdict": "fact", "reason": "Water boils at 100°C at sea level [1].", "confidence": 97, "sources": ["[1](https://en.wikipedia.org/wiki/Boiling_point)"]}`
+)
+
+// Replays canned responses for the given prompts through the handlers
+type replayCase struct {
+	kind     factcheck.PromptKind
+	data     factcheck.PromptData
+	response string
+	err      *factcheck.FixtureError
+	// Model and token usage reported with the response
+	model string
+	usage *factcheck.Usage
+	// Image sent with the prompts
+	image *factcheck.Image
+}
+
+func setupReplay(t *testing.T, cases ...replayCase) {
+	t.Helper()
+	dir := t.TempDir()
+	prompts, err := factcheck.LoadPrompts("")
+	if err != nil {
+		t.Fatalf("loading prompts: %v", err)
+	}
+
+	for _, c := range cases {
+		prompt, err := prompts.Get(c.kind)
+		if err != nil {
+			t.Fatal(err)
+		}
+		system, user, err := prompt.Render(c.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fixture := factcheck.Fixture{
+			Provider:     "test",
+			SystemPrompt: system,
+			UserPrompt:   user,
+			Response:     c.response,
+			Model:        c.model,
+			Usage:        c.usage,
+			Error:        c.err,
+		}
+		if c.image != nil {
+			fixture.ImageSHA256 = c.image.SHA256()
+		}
+		err = factcheck.WriteFixture(dir, fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	experiments, err := factcheck.LoadExperiments("", factcheck.Variant{
+		Name:     "default",
+		Provider: &factcheck.ReplayProvider{Dir: dir},
+		Prompts:  prompts,
+	}, factcheck.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	current.Store(&runtimeState{
+		cfg:         &Config{Model: "replay", ReplayDir: dir},
+		experiments: experiments,
+		health:      &factcheck.HealthChecker{Providers: experiments.Providers()},
+	})
+}
+
+type handlerTest struct {
+	name   string
+	method string
+	body   string
+	// Content-Type of body, if not JSON
+	contentType string
+	fixture     *replayCase
+	wantStatus  int
+	// Checks the decoded response envelope
+	check func(t *testing.T, resp APIResponse)
+}
+
+func runHandlerTests(t *testing.T, handler http.HandlerFunc, path string, tests []handlerTest) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.fixture != nil {
+				setupReplay(t, *tt.fixture)
+			} else {
+				setupReplay(t)
+			}
+
+			req := httptest.NewRequest(tt.method, path, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			var resp APIResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("response is not JSON: %v (%s)", err, rec.Body.String())
+			}
+			if resp.Success != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("success = %v for status %d", resp.Success, rec.Code)
+			}
+			if tt.check != nil {
+				tt.check(t, resp)
+			}
+		})
+	}
+}
+
+// Shared failure cases; the content is always "some text"
+func failureTests(kind factcheck.PromptKind, data factcheck.PromptData, body string) []handlerTest {
+	return []handlerTest{
+		{
+			name:       "wrong method",
+			method:     http.MethodGet,
+			wantStatus: http.StatusMethodNotAllowed,
+			check:      wantError(factcheck.MethodNotAllowed),
+		},
+		{
+			name:       "bad json",
+			method:     http.MethodPost,
+			body:       `{"content": `,
+			wantStatus: http.StatusBadRequest,
+			check:      wantError(factcheck.InvalidRequest),
+		},
+		{
+			name:       "body too large",
+			method:     http.MethodPost,
+			body:       `{"content": "` + strings.Repeat("a", factcheck.MaxContentLength*13) + `"}`,
+			wantStatus: http.StatusRequestEntityTooLarge,
+			check:      wantFields(":too_large"),
+		},
+		{
+			name:       "upstream error",
+			method:     http.MethodPost,
+			body:       body,
+			fixture:    &replayCase{kind: kind, data: data, err: &factcheck.FixtureError{Type: factcheck.RateLimited, Message: "API rate limit exceeded", Retryable: true}},
+			wantStatus: http.StatusTooManyRequests,
+			check:      wantError(factcheck.RateLimited),
+		},
+		{
+			name:       "malformed model output",
+			method:     http.MethodPost,
+			body:       body,
+			fixture:    &replayCase{kind: kind, data: data, response: "I think this text is mostly true."},
+			wantStatus: http.StatusBadGateway,
+			check:      wantError(factcheck.InvalidResponse),
+		},
+		{
+			name:       "missing fixture",
+			method:     http.MethodPost,
+			body:       body,
+			wantStatus: http.StatusServiceUnavailable,
+			check:      wantError(factcheck.ApiUnavailable),
+		},
+	}
+}
+
+// Checks the error type, and that the envelope is complete
+func wantError(typ factcheck.AnalysisErrorType) func(t *testing.T, resp APIResponse) {
+	return func(t *testing.T, resp APIResponse) {
+		t.Helper()
+		if resp.Error == nil {
+			t.Fatalf("no error, want %s", typ)
+		}
+		if resp.Error.Type != typ {
+			t.Errorf("error type = %s, want %s", resp.Error.Type, typ)
+		}
+		if resp.Error.Message == "" || resp.Error.Timestamp.IsZero() {
+			t.Errorf("incomplete error %+v", resp.Error)
+		}
+	}
+}
+
+// Checks for an INVALID_REQUEST error, or PAYLOAD_TOO_LARGE for a too_large
+// problem, with problems given as field:code
+func wantFields(want ...string) func(t *testing.T, resp APIResponse) {
+	return func(t *testing.T, resp APIResponse) {
+		t.Helper()
+		typ := factcheck.InvalidRequest
+		for _, problem := range want {
+			if strings.HasSuffix(problem, ":"+factcheck.FieldTooLarge) {
+				typ = factcheck.PayloadTooLarge
+			}
+		}
+		wantError(typ)(t, resp)
+		if resp.Error == nil {
+			return
+		}
+		got := []string{}
+		for _, f := range resp.Error.Fields {
+			got = append(got, f.Field+":"+f.Code)
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("fields = %v, want %v", got, want)
+		}
+	}
+}
+
+// Re-decodes the response data into v
+func decodeData(t *testing.T, resp APIResponse, v interface{}) {
+	t.Helper()
+	data, err := json.Marshal(resp.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("decoding data: %v", err)
+	}
+}
+
+func TestAnalyzeArticleHandler(t *testing.T) {
+	lastEdited := time.Date(2025, 7, 25, 18, 5, 27, 0, time.UTC)
+	data := factcheck.PromptData{Content: "some text", Title: "Apollo 11 lands", URL: "https://example.com/apollo", LastEdited: lastEdited}
+	body := `{"content": "some text", "title": "Apollo 11 lands", "url": "https://example.com/apollo", "last_edited": "2025-07-25T18:05:27Z"}`
+
+	tests := append([]handlerTest{
+		{
+			name:       "success",
+			method:     http.MethodPost,
+			body:       body,
+			fixture:    &replayCase{kind: factcheck.PromptArticle, data: data, response: articleResponse},
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, resp APIResponse) {
+				var result factcheck.AnalysisResponse
+				decodeData(t, resp, &result)
+				if result.CredibilityScore != 95 || result.Confidence != 90 {
+					t.Errorf("scores = %d/%d, want 95/90", result.CredibilityScore, result.Confidence)
+				}
+				if len(result.Sources) != 1 || result.PromptVersion == "" || result.Variant != "default" {
+					t.Errorf("unexpected result %+v", result)
+				}
+			},
+		},
+	}, failureTests(factcheck.PromptArticle, data, body)...)
+
+	runHandlerTests(t, analyzeArticleHandler, "/analyze/article", tests)
+}
+
+func TestAnalyzeShortTextHandler(t *testing.T) {
+	data := factcheck.PromptData{Content: "some text"}
+	body := `{"content": "some text"}`
+
+	tests := append([]handlerTest{
+		{
+			name:       "success",
+			method:     http.MethodPost,
+			body:       body,
+			fixture:    &replayCase{kind: factcheck.PromptTextShort, data: data, response: shortResponse},
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, resp APIResponse) {
+				var result factcheck.ShortAnalysisResponse
+				decodeData(t, resp, &result)
+				if result.Verdict != factcheck.VerdictFact || result.Analysis.Fact == nil || result.Confidence != 97 {
+					t.Errorf("unexpected result %+v", result)
+				}
+			},
+		},
+		{
+			name:       "fenced model output",
+			method:     http.MethodPost,
+			body:       body,
+			fixture:    &replayCase{kind: factcheck.PromptTextShort, data: data, response: "Here you go:\n" + shortResponse + "\nHope this helps"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "multiple conclusions",
+			method:     http.MethodPost,
+			body:       body,
+			fixture:    &replayCase{kind: factcheck.PromptTextShort, data: data, response: `{"analysis": {"fact": ["a"], "false": ["b"]}, "confidence": 50, "sources": []}`},
+			wantStatus: http.StatusBadGateway,
+			check:      wantError(factcheck.InvalidResponse),
+		},
+	}, failureTests(factcheck.PromptTextShort, data, body)...)
+
+	runHandlerTests(t, analyzeShortTextHandler, "/analyze/text/short", tests)
+}
+
+func TestAnalyzeLongTextHandler(t *testing.T) {
+	data := factcheck.PromptData{Content: "some text"}
+	body := `{"content": "some text"}`
+
+	tests := append([]handlerTest{
+		{
+			name:       "success",
+			method:     http.MethodPost,
+			body:       body,
+			fixture:    &replayCase{kind: factcheck.PromptTextLong, data: data, response: articleResponse},
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, resp APIResponse) {
+				var result factcheck.AnalysisResponse
+				decodeData(t, resp, &result)
+				if result.Categories.Factuality != 97 || result.Categories.Objectivity != 92 {
+					t.Errorf("unexpected categories %+v", result.Categories)
+				}
+			},
+		},
+		{
+			name:       "score out of range",
+			method:     http.MethodPost,
+			body:       body,
+			fixture:    &replayCase{kind: factcheck.PromptTextLong, data: data, response: strings.Replace(articleResponse, `"credibilityScore": 95`, `"credibilityScore": 150`, 1)},
+			wantStatus: http.StatusBadGateway,
+			check:      wantError(factcheck.InvalidResponse),
+		},
+	}, failureTests(factcheck.PromptTextLong, data, body)...)
+
+	runHandlerTests(t, analyzeLongTextHandler, "/analyze/text/long", tests)
+}
+
+// A multipart form with the given fields and image file, and its Content-Type
+func imageForm(t *testing.T, fields map[string]string, image []byte) (string, string) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		form.WriteField(name, value)
+	}
+	if image != nil {
+		file, err := form.CreateFormFile("image", "screenshot.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Write(image)
+	}
+	form.Close()
+	return body.String(), form.FormDataContentType()
+}
+
+func TestAnalyzeImageHandler(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	image, err := factcheck.NewImage(png)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(png)
+	data := factcheck.PromptData{}
+	response := strings.Replace(shortResponse, "{", `{"claims": ["Water boils at 100°C"], `, 1)
+	short := &replayCase{kind: factcheck.PromptImageShort, data: data, response: response, image: &image}
+	form, formType := imageForm(t, map[string]string{"responseLanguage": "en"}, png)
+	longForm, longFormType := imageForm(t, map[string]string{"mode": "long"}, png)
+	textForm, textFormType := imageForm(t, nil, []byte("not an image"))
+	bigForm, bigFormType := imageForm(t, nil, bytes.Repeat(png, factcheck.MaxImageBytes/len(png)*2))
+	checkShort := func(t *testing.T, resp APIResponse) {
+		var result factcheck.ShortAnalysisResponse
+		decodeData(t, resp, &result)
+		if result.Verdict != factcheck.VerdictFact || len(result.Claims) != 1 || result.PromptVersion != "image-short-v1" {
+			t.Errorf("unexpected result %+v", result)
+		}
+	}
+
+	tests := []handlerTest{
+		{
+			name:       "base64",
+			method:     http.MethodPost,
+			body:       `{"image": "` + encoded + `"}`,
+			fixture:    short,
+			wantStatus: http.StatusOK,
+			check:      checkShort,
+		},
+		{
+			name:       "data url",
+			method:     http.MethodPost,
+			body:       `{"image": "data:image/png;base64,` + encoded + `", "mode": "short"}`,
+			fixture:    short,
+			wantStatus: http.StatusOK,
+			check:      checkShort,
+		},
+		{
+			name:        "multipart",
+			method:      http.MethodPost,
+			body:        form,
+			contentType: formType,
+			fixture:     &replayCase{kind: factcheck.PromptImageShort, data: factcheck.PromptData{ResponseLanguage: "English"}, response: response, image: &image},
+			wantStatus:  http.StatusOK,
+			check: func(t *testing.T, resp APIResponse) {
+				checkShort(t, resp)
+				var result factcheck.ShortAnalysisResponse
+				decodeData(t, resp, &result)
+				if result.ResponseLanguage != "en" {
+					t.Errorf("response language = %q, want en", result.ResponseLanguage)
+				}
+			},
+		},
+		{
+			name:        "long",
+			method:      http.MethodPost,
+			body:        longForm,
+			contentType: longFormType,
+			fixture:     &replayCase{kind: factcheck.PromptImageLong, data: data, response: articleResponse, image: &image},
+			wantStatus:  http.StatusOK,
+			check: func(t *testing.T, resp APIResponse) {
+				var result factcheck.AnalysisResponse
+				decodeData(t, resp, &result)
+				if result.CredibilityScore != 95 || result.PromptVersion != "image-long-v1" {
+					t.Errorf("unexpected result %+v", result)
+				}
+			},
+		},
+		{
+			name:       "wrong method",
+			method:     http.MethodGet,
+			wantStatus: http.StatusMethodNotAllowed,
+			check:      wantError(factcheck.MethodNotAllowed),
+		},
+		{
+			name:       "not base64",
+			method:     http.MethodPost,
+			body:       `{"image": "not base64!"}`,
+			wantStatus: http.StatusBadRequest,
+			check:      wantFields("image:invalid_value"),
+		},
+		{
+			name:        "not an image",
+			method:      http.MethodPost,
+			body:        textForm,
+			contentType: textFormType,
+			wantStatus:  http.StatusBadRequest,
+			check:       wantFields("image:unsupported_type"),
+		},
+		{
+			name:       "no image",
+			method:     http.MethodPost,
+			body:       `{"mode": "long"}`,
+			wantStatus: http.StatusBadRequest,
+			check:      wantFields("image:required"),
+		},
+		{
+			name:       "bad mode",
+			method:     http.MethodPost,
+			body:       `{"image": "` + encoded + `", "mode": "medium"}`,
+			wantStatus: http.StatusBadRequest,
+			check:      wantFields("mode:invalid_value"),
+		},
+		{
+			name:       "body too large",
+			method:     http.MethodPost,
+			body:       `{"image": "` + strings.Repeat("A", factcheck.MaxImageBytes*2) + `"}`,
+			wantStatus: http.StatusRequestEntityTooLarge,
+			check:      wantFields(":too_large"),
+		},
+		{
+			name:        "form too large",
+			method:      http.MethodPost,
+			body:        bigForm,
+			contentType: bigFormType,
+			wantStatus:  http.StatusRequestEntityTooLarge,
+			check:       wantFields(":too_large"),
+		},
+		{
+			name:        "truncated form",
+			method:      http.MethodPost,
+			body:        textForm[:len(textForm)-20],
+			contentType: textFormType,
+			wantStatus:  http.StatusBadRequest,
+			check: func(t *testing.T, resp APIResponse) {
+				wantFields(":malformed_body")(t, resp)
+				// The multipart error stays in the logs
+				if resp.Error != nil && strings.Contains(resp.Error.Message+resp.Error.UserMessage, "EOF") {
+					t.Errorf("raw error leaked: %+v", resp.Error)
+				}
+			},
+		},
+		{
+			name:       "no fixture",
+			method:     http.MethodPost,
+			body:       `{"image": "` + encoded + `"}`,
+			wantStatus: http.StatusServiceUnavailable,
+			check:      wantError(factcheck.ApiUnavailable),
+		},
+	}
+	runHandlerTests(t, analyzeImageHandler, "/analyze/image", tests)
+
+	t.Run("image too large", func(t *testing.T) {
+		setupReplay(t)
+		defer func(max int) { factcheck.MaxImageBytes = max }(factcheck.MaxImageBytes)
+		factcheck.MaxImageBytes = len(png) - 1
+		rec := httptest.NewRecorder()
+		analyzeImageHandler(rec, httptest.NewRequest(http.MethodPost, "/analyze/image", strings.NewReader(`{"image": "`+encoded+`"}`)))
+		var resp APIResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("status = %d, want 413 (%s)", rec.Code, rec.Body.String())
+		}
+		wantFields("image:too_large")(t, resp)
+	})
+
+	t.Run("provider without vision", func(t *testing.T) {
+		setupReplay(t)
+		prompts, _ := factcheck.LoadPrompts("")
+		state := *current.Load()
+		var err error
+		state.experiments, err = factcheck.LoadExperiments("", factcheck.Variant{Name: "default", Provider: factcheck.Pollinations, Prompts: prompts}, factcheck.NewRegistry())
+		if err != nil {
+			t.Fatal(err)
+		}
+		current.Store(&state)
+
+		rec := httptest.NewRecorder()
+		analyzeImageHandler(rec, httptest.NewRequest(http.MethodPost, "/analyze/image", strings.NewReader(`{"image": "`+encoded+`"}`)))
+		if rec.Code != http.StatusNotImplemented {
+			t.Fatalf("status = %d, want 501 (%s)", rec.Code, rec.Body.String())
+		}
+		var resp APIResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		wantError(factcheck.CapabilityUnsupported)(t, resp)
+		if resp.Error.Retryable || resp.Error.UserMessage == "" {
+			t.Errorf("unexpected error %+v", resp.Error)
+		}
+	})
+}
+
+func TestAnalyzeLanguages(t *testing.T) {
+	german := "Der Bundestag hat heute ein neues Gesetz zur Förderung erneuerbarer Energien beschlossen, das ab dem nächsten Jahr gilt."
+	tests := []struct {
+		name         string
+		body         string
+		data         factcheck.PromptData
+		wantDetected string
+		wantResponse string
+	}{
+		{"detected", `{"content": "` + german + `"}`, factcheck.PromptData{Content: german, Language: "German", ResponseLanguage: "German"}, "de", "de"},
+		{"response language", `{"content": "` + german + `", "responseLanguage": "en"}`, factcheck.PromptData{Content: german, Language: "German", ResponseLanguage: "English"}, "de", "en"},
+		{"undetected", `{"content": "some text", "language": "es"}`, factcheck.PromptData{Content: "some text", Language: "Spanish", ResponseLanguage: "Spanish"}, "", "es"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupReplay(t, replayCase{kind: factcheck.PromptTextShort, data: tt.data, response: shortResponse})
+			rec := httptest.NewRecorder()
+			analyzeShortTextHandler(rec, httptest.NewRequest(http.MethodPost, "/analyze/text/short", strings.NewReader(tt.body)))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d (%s)", rec.Code, rec.Body.String())
+			}
+			var resp APIResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			var result factcheck.ShortAnalysisResponse
+			decodeData(t, resp, &result)
+			if result.DetectedLanguage != tt.wantDetected || result.ResponseLanguage != tt.wantResponse {
+				t.Errorf("languages = %q/%q, want %q/%q", result.DetectedLanguage, result.ResponseLanguage, tt.wantDetected, tt.wantResponse)
+			}
+		})
+	}
+}
+
+func TestRequestValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		strict  bool
+		body    string
+		want    []factcheck.FieldError
+	}{
+		{"empty body", analyzeShortTextHandler, false, ``, []factcheck.FieldError{{Code: factcheck.FieldRequired}}},
+		{"empty content", analyzeShortTextHandler, false, `{"content": ""}`, []factcheck.FieldError{{Field: "content", Code: factcheck.FieldRequired}}},
+		{"wrong type", analyzeLongTextHandler, false, `{"content": 42}`, []factcheck.FieldError{{Field: "content", Code: factcheck.FieldInvalidType}}},
+		{"missing title", analyzeArticleHandler, false, `{"content": "some text", "url": "https://example.com"}`, []factcheck.FieldError{{Field: "title", Code: factcheck.FieldRequired}}},
+		{"bad url and date", analyzeArticleHandler, false, `{"content": "some text", "title": "t", "url": "example.com", "last_edited": "2999-01-01T00:00:00Z"}`,
+			[]factcheck.FieldError{{Field: "url", Code: factcheck.FieldInvalidURL}, {Field: "last_edited", Code: factcheck.FieldInFuture}}},
+		{"unknown language", analyzeLongTextHandler, false, `{"content": "some text", "responseLanguage": "klingon"}`, []factcheck.FieldError{{Field: "responseLanguage", Code: factcheck.FieldInvalidValue}}},
+		{"bad date", analyzeArticleHandler, false, `{"content": "some text", "title": "t", "last_edited": "yesterday"}`, []factcheck.FieldError{{Field: "last_edited", Code: factcheck.FieldInvalidValue}}},
+		{"unknown field in strict mode", analyzeShortTextHandler, true, `{"content": "some text", "contnet": "typo"}`, []factcheck.FieldError{{Field: "contnet", Code: factcheck.FieldUnknown}}},
+		{"trailing data in strict mode", analyzeShortTextHandler, true, `{"content": "some text"} {}`, []factcheck.FieldError{{Code: factcheck.FieldInvalidJSON}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupReplay(t)
+			state := *current.Load()
+			cfg := *state.cfg
+			cfg.StrictRequests = tt.strict
+			state.cfg = &cfg
+			current.Store(&state)
+
+			rec := httptest.NewRecorder()
+			tt.handler(rec, httptest.NewRequest(http.MethodPost, "/analyze", strings.NewReader(tt.body)))
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400 (%s)", rec.Code, rec.Body.String())
+			}
+			var resp APIResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			wantError(factcheck.InvalidRequest)(t, resp)
+			if len(resp.Error.Fields) != len(tt.want) {
+				t.Fatalf("fields = %+v, want %+v", resp.Error.Fields, tt.want)
+			}
+			for i, f := range resp.Error.Fields {
+				if f.Field != tt.want[i].Field || f.Code != tt.want[i].Code || f.Message == "" {
+					t.Errorf("field %d = %+v, want %+v", i, f, tt.want[i])
+				}
+			}
+		})
+	}
+
+	// Unknown fields are ignored unless strict
+	setupReplay(t, replayCase{kind: factcheck.PromptTextShort, data: factcheck.PromptData{Content: "some text"}, response: shortResponse})
+	rec := httptest.NewRecorder()
+	analyzeShortTextHandler(rec, httptest.NewRequest(http.MethodPost, "/analyze", strings.NewReader(`{"content": "some text", "extra": 1}`)))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200 (%s)", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	handler := instrument(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = factcheck.RequestID(r.Context())
+	}))
+
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{"generated", "", false},
+		{"from client", "client-req_42", true},
+		{"invalid from client", "bad id\nwith newline", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/health", nil)
+			if tt.incoming != "" {
+				req.Header.Set("X-Request-ID", tt.incoming)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			id := rec.Header().Get("X-Request-ID")
+			if id == "" || id != seen {
+				t.Fatalf("header %q, context %q", id, seen)
+			}
+			if (id == tt.incoming) != tt.keep {
+				t.Errorf("request ID %q for incoming %q", id, tt.incoming)
+			}
+		})
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	data := factcheck.PromptData{Content: "some text"}
+	setupReplay(t, replayCase{kind: factcheck.PromptTextShort, data: data, response: shortResponse})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/analyze/text/short", analyzeShortTextHandler)
+	mux.Handle("/metrics", factcheck.MetricsHandler())
+	server := instrument(mux)
+
+	req := httptest.NewRequest(http.MethodPost, "/analyze/text/short", strings.NewReader(`{"content": "some text"}`))
+	server.ServeHTTP(httptest.NewRecorder(), req)
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`falsefact_http_requests_total{endpoint="/analyze/text/short",method="POST",status="200"} 1`,
+		`falsefact_http_request_duration_seconds_count{endpoint="/analyze/text/short",method="POST",status="200"} 1`,
+		`falsefact_http_requests_in_flight 1`,
+		`falsefact_provider_request_duration_seconds_count{provider="replay"}`,
+		`falsefact_confidence_count{kind="text_short",variant="default"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics do not contain %s", want)
+		}
+	}
+}
+
+func TestDailyBudget(t *testing.T) {
+	data := factcheck.PromptData{Content: "some text"}
+	// $0.30 per request at the default gemini-2.5-flash price
+	setupReplay(t, replayCase{kind: factcheck.PromptTextShort, data: data, response: shortResponse,
+		model: "gemini-2.5-flash", usage: &factcheck.Usage{PromptTokens: 1_000_000, TotalTokens: 1_000_000}})
+	state := *current.Load()
+	state.budgets = factcheck.BudgetLimits{Keys: map[string]float64{"team-key": 0.5}}
+	current.Store(&state)
+	factcheck.Budgets = factcheck.NewBudgetTracker()
+	factcheck.ReportUsage = true
+	defer func() { factcheck.Budgets, factcheck.ReportUsage = nil, false }()
+
+	analyze := func(apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/analyze/text/short", strings.NewReader(`{"content": "some text"}`))
+		req.Header.Set("X-API-Key", apiKey)
+		rec := httptest.NewRecorder()
+		analyzeShortTextHandler(rec, req)
+		return rec
+	}
+
+	// The second request starts under the limit and may overshoot it
+	for i := range 2 {
+		rec := analyze("team-key")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d (%s)", i+1, rec.Code, rec.Body.String())
+		}
+		var resp APIResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		var result factcheck.ShortAnalysisResponse
+		decodeData(t, resp, &result)
+		if result.Usage == nil || result.Usage.Calls != 1 || result.Usage.CostUSD != 0.3 {
+			t.Errorf("usage = %+v, want one call costing $0.30", result.Usage)
+		}
+	}
+
+	rec := analyze("team-key")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("status = %d, Retry-After %q; want 429 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	var resp APIResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	wantError(factcheck.BudgetExceeded)(t, resp)
+
+	// Keys without a limit are not affected
+	if rec := analyze("other-key"); rec.Code != http.StatusOK {
+		t.Errorf("other key: status = %d", rec.Code)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	setupReplay(t)
+
+	rec := httptest.NewRecorder()
+	readyHandler(rec, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d (%s)", rec.Code, rec.Body.String())
+	}
+	var resp APIResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	var data struct {
+		Status       StatusType
+		Dependencies []factcheck.DependencyStatus
+		Build        BuildInfo
+	}
+	decodeData(t, resp, &data)
+	if data.Status != StatusReady || len(data.Dependencies) != 1 || data.Dependencies[0].Name != "replay" {
+		t.Errorf("unexpected readiness %+v", data)
+	}
+	if data.Build.Version != "dev" || len(data.Build.Providers) != 1 || data.Build.Prompts[factcheck.PromptTextShort] == "" {
+		t.Errorf("unexpected build info %+v", data.Build)
+	}
+
+	// The replay provider is not ready without its fixtures directory
+	state := *current.Load()
+	state.health = &factcheck.HealthChecker{Providers: []factcheck.Provider{&factcheck.ReplayProvider{Dir: "/does/not/exist"}}}
+	current.Store(&state)
+	rec = httptest.NewRecorder()
+	readyHandler(rec, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), `"not_ready"`) {
+		t.Errorf("status = %d (%s), want 503 not_ready", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"false-fact-server/factcheck"
+)
+
+// Prefix of the current API version; the unprefixed paths are kept as aliases
+const apiPrefix = "/v1"
+
+// An endpoint, registered under apiPrefix and as an alias, and described in /openapi.json
+type route struct {
+	method  string
+	path    string
+	summary string
+	// Zero value of the JSON request body, nil for none
+	request any
+	// Fields of request that can also be sent as files of a multipart form
+	uploads []string
+	// Zero value of the data of a successful APIResponse
+	response any
+	// Another shape the data can have, e.g. for a mode of the request
+	alternative any
+	// Set for responses that are not an APIResponse
+	contentType string
+	// Status codes the endpoint returns besides 200
+	statuses []int
+	// Served at path only, e.g. /metrics
+	unversioned bool
+	handler     http.Handler
+}
+
+// Statuses of the analyze endpoints besides 200, see errorStatuses
+var analyzeStatuses = []int{
+	http.StatusBadRequest, http.StatusMethodNotAllowed, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity, http.StatusTooManyRequests,
+	http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable,
+}
+
+func apiRoutes() []route {
+	return []route{
+		{
+			method:   http.MethodPost,
+			path:     "/analyze/article",
+			summary:  "Analyze the credibility of an article",
+			request:  factcheck.AnalyzeArticleRequest{},
+			response: factcheck.AnalysisResponse{},
+			statuses: analyzeStatuses,
+			handler:  withCORS(analyzeArticleHandler),
+		},
+		{
+			method:   http.MethodPost,
+			path:     "/analyze/text/short",
+			summary:  "Give a verdict on a short text",
+			request:  factcheck.AnalyzeTextRequest{},
+			response: factcheck.ShortAnalysisResponse{},
+			statuses: analyzeStatuses,
+			handler:  withCORS(analyzeShortTextHandler),
+		},
+		{
+			method:   http.MethodPost,
+			path:     "/analyze/text/long",
+			summary:  "Analyze the credibility of a long text",
+			request:  factcheck.AnalyzeTextRequest{},
+			response: factcheck.AnalysisResponse{},
+			statuses: analyzeStatuses,
+			handler:  withCORS(analyzeLongTextHandler),
+		},
+		{
+			method:      http.MethodPost,
+			path:        "/analyze/image",
+			summary:     "Extract the claims in an image and give a short verdict or, with mode long, a full analysis",
+			request:     factcheck.AnalyzeImageRequest{},
+			uploads:     []string{"image"},
+			response:    factcheck.ShortAnalysisResponse{},
+			alternative: factcheck.AnalysisResponse{},
+			statuses:    append(slices.Clone(analyzeStatuses), http.StatusNotImplemented),
+			handler:     withCORS(analyzeImageHandler),
+		},
+		{
+			method:   http.MethodGet,
+			path:     "/health",
+			summary:  "Health check",
+			response: HealthData{},
+			handler:  withCORS(healthHandler),
+		},
+		{
+			method:   http.MethodGet,
+			path:     "/health/live",
+			summary:  "Liveness: the process is serving",
+			response: LiveData{},
+			handler:  withCORS(liveHandler),
+		},
+		{
+			method:   http.MethodGet,
+			path:     "/health/ready",
+			summary:  "Readiness of every provider, with build info; 503 when not ready",
+			response: ReadyData{},
+			statuses: []int{http.StatusServiceUnavailable},
+			handler:  withCORS(readyHandler),
+		},
+		{
+			method:   http.MethodGet,
+			path:     "/experiments",
+			summary:  "Per-variant statistics of the running experiments",
+			response: ExperimentsData{},
+			statuses: []int{http.StatusMethodNotAllowed},
+			handler:  withCORS(experimentsHandler),
+		},
+		{
+			method:      http.MethodGet,
+			path:        "/metrics",
+			summary:     "Prometheus metrics",
+			contentType: "text/plain",
+			unversioned: true,
+			handler:     factcheck.MetricsHandler(),
+		},
+		{
+			method:      http.MethodGet,
+			path:        "/openapi.json",
+			summary:     "This OpenAPI document",
+			contentType: "application/json",
+			unversioned: true,
+			handler:     withCORS(openAPIHandler),
+		},
+	}
+}
+
+// Registers every route under apiPrefix and as an alias, and 404s for everything else
+func registerRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/", withCORS(rootHandler))
+	for _, rt := range apiRoutes() {
+		mux.Handle(rt.path, rt.handler)
+		if !rt.unversioned {
+			mux.Handle(apiPrefix+rt.path, rt.handler)
+		}
+	}
+}
+
+// Method and path of every endpoint, for the startup log
+func endpointNames() []string {
+	names := []string{}
+	for _, rt := range apiRoutes() {
+		path := rt.path
+		if !rt.unversioned {
+			path = apiPrefix + path
+		}
+		names = append(names, rt.method+" "+path)
+	}
+	return names
+}
+
+// /openapi.json endpoint handler
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(openAPIDocument(apiRoutes()))
+}
+
+// The OpenAPI 3 document of routes, with schemas generated from the Go types
+func openAPIDocument(routes []route) map[string]any {
+	g := &schemaGenerator{schemas: map[string]any{}}
+	paths := map[string]any{}
+	for _, rt := range routes {
+		if rt.unversioned {
+			paths[rt.path] = map[string]any{strings.ToLower(rt.method): g.operation(rt, false)}
+			continue
+		}
+		paths[apiPrefix+rt.path] = map[string]any{strings.ToLower(rt.method): g.operation(rt, false)}
+		paths[rt.path] = map[string]any{strings.ToLower(rt.method): g.operation(rt, true)}
+	}
+	g.schema(reflect.TypeFor[factcheck.ExtensionError]())
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "false-fact-server",
+			"description": "Fact-checking API of the False Fact extension. Paths without " + apiPrefix + " are deprecated aliases.",
+			"version":     version,
+		},
+		"paths":      paths,
+		"components": map[string]any{"schemas": g.schemas},
+	}
+}
+
+// Builds component schemas from Go types, following their json tags
+type schemaGenerator struct {
+	schemas map[string]any
+}
+
+// Values of the string types that are enums
+var enumValues = map[reflect.Type][]string{
+	reflect.TypeFor[factcheck.Verdict](): {
+		string(factcheck.VerdictFact), string(factcheck.VerdictFalse), string(factcheck.VerdictOpinion), string(factcheck.VerdictNone),
+	},
+	reflect.TypeFor[factcheck.AnalysisErrorType](): {
+		string(factcheck.RateLimited), string(factcheck.ApiUnavailable), string(factcheck.InvalidContent), string(factcheck.NetworkError), string(factcheck.BudgetExceeded),
+		string(factcheck.InvalidResponse), string(factcheck.InvalidRequest), string(factcheck.MethodNotAllowed), string(factcheck.NotFound), string(factcheck.InternalError),
+		string(factcheck.CapabilityUnsupported), string(factcheck.PayloadTooLarge),
+	},
+	reflect.TypeFor[factcheck.PromptKind](): {
+		string(factcheck.PromptArticle), string(factcheck.PromptTextLong), string(factcheck.PromptTextShort), string(factcheck.PromptJSONFix),
+		string(factcheck.PromptImageLong), string(factcheck.PromptImageShort),
+	},
+	reflect.TypeFor[StatusType](): {
+		string(StatusHealthy), string(StatusSuccess), string(StatusError), string(StatusOnline), string(StatusReady), string(StatusNotReady),
+	},
+}
+
+func (g *schemaGenerator) operation(rt route, deprecated bool) map[string]any {
+	op := map[string]any{"summary": rt.summary}
+	if deprecated {
+		op["deprecated"] = true
+		op["description"] = "Alias of " + apiPrefix + rt.path
+	}
+	if rt.request != nil {
+		content := map[string]any{"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(rt.request))}}
+		if len(rt.uploads) > 0 {
+			// The same fields as a form, with the uploads as files instead of base64
+			form := g.object(reflect.TypeOf(rt.request))
+			properties := form["properties"].(map[string]any)
+			for _, name := range rt.uploads {
+				properties[name] = map[string]any{"type": "string", "format": "binary"}
+			}
+			content["multipart/form-data"] = map[string]any{"schema": form}
+		}
+		op["requestBody"] = map[string]any{"required": true, "content": content}
+	}
+
+	var content map[string]any
+	switch rt.contentType {
+	case "text/plain":
+		content = map[string]any{rt.contentType: map[string]any{"schema": map[string]any{"type": "string"}}}
+	case "application/json":
+		content = map[string]any{rt.contentType: map[string]any{"schema": map[string]any{"type": "object"}}}
+	default:
+		// APIResponse with the route's data, or an error
+		data := g.schema(reflect.TypeOf(rt.response))
+		if rt.alternative != nil {
+			data = map[string]any{"oneOf": []any{data, g.schema(reflect.TypeOf(rt.alternative))}}
+		}
+		content = map[string]any{"application/json": map[string]any{"schema": map[string]any{
+			"type":     "object",
+			"required": []string{"success"},
+			"properties": map[string]any{
+				"success": map[string]any{"type": "boolean"},
+				"data":    data,
+				"error":   g.schema(reflect.TypeFor[APIError]()),
+			},
+		}}}
+	}
+	responses := map[string]any{"200": map[string]any{"description": "OK", "content": content}}
+	for _, status := range rt.statuses {
+		responses[strconv.Itoa(status)] = map[string]any{"description": http.StatusText(status), "content": content}
+	}
+	op["responses"] = responses
+	return op
+}
+
+// The schema of t; named structs are added to the components and referenced
+func (g *schemaGenerator) schema(t reflect.Type) map[string]any {
+	if t == reflect.TypeFor[time.Time]() {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	if values, ok := enumValues[t]; ok {
+		return map[string]any{"type": "string", "enum": values}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return nullable(g.schema(t.Elem()))
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		if _, ok := g.schemas[t.Name()]; !ok {
+			// Reserve the name first in case the type refers to itself
+			g.schemas[t.Name()] = nil
+			g.schemas[t.Name()] = g.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem()), "minItems": t.Len(), "maxItems": t.Len()}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int32:
+		return map[string]any{"type": "integer"}
+	case reflect.Int64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Interface:
+		return map[string]any{}
+	}
+	panic(fmt.Sprintf("openapi: no schema for %s", t))
+}
+
+// An object with the exported fields of t; fields without omitempty are required.
+// Slices and maps without omitempty can be null, as encoding/json writes nil ones.
+func (g *schemaGenerator) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s := g.schema(f.Type)
+		if !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+			if f.Type.Kind() == reflect.Slice || f.Type.Kind() == reflect.Map {
+				s = nullable(s)
+			}
+		}
+		properties[name] = s
+	}
+	object := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		object["required"] = required
+	}
+	return object
+}
+
+func nullable(s map[string]any) map[string]any {
+	if _, ok := s["$ref"]; ok {
+		return map[string]any{"allOf": []any{s}, "nullable": true}
+	}
+	n := map[string]any{"nullable": true}
+	for k, v := range s {
+		n[k] = v
+	}
+	return n
+}
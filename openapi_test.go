@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"false-fact-server/factcheck"
+)
+
+// The generated document as decoded JSON, the way clients see it
+func openAPISpecJSON(t *testing.T) map[string]any {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	openAPIHandler(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	var spec map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("/openapi.json is not JSON: %v", err)
+	}
+	return spec
+}
+
+// Checks value against an OpenAPI schema. Properties the schema doesn't describe are
+// reported too, so that the document can't fall behind the types.
+func checkSchema(spec map[string]any, schema map[string]any, value any, at string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		resolved, ok := spec["components"].(map[string]any)["schemas"].(map[string]any)[name].(map[string]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: unresolved %s", at, ref)}
+		}
+		return checkSchema(spec, resolved, value, at)
+	}
+	if value == nil {
+		if schema["nullable"] == true {
+			return nil
+		}
+		return []string{at + ": null is not allowed"}
+	}
+	if all, ok := schema["allOf"].([]any); ok {
+		problems := []string{}
+		for _, s := range all {
+			problems = append(problems, checkSchema(spec, s.(map[string]any), value, at)...)
+		}
+		return problems
+	}
+	if one, ok := schema["oneOf"].([]any); ok {
+		matches := 0
+		for _, s := range one {
+			if len(checkSchema(spec, s.(map[string]any), value, at)) == 0 {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return []string{fmt.Sprintf("%s: %v matches %d of the oneOf schemas", at, value, matches)}
+		}
+		return nil
+	}
+
+	problems := []string{}
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: %v is not an object", at, value)}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := object[name.(string)]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: missing required %s", at, name))
+			}
+		}
+		for name, v := range object {
+			if s, ok := properties[name].(map[string]any); ok {
+				problems = append(problems, checkSchema(spec, s, v, at+"."+name)...)
+			} else if s, ok := schema["additionalProperties"].(map[string]any); ok {
+				problems = append(problems, checkSchema(spec, s, v, at+"."+name)...)
+			} else if properties != nil {
+				problems = append(problems, fmt.Sprintf("%s: undocumented property %s", at, name))
+			}
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: %v is not an array", at, value)}
+		}
+		if n, ok := schema["maxItems"].(float64); ok && len(items) > int(n) {
+			problems = append(problems, fmt.Sprintf("%s: more than %v items", at, n))
+		}
+		for i, item := range items {
+			problems = append(problems, checkSchema(spec, schema["items"].(map[string]any), item, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return []string{fmt.Sprintf("%s: %v is not a string", at, value)}
+		}
+		if enum, ok := schema["enum"].([]any); ok && !slices.Contains(enum, any(s)) {
+			problems = append(problems, fmt.Sprintf("%s: %q is not one of %v", at, s, enum))
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %q is not a date-time", at, s))
+			}
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != float64(int64(n)) {
+			problems = append(problems, fmt.Sprintf("%s: %v is not an integer", at, value))
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			problems = append(problems, fmt.Sprintf("%s: %v is not a number", at, value))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			problems = append(problems, fmt.Sprintf("%s: %v is not a boolean", at, value))
+		}
+	}
+	return problems
+}
+
+// Checks that the status is documented for the operation and the body matches its schema
+func checkResponse(t *testing.T, spec map[string]any, method string, path string, rec *httptest.ResponseRecorder) {
+	t.Helper()
+	op, ok := spec["paths"].(map[string]any)[path].(map[string]any)[strings.ToLower(method)].(map[string]any)
+	if !ok {
+		t.Fatalf("%s %s is not documented", method, path)
+	}
+	response, ok := op["responses"].(map[string]any)[strconv.Itoa(rec.Code)].(map[string]any)
+	if !ok {
+		t.Fatalf("%s %s: status %d is not documented", method, path, rec.Code)
+	}
+	content := response["content"].(map[string]any)
+	media, ok := content["application/json"].(map[string]any)
+	if !ok {
+		return
+	}
+	var body any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%s %s: body is not JSON: %s", method, path, rec.Body.String())
+	}
+	for _, problem := range checkSchema(spec, media["schema"].(map[string]any), body, "body") {
+		t.Errorf("%s %s (%d): %s", method, path, rec.Code, problem)
+	}
+}
+
+func TestOpenAPIContract(t *testing.T) {
+	spec := openAPISpecJSON(t)
+	if spec["openapi"] != "3.0.3" {
+		t.Fatalf("openapi = %v", spec["openapi"])
+	}
+	schemas := spec["components"].(map[string]any)["schemas"].(map[string]any)
+	for _, name := range []string{"AnalysisResponse", "ShortAnalysisResponse", "AnalyzeArticleRequest", "AnalyzeImageRequest", "ExtensionError", "ReadyData"} {
+		if schemas[name] == nil {
+			t.Errorf("schema %s is missing", name)
+		}
+	}
+
+	articleData := factcheck.PromptData{Content: "Apollo 11 landed on the Moon in 1969.", Title: "Moon landing", URL: "https://example.com/apollo", Language: "English", ResponseLanguage: "English"}
+	articleBody := `{"content": "Apollo 11 landed on the Moon in 1969.", "title": "Moon landing", "url": "https://example.com/apollo", "last_edited": "0001-01-01T00:00:00Z"}`
+	textData := factcheck.PromptData{Content: "some text"}
+	failing := factcheck.PromptData{Content: "upstream fails"}
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	image, _ := factcheck.NewImage(png)
+	imageBody := `{"image": "` + base64.StdEncoding.EncodeToString(png) + `"}`
+	setupReplay(t,
+		replayCase{kind: factcheck.PromptImageShort, response: shortResponse, image: &image},
+		replayCase{kind: factcheck.PromptImageLong, response: articleResponse, image: &image},
+		replayCase{kind: factcheck.PromptArticle, data: articleData, response: articleResponse},
+		replayCase{kind: factcheck.PromptTextShort, data: textData, response: shortResponse},
+		replayCase{kind: factcheck.PromptTextLong, data: textData, response: articleResponse},
+		replayCase{kind: factcheck.PromptTextShort, data: failing, err: &factcheck.FixtureError{Type: factcheck.ApiUnavailable, Message: "down", Retryable: true}},
+	)
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodPost, "/analyze/article", articleBody, http.StatusOK},
+		{http.MethodPost, "/analyze/text/short", `{"content": "some text"}`, http.StatusOK},
+		{http.MethodPost, "/analyze/text/long", `{"content": "some text"}`, http.StatusOK},
+		{http.MethodPost, "/analyze/text/short", `{"content": "upstream fails"}`, http.StatusServiceUnavailable},
+		{http.MethodPost, "/analyze/text/short", `not json`, http.StatusBadRequest},
+		{http.MethodGet, "/analyze/text/long", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/analyze/image", imageBody, http.StatusOK},
+		{http.MethodPost, "/analyze/image", strings.Replace(imageBody, "{", `{"mode": "long", `, 1), http.StatusOK},
+		{http.MethodPost, "/analyze/image", `{"image": "bm90IGFuIGltYWdl"}`, http.StatusBadRequest},
+		{http.MethodPost, "/analyze/image", `{"image": "` + strings.Repeat("A", factcheck.MaxImageBytes*2) + `"}`, http.StatusRequestEntityTooLarge},
+		{http.MethodGet, "/health", "", http.StatusOK},
+		{http.MethodGet, "/health/live", "", http.StatusOK},
+		{http.MethodGet, "/health/ready", "", http.StatusOK},
+		{http.MethodGet, "/experiments", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		for _, path := range []string{apiPrefix + tt.path, tt.path} {
+			t.Run(tt.method+" "+path, func(t *testing.T) {
+				// 405 is documented on the method the endpoint has
+				documented := tt.method
+				if tt.status == http.StatusMethodNotAllowed {
+					documented = http.MethodPost
+				}
+				// Request examples must match the documented request bodies
+				op := spec["paths"].(map[string]any)[path].(map[string]any)[strings.ToLower(documented)].(map[string]any)
+				if requestBody, ok := op["requestBody"].(map[string]any); ok && tt.status == http.StatusOK {
+					schema := requestBody["content"].(map[string]any)["application/json"].(map[string]any)["schema"].(map[string]any)
+					var body any
+					json.Unmarshal([]byte(tt.body), &body)
+					for _, problem := range checkSchema(spec, schema, body, "request") {
+						t.Error(problem)
+					}
+				}
+
+				rec := httptest.NewRecorder()
+				mux.ServeHTTP(rec, httptest.NewRequest(tt.method, path, strings.NewReader(tt.body)))
+				if rec.Code != tt.status {
+					t.Fatalf("status = %d, want %d (%s)", rec.Code, tt.status, rec.Body.String())
+				}
+				checkResponse(t, spec, documented, path, rec)
+			})
+		}
+	}
+
+	// Every error type that has a status is in the published enum
+	for typ := range errorStatuses {
+		if !slices.Contains(enumValues[reflect.TypeFor[factcheck.AnalysisErrorType]()], string(typ)) {
+			t.Errorf("error type %s is missing from the enum", typ)
+		}
+	}
+
+	// Image upload as a form, which is documented besides JSON
+	form, formType := imageForm(t, map[string]string{"mode": "long"}, png)
+	op := spec["paths"].(map[string]any)[apiPrefix+"/analyze/image"].(map[string]any)["post"].(map[string]any)
+	formSchema := op["requestBody"].(map[string]any)["content"].(map[string]any)["multipart/form-data"].(map[string]any)["schema"].(map[string]any)
+	if formSchema["properties"].(map[string]any)["image"].(map[string]any)["format"] != "binary" {
+		t.Errorf("image is not a file in the form schema %v", formSchema)
+	}
+	req := httptest.NewRequest(http.MethodPost, apiPrefix+"/analyze/image", strings.NewReader(form))
+	req.Header.Set("Content-Type", formType)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 (%s)", rec.Code, rec.Body.String())
+	}
+	checkResponse(t, spec, http.MethodPost, apiPrefix+"/analyze/image", rec)
+
+	// A daily budget that is used up
+	state := *current.Load()
+	state.budgets = factcheck.BudgetLimits{Keys: map[string]float64{"spent-key": 0.01}}
+	current.Store(&state)
+	factcheck.Budgets = factcheck.NewBudgetTracker()
+	defer func() { factcheck.Budgets = nil }()
+	factcheck.Budgets.Charge(state.budgets, "spent-key", 1)
+	req = httptest.NewRequest(http.MethodPost, apiPrefix+"/analyze/text/short", strings.NewReader(`{"content": "some text"}`))
+	req.Header.Set("X-API-Key", "spent-key")
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", rec.Code)
+	}
+	checkResponse(t, spec, http.MethodPost, apiPrefix+"/analyze/text/short", rec)
+
+	// Not ready
+	state = *current.Load()
+	state.health = &factcheck.HealthChecker{Providers: []factcheck.Provider{&factcheck.ReplayProvider{Dir: "/does/not/exist"}}}
+	current.Store(&state)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, apiPrefix+"/health/ready", nil))
+	checkResponse(t, spec, http.MethodGet, apiPrefix+"/health/ready", rec)
+
+	// Unversioned endpoints
+	for _, path := range []string{"/metrics", "/openapi.json"} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status = %d", path, rec.Code)
+		}
+		checkResponse(t, spec, http.MethodGet, path, rec)
+	}
+}
+
+// Types that serialize fields the document would miss must fail the contract check
+func TestCheckSchemaRejectsUndocumentedFields(t *testing.T) {
+	spec := openAPISpecJSON(t)
+	schema := map[string]any{"$ref": "#/components/schemas/LiveData"}
+	good := map[string]any{"status": "online", "timestamp": time.Now().Format(time.RFC3339)}
+	if problems := checkSchema(spec, schema, good, "body"); len(problems) > 0 {
+		t.Errorf("valid body rejected: %v", problems)
+	}
+	bad := map[string]any{"status": "sleeping", "extra": 1}
+	if problems := checkSchema(spec, schema, bad, "body"); len(problems) != 3 {
+		t.Errorf("expected a bad enum, a missing timestamp and an undocumented property, got %v", problems)
+	}
+}
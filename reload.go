@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"false-fact-server/factcheck"
+)
+
+// What requests use that a reload replaces: the providers, prompts, experiments,
+// readiness checks and budget limits built from one Config. It is never modified once
+// stored.
+type runtimeState struct {
+	cfg         *Config
+	experiments *factcheck.Experiments
+	health      *factcheck.HealthChecker
+	// Checked against the spend in factcheck.Budgets
+	budgets factcheck.BudgetLimits
+}
+
+// The current runtime state. Handlers load it once, so a request finishes with the
+// providers and prompts it started with even if a reload happens meanwhile.
+var current atomic.Pointer[runtimeState]
+
+// Builds the runtime state for cfg. Nothing in use is changed, so on error the caller
+// can keep the current state. The providers are looked up in a registry of this build
+// only, and reach requests through the experiments once the state is installed.
+func buildRuntime(cfg *Config) (*runtimeState, error) {
+	providers := factcheck.NewRegistry(factcheck.NewGeminiProvider(cfg.GeminiAPIKey))
+	// Fixtures recorded with RECORD_DIR can be replayed with MODEL=replay
+	if cfg.ReplayDir != "" {
+		providers.Register(&factcheck.ReplayProvider{Dir: cfg.ReplayDir})
+	}
+
+	// Model selection
+	selectedProvider, err := providers.ByName(cfg.Model)
+	if err != nil {
+		return nil, fmt.Errorf("MODEL: %w", err)
+	}
+	if cfg.RecordDir != "" {
+		selectedProvider = &factcheck.RecordingProvider{Inner: selectedProvider, Dir: cfg.RecordDir}
+	}
+
+	// Prompt templates, optionally overridden from a directory
+	prompts, err := factcheck.LoadPrompts(cfg.PromptsDir)
+	if err != nil {
+		return nil, fmt.Errorf("loading prompts: %w", err)
+	}
+
+	// Optional ensemble of several providers or several samples of MODEL
+	var ensemble *factcheck.Ensemble
+	if cfg.EnsembleProviders != "" || cfg.EnsembleSamples > 0 {
+		ensemble, err = factcheck.NewEnsemble(providers, strings.Split(cfg.EnsembleProviders, ","), cfg.EnsembleSamples, cfg.EnsembleAggregate, selectedProvider)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ensemble: %w", err)
+		}
+	}
+
+	// Optional A/B experiments between providers and prompts
+	experiments, err := factcheck.LoadExperiments(cfg.ExperimentsFile, factcheck.Variant{
+		Name:     "default",
+		Provider: selectedProvider,
+		Prompts:  prompts,
+		Ensemble: ensemble,
+	}, providers)
+	if err != nil {
+		return nil, fmt.Errorf("loading experiments: %w", err)
+	}
+
+	// Per-API-key daily limits
+	var limits map[string]float64
+	if cfg.BudgetsFile != "" {
+		limits, err = factcheck.LoadBudgetLimits(cfg.BudgetsFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading budgets: %w", err)
+		}
+	}
+
+	// Readiness checks provider configuration, and probes the upstream APIs with HEALTH_PROBE=true
+	health := &factcheck.HealthChecker{Providers: experiments.Providers()}
+	if cfg.HealthProbe {
+		health.ProbeInterval = cfg.HealthProbeInterval
+		health.ProbeTimeout = cfg.HealthProbeTimeout
+	}
+
+	budgets := factcheck.BudgetLimits{Default: cfg.DailyBudgetUSD, Keys: limits}
+	return &runtimeState{cfg: cfg, experiments: experiments, health: health, budgets: budgets}, nil
+}
+
+// Makes state the one new requests use
+func installRuntime(state *runtimeState) {
+	current.Store(state)
+
+	variant := state.experiments.Default
+	args := []any{"provider", variant.Provider.Name(), "prompts", variant.Prompts.Versions()}
+	if variant.Ensemble != nil {
+		args = append(args, "ensemble", variant.Ensemble.String(), "aggregate", variant.Ensemble.Aggregate)
+	}
+	if state.cfg.RecordDir != "" {
+		args = append(args, "recordDir", state.cfg.RecordDir)
+	}
+	if state.budgets.Default > 0 || len(state.budgets.Keys) > 0 {
+		args = append(args, "dailyBudgetUsd", state.budgets.Default, "budgetKeys", len(state.budgets.Keys))
+	}
+	slog.Info("runtime configuration installed", args...)
+}
+
+// Reloads the configuration from the same sources as at startup and installs it.
+// A configuration that doesn't load, validate or build is logged and rejected,
+// and the current one stays in use.
+type reloader struct {
+	args      []string
+	lookupEnv func(string) (string, bool)
+	envFile   string
+
+	// Serializes reloads from SIGHUP and the file watch
+	mu sync.Mutex
+	// Config, .env and referenced files as of the last reload, for the file watch
+	files map[string]time.Time
+}
+
+func (r *reloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old := current.Load()
+	cfg, opts, err := LoadConfig(r.args, r.lookupEnv, r.envFile)
+	var state *runtimeState
+	if err == nil {
+		state, err = buildRuntime(cfg)
+	}
+	if err != nil {
+		factcheck.ObserveConfigReload(false)
+		slog.Error("configuration reload rejected, keeping the current configuration", "error", err)
+		return err
+	}
+
+	if old != nil {
+		if changed := cfg.restartRequired(old.cfg); len(changed) > 0 {
+			slog.Warn("changed settings take effect after a restart", "settings", changed)
+		}
+	}
+	installRuntime(state)
+	r.files = watchedFiles(cfg, opts.ConfigFile, r.envFile)
+	factcheck.ObserveConfigReload(true)
+	slog.Info("configuration reloaded", "file", opts.ConfigFile, "envFile", opts.EnvFile)
+	return nil
+}
+
+// Reloads whenever a signal arrives on signals, until ctx is done
+func (r *reloader) handleSignals(ctx context.Context, signals <-chan os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-signals:
+			slog.Info("reloading configuration", "signal", sig.String())
+			r.reload()
+		}
+	}
+}
+
+// Checks the watched files every interval and reloads when one was changed, added
+// or removed, until ctx is done
+func (r *reloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.mu.Lock()
+			scan := filesOf(current.Load().cfg, r.files)
+			changed := !sameFiles(r.files, scan)
+			// A rejected reload is not retried until the files change again
+			r.files = scan
+			r.mu.Unlock()
+			if changed {
+				slog.Info("reloading configuration", "reason", "files changed")
+				r.reload()
+			}
+		}
+	}
+}
+
+// The config file, .env, and the prompt, experiment and budget files of cfg with
+// their modification times
+func watchedFiles(cfg *Config, configFile string, envFile string) map[string]time.Time {
+	paths := map[string]time.Time{}
+	for _, path := range []string{configFile, envFile} {
+		if path != "" {
+			paths[path] = time.Time{}
+		}
+	}
+	return filesOf(cfg, paths)
+}
+
+// Modification times of the files in previous and those cfg refers to; missing files
+// have the zero time
+func filesOf(cfg *Config, previous map[string]time.Time) map[string]time.Time {
+	files := map[string]time.Time{}
+	stat := func(path string) {
+		if info, err := os.Stat(path); err == nil {
+			files[path] = info.ModTime()
+		} else {
+			files[path] = time.Time{}
+		}
+	}
+	for path := range previous {
+		stat(path)
+	}
+	for _, path := range []string{cfg.ExperimentsFile, cfg.BudgetsFile} {
+		if path != "" {
+			stat(path)
+		}
+	}
+	if cfg.PromptsDir != "" {
+		filepath.WalkDir(cfg.PromptsDir, func(path string, d os.DirEntry, err error) error {
+			if err == nil {
+				stat(path)
+			}
+			return nil
+		})
+	}
+	return files
+}
+
+func sameFiles(a map[string]time.Time, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, modTime := range a {
+		if other, ok := b[path]; !ok || !other.Equal(modTime) {
+			return false
+		}
+	}
+	return true
+}
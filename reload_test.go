@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"false-fact-server/factcheck"
+)
+
+// A reloader reading a YAML config that replays fixtures with prompt overrides
+func setupReload(t *testing.T) (r *reloader, configFile string, promptFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	promptsDir := filepath.Join(dir, "prompts")
+	replayDir := filepath.Join(dir, "fixtures")
+	for _, d := range []string{promptsDir, replayDir} {
+		if err := os.Mkdir(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	promptFile = writePromptVersion(t, promptsDir, "reload-v1")
+	configFile = writeFile(t, dir, "config.yaml", "port: \"8080\"\nmodel: replay\nreplayDir: "+replayDir+"\npromptsDir: "+promptsDir+"\ndailyBudgetUsd: 1\n")
+
+	factcheck.Budgets = factcheck.NewBudgetTracker()
+	t.Cleanup(func() { factcheck.Budgets = nil })
+	return &reloader{args: []string{"-config", configFile}, lookupEnv: fakeEnv(nil), envFile: filepath.Join(dir, ".env")}, configFile, promptFile
+}
+
+// Writes a text_short prompt override with the given version
+func writePromptVersion(t *testing.T, dir string, version string) string {
+	t.Helper()
+	embedded, err := os.ReadFile("factcheck/prompts/text_short.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := strings.Replace(string(embedded), "text-short-v3", version, 1)
+	return writeFile(t, dir, "text_short.tmpl", content)
+}
+
+func promptVersion(state *runtimeState) string {
+	return state.experiments.Default.Prompts.Versions()[factcheck.PromptTextShort]
+}
+
+func TestReload(t *testing.T) {
+	r, configFile, promptFile := setupReload(t)
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	before := current.Load()
+	if promptVersion(before) != "reload-v1" || before.budgets.Limit("any-key") != 1 {
+		t.Fatalf("prompt %s, budget %v", promptVersion(before), before.budgets.Limit("any-key"))
+	}
+
+	writePromptVersion(t, filepath.Dir(promptFile), "reload-v2")
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if promptVersion(current.Load()) != "reload-v2" {
+		t.Errorf("prompt %s after reload, want reload-v2", promptVersion(current.Load()))
+	}
+	// Requests that loaded the old state keep its prompts
+	if promptVersion(before) != "reload-v1" {
+		t.Errorf("old state changed to %s", promptVersion(before))
+	}
+
+	// A broken template is rejected and the current state stays in use
+	applied := current.Load()
+	writeFile(t, filepath.Dir(promptFile), "text_short.tmpl", `{{define "version"}}broken{{end}}`)
+	if err := r.reload(); err == nil {
+		t.Error("expected the broken prompt to be rejected")
+	}
+	// So is an invalid config file
+	writePromptVersion(t, filepath.Dir(promptFile), "reload-v3")
+	writeFile(t, filepath.Dir(configFile), "config.yaml", "port: \"8080\"\nmodel: nonexistent\n")
+	if err := r.reload(); err == nil {
+		t.Error("expected the unknown model to be rejected")
+	}
+	if current.Load() != applied || current.Load().budgets.Limit("any-key") != 1 {
+		t.Error("a rejected reload replaced the runtime state")
+	}
+}
+
+func TestRejectedReloadKeepsProviders(t *testing.T) {
+	r, configFile, promptFile := setupReload(t)
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	replayDir := current.Load().experiments.Default.Provider.(*factcheck.ReplayProvider).Dir
+
+	// A new replay dir and Gemini key, with a prompt that fails to load after them
+	writeFile(t, filepath.Dir(promptFile), "text_short.tmpl", `{{define "version"}}broken{{end}}`)
+	writeFile(t, filepath.Dir(configFile), "config.yaml", "port: \"8080\"\nmodel: replay\nreplayDir: "+t.TempDir()+"\npromptsDir: "+filepath.Dir(promptFile)+"\ngeminiApiKey: new-key\n")
+	if err := r.reload(); err == nil {
+		t.Fatal("expected the broken prompt to be rejected")
+	}
+	if got := current.Load().experiments.Default.Provider.(*factcheck.ReplayProvider).Dir; got != replayDir {
+		t.Errorf("replay dir = %s after a rejected reload, want %s", got, replayDir)
+	}
+	// Nothing was registered globally either
+	if _, err := factcheck.ProviderByName("replay"); err == nil {
+		t.Error("a reload registered the replay provider globally")
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	old := defaultConfig()
+	cfg := old
+	cfg.Port, cfg.Model, cfg.LogLevel, cfg.PromptsDir = "9090", "gemini", "debug", "/prompts"
+	if changed := cfg.restartRequired(&old); !slices.Equal(changed, []string{"PORT", "LOG_LEVEL"}) {
+		t.Errorf("restart required for %v, want PORT and LOG_LEVEL", changed)
+	}
+}
+
+func TestReloadWatch(t *testing.T) {
+	r, configFile, promptFile := setupReload(t)
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.files[configFile]; !ok {
+		t.Fatalf("config file is not watched: %v", r.files)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.watch(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	writePromptVersion(t, filepath.Dir(promptFile), "reload-v2")
+	// Some filesystems only keep whole seconds
+	later := time.Now().Add(2 * time.Second)
+	os.Chtimes(promptFile, later, later)
+	deadline := time.Now().Add(5 * time.Second)
+	for promptVersion(current.Load()) != "reload-v2" {
+		if time.Now().After(deadline) {
+			t.Fatal("the changed prompt was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Set once shutdown starts; /health/ready then reports not ready
+var draining atomic.Bool
+
+// Timeouts of the HTTP server and of its shutdown
+type serverConfig struct {
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	// Analyses can take a while, so this must cover the slowest provider call
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	// How long the server keeps serving while reporting not ready, so load balancers stop
+	// sending traffic before the listener closes
+	DrainDelay time.Duration
+	// How long in-flight requests get to finish before they are cancelled
+	ShutdownTimeout time.Duration
+}
+
+func newServer(handler http.Handler, cfg serverConfig) *http.Server {
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+		Protocols:         new(http.Protocols),
+	}
+	// HTTP/2 is only negotiated over TLS
+	srv.Protocols.SetHTTP1(true)
+	srv.Protocols.SetHTTP2(true)
+	return srv
+}
+
+// Serves TLS with the certificate from certs instead of plain HTTP
+func enableTLS(srv *http.Server, certs *certReloader) {
+	srv.TLSConfig = &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+	}
+}
+
+// Serves until ctx is done, then drains: readiness turns not ready, new connections are
+// refused after DrainDelay, and in-flight requests get ShutdownTimeout to finish before
+// their connections are closed. Returns nil after a clean shutdown.
+func serve(ctx context.Context, srv *http.Server, ln net.Listener, cfg serverConfig) error {
+	errs := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			errs <- srv.ServeTLS(ln, "", "")
+		} else {
+			errs <- srv.Serve(ln)
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	draining.Store(true)
+	slog.Info("shutting down, draining requests", "drainDelay", cfg.DrainDelay, "timeout", cfg.ShutdownTimeout)
+	time.Sleep(cfg.DrainDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		// Cancels the requests that are still running, including their provider calls
+		slog.Warn("requests still running at the shutdown deadline, closing their connections", "error", err)
+		srv.Close()
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	slog.Info("server stopped")
+	return nil
+}
+
+// Serves a certificate and key pair, reloading it when either file changes so renewed
+// certificates (e.g. from certbot) are picked up without a restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+	// Newest modification time of the two files when they were last loaded
+	modTime time.Time
+}
+
+// Loads the pair; unlike a reload, a failure here is an error
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := c.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+// Loads the pair if either file changed since the last load. On failure the previous
+// certificate stays in use; a half-written renewal is retried on the next check.
+func (c *certReloader) reloadIfChanged() (bool, error) {
+	modTime := time.Time{}
+	for _, name := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return false, err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	if modTime.Equal(c.modTime) {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return false, err
+	}
+	c.cert.Store(&cert)
+	c.modTime = modTime
+	return true, nil
+}
+
+// Checks the files every interval until ctx is done
+func (c *certReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := c.reloadIfChanged()
+			if err != nil {
+				slog.Warn("failed to reload TLS certificate, keeping the current one", "cert", c.certFile, "error", err)
+			} else if reloaded {
+				slog.Info("reloaded TLS certificate", "cert", c.certFile)
+			}
+		}
+	}
+}
+
+// Redirects every request to the same path over HTTPS on publicHost and httpsPort. The
+// client's Host header is not used, so the redirect can't send anyone elsewhere. GET and
+// HEAD get a 301; other methods a 308, which keeps the method and body.
+func redirectToHTTPS(publicHost string, httpsPort string) http.Handler {
+	host := publicHost
+	if httpsPort != "443" {
+		host = net.JoinHostPort(strings.Trim(publicHost, "[]"), httpsPort)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			status = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+	})
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Serves handler on a local port until the returned cancel function is called
+func startServer(t *testing.T, handler http.Handler, cfg serverConfig) (string, context.CancelFunc, chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- serve(ctx, newServer(handler, cfg), ln, cfg)
+	}()
+	t.Cleanup(func() { draining.Store(false) })
+	return "http://" + ln.Addr().String(), cancel, done
+}
+
+func TestShutdownWaitsForInFlightRequests(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})
+	url, cancel, done := startServer(t, handler, serverConfig{ShutdownTimeout: 5 * time.Second})
+
+	result := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			result <- 0
+			return
+		}
+		resp.Body.Close()
+		result <- resp.StatusCode
+	}()
+	<-started
+	cancel()
+
+	// Readiness turns not ready while the request is still running
+	time.Sleep(50 * time.Millisecond)
+	setupReplay(t)
+	rec := httptest.NewRecorder()
+	readyHandler(rec, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("readiness while draining = %d, want 503", rec.Code)
+	}
+
+	close(release)
+	if status := <-result; status != http.StatusOK {
+		t.Errorf("in-flight request got status %d, want 200", status)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("serve returned %v", err)
+	}
+	if _, err := http.Get(url); err == nil {
+		t.Error("expected new connections to be refused after shutdown")
+	}
+}
+
+func TestShutdownCancelsRequestsAtDeadline(t *testing.T) {
+	started, cancelled := make(chan struct{}), make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+		close(cancelled)
+	})
+	url, cancel, done := startServer(t, handler, serverConfig{ShutdownTimeout: 100 * time.Millisecond})
+
+	go http.Get(url)
+	<-started
+	cancel()
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request context was not cancelled at the shutdown deadline")
+	}
+	if err := <-done; err != nil {
+		t.Errorf("serve returned %v", err)
+	}
+}
+
+// Writes a self-signed certificate for 127.0.0.1 with the given common name
+func writeCert(t *testing.T, certFile string, keyFile string, commonName string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+}
+
+// The common name of the certificate the server presents
+func servedCommonName(t *testing.T, client *http.Client, url string) (string, int) {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.TLS.PeerCertificates[0].Subject.CommonName, resp.ProtoMajor
+}
+
+func TestTLSWithHTTP2AndCertReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, "first")
+	certs, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := serverConfig{ShutdownTimeout: time.Second}
+	srv := newServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), cfg)
+	enableTLS(srv, certs)
+	go serve(ctx, srv, ln, cfg)
+	t.Cleanup(func() { draining.Store(false) })
+
+	url := "https://" + ln.Addr().String()
+	newClient := func() *http.Client {
+		return &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2: true,
+		}}
+	}
+	if name, proto := servedCommonName(t, newClient(), url); name != "first" || proto != 2 {
+		t.Errorf("served %q over HTTP/%d, want first over HTTP/2", name, proto)
+	}
+
+	// A renewal only takes effect once the files have changed
+	if reloaded, err := certs.reloadIfChanged(); reloaded || err != nil {
+		t.Errorf("reloaded unchanged files: %v, %v", reloaded, err)
+	}
+	writeCert(t, certFile, keyFile, "renewed")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	if reloaded, err := certs.reloadIfChanged(); !reloaded || err != nil {
+		t.Fatalf("renewal not reloaded: %v, %v", reloaded, err)
+	}
+	if name, _ := servedCommonName(t, newClient(), url); name != "renewed" {
+		t.Errorf("served %q after renewal, want renewed", name)
+	}
+
+	// A broken renewal keeps the working certificate
+	os.WriteFile(keyFile, []byte("half written"), 0o600)
+	os.Chtimes(keyFile, later.Add(time.Minute), later.Add(time.Minute))
+	if _, err := certs.reloadIfChanged(); err == nil {
+		t.Error("expected an error for a broken key")
+	}
+	if name, _ := servedCommonName(t, newClient(), url); name != "renewed" {
+		t.Errorf("served %q after a failed reload, want renewed", name)
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	for _, tt := range []struct {
+		method, host, port, want string
+		status                   int
+	}{
+		{http.MethodGet, "example.com", "443", "https://example.com/health?x=1", http.StatusMovedPermanently},
+		{http.MethodGet, "example.com:8080", "8443", "https://example.com:8443/health?x=1", http.StatusMovedPermanently},
+		{http.MethodPost, "example.com", "443", "https://example.com/health?x=1", http.StatusPermanentRedirect},
+		// The Host header of the request is ignored
+		{http.MethodGet, "evil.example", "443", "https://example.com/health?x=1", http.StatusMovedPermanently},
+	} {
+		req := httptest.NewRequest(tt.method, "http://"+tt.host+"/health?x=1", nil)
+		rec := httptest.NewRecorder()
+		redirectToHTTPS("example.com", tt.port).ServeHTTP(rec, req)
+		if rec.Code != tt.status || rec.Header().Get("Location") != tt.want {
+			t.Errorf("%s %s: %d to %s, want %d to %s", tt.method, tt.host, rec.Code, rec.Header().Get("Location"), tt.status, tt.want)
+		}
+	}
+	rec := httptest.NewRecorder()
+	redirectToHTTPS("[::1]", "8443").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := rec.Header().Get("Location"); got != "https://[::1]:8443/" {
+		t.Errorf("IPv6 redirect to %s", got)
+	}
+}